@@ -3,15 +3,19 @@ package hash
 import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"micro/balance"
+	"micro/observability"
 )
 
 type Balancer struct {
 	connections []balancer.SubConn
 	length int
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if b.length == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	// grpc 没有为负载均衡提供很好的设计, 较难获取对应的数据做哈希负载均衡
@@ -20,6 +24,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	//idx := info.Ctx.Value("user_id")
 	//idx := info.Ctx.Value("hash_code")
 
+	b.metrics.Picked(b.connections[0])
 	return balancer.PickResult{
 		SubConn: b.connections[0],
 		Done: func(info balancer.DoneInfo) {
@@ -29,7 +34,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 }
 
 type Builder struct {
-	
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -40,6 +45,15 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 	return &Balancer{
 		connections: connections,
 		length: len(connections),
+		metrics: balance.NewMetrics("hash", b.Sink, info),
 	}
 }
 
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	targets := make([]string, 0, len(b.connections))
+	for _, c := range b.connections {
+		targets = append(targets, b.metrics.Target(c))
+	}
+	return map[string]any{"targets": targets}
+}
@@ -3,15 +3,19 @@ package hash
 import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"micro/balance"
+	"micro/observability"
 )
 
 type ConsistentBalancer struct {
 	connections []balancer.SubConn
 	length int
+	metrics *balance.Metrics
 }
 
 func (b *ConsistentBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if b.length == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	
@@ -23,6 +27,7 @@ func (b *ConsistentBalancer) Pick(info balancer.PickInfo) (balancer.PickResult,
 	//idx := info.Ctx.Value("user_id")
 	//idx := info.Ctx.Value("hash_code")
 
+	b.metrics.Picked(b.connections[0])
 	return balancer.PickResult{
 		SubConn: b.connections[0],
 		Done: func(info balancer.DoneInfo) {
@@ -32,7 +37,7 @@ func (b *ConsistentBalancer) Pick(info balancer.PickInfo) (balancer.PickResult,
 }
 
 type ConsistentBalancerBuilder struct {
-	
+	Sink observability.Sink
 }
 
 func (b *ConsistentBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -43,6 +48,7 @@ func (b *ConsistentBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Pi
 	return &Balancer{
 		connections: connections,
 		length: len(connections),
+		metrics: balance.NewMetrics("consistent_hash", b.Sink, info),
 	}
 }
 
@@ -4,11 +4,14 @@ import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"math"
+	"micro/balance"
+	"micro/observability"
 	"sync/atomic"
 )
 
 type Balancer struct {
 	connections []*activeConn
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -20,17 +23,23 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 			res = c
 		}
 	}
+	if res.c == nil {
+		b.metrics.NoSubConn()
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
 	atomic.AddUint32(&res.cnt, 1)
+	b.metrics.Picked(res.c)
 	return balancer.PickResult{
 		SubConn: res.c,
 		Done: func(info balancer.DoneInfo) {
-			atomic.AddUint32(&res.cnt, -1)
+			// 无符号数减一
+			atomic.AddUint32(&res.cnt, ^uint32(0))
 		},
 	}, nil
 }
 
 type Builder struct {
-	
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -42,6 +51,7 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 	}
 	return &Balancer{
 		connections: conns,
+		metrics: balance.NewMetrics("least_active", b.Sink, info),
 	}
 }
 
@@ -51,4 +61,11 @@ type activeConn struct {
 	c balancer.SubConn
 }
 
-
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	res := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		res = append(res, map[string]any{"target": b.metrics.Target(c.c), "active": atomic.LoadUint32(&c.cnt)})
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+package balance
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"micro/observability"
+)
+
+// Metrics 负载均衡器上报每个节点被选中的次数, 以及没有可用节点的次数
+// 流量不均匀的时候看 picker_picks_total 按 target 分组就知道偏到哪里了
+// nil 也可以用, 什么都不上报
+type Metrics struct {
+	picker  string
+	sink    observability.Sink
+	targets map[balancer.SubConn]string
+}
+
+// NewMetrics picker 是负载均衡器的名字, 例如 round_robin
+func NewMetrics(picker string, sink observability.Sink, info base.PickerBuildInfo) *Metrics {
+	targets := make(map[balancer.SubConn]string, len(info.ReadySCs))
+	for c, ci := range info.ReadySCs {
+		targets[c] = ci.Address.Addr
+	}
+	return &Metrics{
+		picker:  picker,
+		sink:    observability.SinkOrNop(sink),
+		targets: targets,
+	}
+}
+
+// Picked 选中了 c
+func (m *Metrics) Picked(c balancer.SubConn) {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter("picker_picks_total", observability.Labels{
+		"picker": m.picker,
+		"target": m.targets[c],
+	})
+}
+
+// NoSubConn 返回 balancer.ErrNoSubConnAvailable 之前调用
+func (m *Metrics) NoSubConn() {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter("picker_no_subconn_total", observability.Labels{
+		"picker": m.picker,
+	})
+}
+
+// Target c 对应的节点地址
+func (m *Metrics) Target(c balancer.SubConn) string {
+	if m == nil {
+		return ""
+	}
+	return m.targets[c]
+}
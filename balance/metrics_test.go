@@ -0,0 +1,56 @@
+package balance
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"micro/observability"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	sc1, sc2 := &subConn{name: "1"}, &subConn{name: "2"}
+	sink := &memSink{}
+	m := NewMetrics("round_robin", sink, base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			sc1: {Address: resolver.Address{Addr: "127.0.0.1:8081"}},
+			sc2: {Address: resolver.Address{Addr: "127.0.0.1:8082"}},
+		},
+	})
+	m.Picked(sc1)
+	m.Picked(sc2)
+	m.Picked(sc1)
+	m.NoSubConn()
+	assert.Equal(t, map[string]int{
+		"picker_picks_total{picker=round_robin,target=127.0.0.1:8081}": 2,
+		"picker_picks_total{picker=round_robin,target=127.0.0.1:8082}": 1,
+		"picker_no_subconn_total{picker=round_robin}":                  1,
+	}, sink.counters)
+
+	// 直接构造的 picker 没有 Metrics, 不能 panic
+	var nilMetrics *Metrics
+	nilMetrics.Picked(sc1)
+	nilMetrics.NoSubConn()
+}
+
+type subConn struct {
+	balancer.SubConn
+	name string
+}
+
+type memSink struct {
+	observability.NopSink
+	counters map[string]int
+}
+
+func (m *memSink) IncCounter(name string, labels observability.Labels) {
+	if m.counters == nil {
+		m.counters = make(map[string]int)
+	}
+	key := name + "{picker=" + labels["picker"]
+	if target, ok := labels["target"]; ok {
+		key += ",target=" + target
+	}
+	m.counters[key+"}"]++
+}
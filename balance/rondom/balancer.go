@@ -4,18 +4,23 @@ import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"math/rand"
+	"micro/balance"
+	"micro/observability"
 )
 
 type Balancer struct {
 	connections []balancer.SubConn
 	length int
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if b.length == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	idx := rand.Intn(b.length)
+	b.metrics.Picked(b.connections[idx])
 	return balancer.PickResult{
 		SubConn: b.connections[idx],
 		Done: func(info balancer.DoneInfo) {
@@ -25,7 +30,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 }
 
 type Builder struct {
-	
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -36,7 +41,15 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 	return &Balancer{
 		connections: connections,
 		length: len(connections),
+		metrics: balance.NewMetrics("random", b.Sink, info),
 	}
 }
 
-
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	targets := make([]string, 0, len(b.connections))
+	for _, c := range b.connections {
+		targets = append(targets, b.metrics.Target(c))
+	}
+	return map[string]any{"targets": targets}
+}
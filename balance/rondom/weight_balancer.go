@@ -4,15 +4,19 @@ import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"math/rand"
+	"micro/balance"
+	"micro/observability"
 )
 
 type WeightBalancer struct {
 	connections []*weightConn
 	totalWeight uint32
+	metrics *balance.Metrics
 }
 
 func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if len(b.connections) == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	tgt := rand.Intn(int(b.totalWeight) + 1)
@@ -24,6 +28,7 @@ func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 			break
 		}
 	}
+	b.metrics.Picked(b.connections[idx].c)
 	return balancer.PickResult{
 		SubConn: b.connections[idx].c,
 		Done: func(info balancer.DoneInfo) {
@@ -33,7 +38,7 @@ func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 }
 
 type WeightBalancerBuilder struct {
-
+	Sink observability.Sink
 }
 
 func (b *WeightBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -50,6 +55,7 @@ func (b *WeightBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker
 	return &WeightBalancer{
 		connections: cs,
 		totalWeight: totalWeight,
+		metrics: balance.NewMetrics("weight_random", b.Sink, info),
 	}
 }
 
@@ -59,4 +65,11 @@ type weightConn struct {
 	weight uint32
 }
 
-
+// Inspect 调试接口展示的内部状态
+func (b *WeightBalancer) Inspect() any {
+	conns := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		conns = append(conns, map[string]any{"target": b.metrics.Target(c.c), "weight": c.weight})
+	}
+	return map[string]any{"connections": conns, "total_weight": b.totalWeight}
+}
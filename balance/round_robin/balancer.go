@@ -3,6 +3,8 @@ package round_robin
 import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"micro/balance"
+	"micro/observability"
 	"sync/atomic"
 )
 
@@ -10,15 +12,18 @@ type Balancer struct {
 	connections []balancer.SubConn
 	index int32
 	length int32
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if len(b.connections) == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	// 保证准确性, 使用原子操作读取数据
 	idx := atomic.AddInt32(&b.index, 1)
 	c := b.connections[idx]
+	b.metrics.Picked(c)
 	return balancer.PickResult{
 		SubConn: c,
 		Done: func(info balancer.DoneInfo) {
@@ -28,7 +33,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 }
 
 type Builder struct {
-	
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -40,6 +45,15 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 		connections: conns,
 		index:       -1,  // 从第一个开始轮询
 		length: int32(len(info.ReadySCs)),
+		metrics: balance.NewMetrics("round_robin", b.Sink, info),
 	}
 }
 
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	targets := make([]string, 0, len(b.connections))
+	for _, c := range b.connections {
+		targets = append(targets, b.metrics.Target(c))
+	}
+	return map[string]any{"targets": targets, "index": atomic.LoadInt32(&b.index)}
+}
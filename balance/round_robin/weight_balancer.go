@@ -4,15 +4,19 @@ import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"math"
+	"micro/balance"
+	"micro/observability"
 	"sync"
 )
 
 type WeightBalancer struct {
 	connections []*weightConn
+	metrics *balance.Metrics
 }
 
 func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if len(w.connections) == 0 {
+		w.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	var totalWeight uint32
@@ -33,6 +37,7 @@ func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 	// 根据加权轮询算法更新权重
 	res.currentWeight -= totalWeight
 	res.mutex.Unlock()
+	w.metrics.Picked(res.c)
 	return balancer.PickResult{
 		SubConn: res.c,
 		Done: func(info balancer.DoneInfo) {
@@ -55,7 +60,7 @@ func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 }
 
 type WeightBalancerBulider struct {
-	
+	Sink observability.Sink
 }
 
 func (w *WeightBalancerBulider) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -74,6 +79,7 @@ func (w *WeightBalancerBulider) Build(info base.PickerBuildInfo) balancer.Picker
 	}
 	return &WeightBalancer{
 		connections: cs,
+		metrics: balance.NewMetrics("weight_round_robin", w.Sink, info),
 	}
 }
 
@@ -83,4 +89,20 @@ type weightConn struct {
 	weight          uint32  
 	currentWeight   uint32 // 当前权重
 	efficientWeight uint32 // 有效权重
-}
\ No newline at end of file
+}
+
+// Inspect 调试接口展示的内部状态
+func (w *WeightBalancer) Inspect() any {
+	res := make([]map[string]any, 0, len(w.connections))
+	for _, c := range w.connections {
+		c.mutex.Lock()
+		res = append(res, map[string]any{
+			"target":           w.metrics.Target(c.c),
+			"weight":           c.weight,
+			"current_weight":   c.currentWeight,
+			"efficient_weight": c.efficientWeight,
+		})
+		c.mutex.Unlock()
+	}
+	return res
+}
@@ -2,11 +2,18 @@ package micro
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/credentials"
+	"micro/observability"
 	"micro/registry"
+	"net"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -14,15 +21,24 @@ type ClientOption func(c *Client)
 
 type Client struct {
 	insecure bool
+	tlsConfig *tls.Config
+	creds credentials.PerRPCCredentials
 	r registry.Registry
 	timeout time.Duration
 	// 负载均衡的 pirckerbuilder
 	balancer balancer.Builder
+	sink observability.Sink
+	logger observability.Logger
+	// 调试接口, 展示每个服务的节点, 连接和负载均衡器的状态
+	debug *debugState
+	debugAddr string
+	debugMutex sync.Mutex
+	debugServer *http.Server
 }
 
 // NewClient 可以不使用注册中心
 func NewClient(opts ...ClientOption) *Client {
-	res := &Client{}
+	res := &Client{debug: newDebugState()}
 	
 	for _, opt := range opts {
 		opt(res)
@@ -32,7 +48,10 @@ func NewClient(opts ...ClientOption) *Client {
 
 func ClientWithPickBuilder(name string, b base.PickerBuilder) ClientOption {
 	return func(c *Client) {
-		builder := base.NewBalancerBuilder(name, b, base.Config{HealthCheck: true})
+		builder := &debugBalancerBuilder{
+			Builder: base.NewBalancerBuilder(name, b, base.Config{HealthCheck: true}),
+			debug: c.debug,
+		}
 		balancer.Register(builder)
 		c.balancer = builder
 	}
@@ -44,6 +63,45 @@ func ClientInsecure() ClientOption {
 	}
 }
 
+// ClientWithTLS 使用 TLS 连接服务端, 可以使用 security.CertStore 生成配置
+// SNI 和 authority 都是服务名, 所以服务端证书的 SAN 里面要包含服务名
+func ClientWithTLS(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// ClientWithPerRPCCredentials 每次调用都带上认证信息, 例如 security.PerRPCCredentials
+func ClientWithPerRPCCredentials(creds credentials.PerRPCCredentials) ClientOption {
+	return func(c *Client) {
+		c.creds = creds
+	}
+}
+
+// ClientWithMetricsSink 上报服务发现的指标
+// 负载均衡器的指标通过 PickerBuilder 的 Sink 字段配置
+func ClientWithMetricsSink(s observability.Sink) ClientOption {
+	return func(c *Client) {
+		c.sink = s
+	}
+}
+
+// ClientWithLogger 服务发现使用的日志, 默认使用 slog.Default()
+func ClientWithLogger(l observability.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// ClientWithDebugServer 第一次 Dial 的时候在 addr 上启动 HTTP 服务, 通过 /debug/micro 查看
+// 每个服务的节点列表, 连接状态, 负载均衡器和最近的注册中心事件
+// 也可以用 DebugHandler 挂到自己的 HTTP 服务上
+func ClientWithDebugServer(addr string) ClientOption {
+	return func(c *Client) {
+		c.debugAddr = addr
+	}
+}
+
 func ClientWithRegistry(r registry.Registry, timeout time.Duration) ClientOption {
 	return func(c *Client) {
 		c.r = r
@@ -53,19 +111,29 @@ func ClientWithRegistry(r registry.Registry, timeout time.Duration) ClientOption
 
 func (c *Client) Dial(ctx context.Context, service string, 
 	dialOptions...grpc.DialOption) (*grpc.ClientConn, error) {
+	if err := c.startDebug(); err != nil {
+		return nil, err
+	}
 	var opts []grpc.DialOption
 	// 如果有注册中心, 构造 grpc 服务发现的 option
 	if c.r != nil {
 		// 拿到自定义的 resolverBuiler 
-		rb, err := NewRegistryBuilder(c.r, c.timeout)
+		rb, err := NewRegistryBuilder(c.r, c.timeout, ResolverWithSink(c.sink), ResolverWithLogger(c.logger))
 		if err != nil {
 			return nil, err
 		}
+		rb.debug = c.debug
 		opts = append(opts, grpc.WithResolvers(rb))
 	}
 	if c.insecure {
 		opts = append(opts, grpc.WithInsecure())
 	}
+	if c.tlsConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(c.tlsConfig)))
+	}
+	if c.creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(c.creds))
+	}
 	// 增加负载均衡的 grpc option
 	if c.balancer != nil {
 		opts = append(opts, grpc.WithDefaultServiceConfig(
@@ -76,4 +144,42 @@ func (c *Client) Dial(ctx context.Context, service string,
 	}
 	cc, err := grpc.DialContext(ctx, fmt.Sprintf("registry:///%s", service), opts...)
 	return cc, err
-}
\ No newline at end of file
+}
+
+// DebugHandler 调试接口, 返回 JSON
+func (c *Client) DebugHandler() http.Handler {
+	return c.debug
+}
+
+func (c *Client) startDebug() error {
+	c.debugMutex.Lock()
+	defer c.debugMutex.Unlock()
+	if c.debugAddr == "" || c.debugServer != nil {
+		return nil
+	}
+	lis, err := net.Listen("tcp", c.debugAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/micro", c.DebugHandler())
+	c.debugServer = &http.Server{Handler: mux}
+	go func(srv *http.Server) {
+		if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			observability.LoggerOrDefault(c.logger).Error("调试端口退出", "address", c.debugAddr, "error", err)
+		}
+	}(c.debugServer)
+	return nil
+}
+
+// Close 关闭调试接口, 已经 Dial 的连接由调用者自己关闭
+func (c *Client) Close() error {
+	c.debugMutex.Lock()
+	defer c.debugMutex.Unlock()
+	if c.debugServer == nil {
+		return nil
+	}
+	err := c.debugServer.Close()
+	c.debugServer = nil
+	return err
+}
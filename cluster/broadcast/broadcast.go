@@ -2,10 +2,17 @@ package broadcast
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"micro/registry"
 	"reflect"
+	"sync"
 )
 
 // grpc 不能通过 picker 实现广播与组播
@@ -27,6 +34,15 @@ func NewClusterBuilder(r registry.Registry, service string, dialOptions...grpc.D
 	}
 }
 
+// WithTLS 使用 TLS 连接每一个节点
+// 节点是直接用地址连接的, 所以要把 authority 设置为服务名, 这样 SNI 和证书校验用的都是服务名
+func (b *ClusterBuilder) WithTLS(cfg *tls.Config) *ClusterBuilder {
+	b.dialOptions = append(b.dialOptions,
+		grpc.WithTransportCredentials(credentials.NewTLS(cfg)),
+		grpc.WithAuthority(b.service))
+	return b
+}
+
 func (b ClusterBuilder) BuildUnaryInterceptor() grpc.UnaryClientInterceptor {
 	// method: users.UserService/GetByID
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, 
@@ -60,6 +76,174 @@ func (b ClusterBuilder) BuildUnaryInterceptor() grpc.UnaryClientInterceptor {
 	}
 }
 
+// BuildStreamInterceptor 广播流, 和每个节点都建立一个流
+// SendMsg 会发给所有节点, RecvMsg 依次返回所有节点的消息, 所有节点的流都结束之后返回 io.EOF
+// 某个节点出错的时候取消所有节点的流, RecvMsg 返回第一个错误
+// 所有流结束、出错或者 ctx 被取消的时候关闭连接, 所以不读到 io.EOF 的话要取消 ctx, 和普通的 grpc 流一样
+func (b ClusterBuilder) BuildStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !isBroadcast(ctx) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		instanses, err := b.registry.ListServices(ctx, b.service)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		res := &broadcastStream{ctx: ctx, cancel: cancel}
+		for _, ins := range instanses {
+			insCC, er := grpc.Dial(ins.Address, b.dialOptions...)
+			if er != nil {
+				cancel()
+				res.close()
+				return nil, er
+			}
+			res.conns = append(res.conns, insCC)
+			stream, er := streamer(ctx, desc, insCC, method, opts...)
+			if er != nil {
+				cancel()
+				res.close()
+				return nil, er
+			}
+			res.streams = append(res.streams, stream)
+		}
+		go func() {
+			<-ctx.Done()
+			res.close()
+		}()
+		return res, nil
+	}
+}
+
+type broadcastStream struct {
+	// ctx 是这个广播流自己的, 取消之后所有节点的流都会结束
+	ctx     context.Context
+	cancel  context.CancelFunc
+	streams []grpc.ClientStream
+	conns   []*grpc.ClientConn
+
+	once sync.Once
+	msgs chan broadcastMsg
+
+	errOnce sync.Once
+	err     error
+}
+
+type broadcastMsg struct {
+	msg any
+}
+
+// Header 合并所有节点的 header, 同一个 key 有多个值
+func (s *broadcastStream) Header() (metadata.MD, error) {
+	mds := make([]metadata.MD, 0, len(s.streams))
+	for _, stream := range s.streams {
+		md, err := stream.Header()
+		if err != nil {
+			return nil, err
+		}
+		mds = append(mds, md)
+	}
+	return metadata.Join(mds...), nil
+}
+
+// Trailer 合并所有节点的 trailer, 要在 RecvMsg 返回 io.EOF 或者错误之后调用
+func (s *broadcastStream) Trailer() metadata.MD {
+	mds := make([]metadata.MD, 0, len(s.streams))
+	for _, stream := range s.streams {
+		mds = append(mds, stream.Trailer())
+	}
+	return metadata.Join(mds...)
+}
+
+func (s *broadcastStream) CloseSend() error {
+	var errs []error
+	for _, stream := range s.streams {
+		errs = append(errs, stream.CloseSend())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *broadcastStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *broadcastStream) SendMsg(m any) error {
+	var errs []error
+	for _, stream := range s.streams {
+		errs = append(errs, stream.SendMsg(m))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *broadcastStream) RecvMsg(m any) error {
+	// 第一次调用才知道响应的类型
+	s.once.Do(func() {
+		s.recv(reflect.TypeOf(m).Elem())
+	})
+	res, ok := <-s.msgs
+	if !ok {
+		if s.err != nil {
+			return s.err
+		}
+		return io.EOF
+	}
+	if pm, ok := m.(proto.Message); ok {
+		proto.Reset(pm)
+		proto.Merge(pm, res.msg.(proto.Message))
+		return nil
+	}
+	reflect.ValueOf(m).Elem().Set(reflect.ValueOf(res.msg).Elem())
+	return nil
+}
+
+// recv 每个节点一个 goroutine 读取消息, 全部读完或者有一个出错之后取消 ctx
+func (s *broadcastStream) recv(typ reflect.Type) {
+	s.msgs = make(chan broadcastMsg)
+	var wg sync.WaitGroup
+	wg.Add(len(s.streams))
+	for _, stream := range s.streams {
+		go func(stream grpc.ClientStream) {
+			defer wg.Done()
+			for {
+				msg := reflect.New(typ).Interface()
+				err := stream.RecvMsg(msg)
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					s.fail(err)
+					return
+				}
+				select {
+				case s.msgs <- broadcastMsg{msg: msg}:
+				case <-s.ctx.Done():
+					return
+				}
+			}
+		}(stream)
+	}
+	go func() {
+		wg.Wait()
+		s.cancel()
+		close(s.msgs)
+	}()
+}
+
+// fail 只记录第一个错误, 然后取消其它节点的流
+func (s *broadcastStream) fail(err error) {
+	s.errOnce.Do(func() {
+		s.err = err
+		s.cancel()
+	})
+}
+
+func (s *broadcastStream) close() {
+	for _, cc := range s.conns {
+		_ = cc.Close()
+	}
+}
+
 func UseBroadcast(ctx context.Context) context.Context {
 	return context.WithValue(ctx, broadcastKey{}, true)
 }
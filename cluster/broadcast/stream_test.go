@@ -0,0 +1,132 @@
+package broadcast
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"micro/registry"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClusterBuilder_BuildStreamInterceptor(t *testing.T) {
+	// 三个节点, 一个节点还没有就绪
+	r := &memRegistry{}
+	for i := 0; i < 3; i++ {
+		hs := health.NewServer()
+		if i == 0 {
+			hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+		server := grpc.NewServer()
+		healthpb.RegisterHealthServer(server, hs)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			_ = server.Serve(lis)
+		}()
+		defer server.Stop()
+		r.instances = append(r.instances, registry.ServiceInstance{Name: "user-service", Address: lis.Addr().String()})
+	}
+
+	bd := NewClusterBuilder(r, "user-service", grpc.WithInsecure())
+	cc, err := grpc.Dial(r.instances[0].Address, grpc.WithInsecure(),
+		grpc.WithStreamInterceptor(bd.BuildStreamInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	// Watch 是服务端流, 每个节点都会先推送一次当前的状态
+	stream, err := healthpb.NewHealthClient(cc).Watch(UseBroadcast(ctx), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	cnt := make(map[healthpb.HealthCheckResponse_ServingStatus]int, 2)
+	for i := 0; i < 3; i++ {
+		resp, er := stream.Recv()
+		require.NoError(t, er)
+		cnt[resp.Status]++
+	}
+	assert.Equal(t, map[healthpb.HealthCheckResponse_ServingStatus]int{
+		healthpb.HealthCheckResponse_SERVING:     2,
+		healthpb.HealthCheckResponse_NOT_SERVING: 1,
+	}, cnt)
+}
+
+func TestClusterBuilder_BuildStreamInterceptorClose(t *testing.T) {
+	// 最后一个节点没有注册健康检查, Watch 会返回 Unimplemented, 其它节点的 Watch 不会主动结束
+	r := &memRegistry{}
+	for i := 0; i < 3; i++ {
+		server := grpc.NewServer()
+		if i < 2 {
+			healthpb.RegisterHealthServer(server, health.NewServer())
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			_ = server.Serve(lis)
+		}()
+		defer server.Stop()
+		r.instances = append(r.instances, registry.ServiceInstance{Name: "user-service", Address: lis.Addr().String()})
+	}
+	bd := NewClusterBuilder(r, "user-service", grpc.WithInsecure())
+	interceptor := bd.BuildStreamInterceptor()
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	method := "/grpc.health.v1.Health/Watch"
+
+	testCases := []struct {
+		name string
+		// 返回之后连接都应该被关闭
+		use func(t *testing.T, cancel context.CancelFunc, stream grpc.ClientStream)
+	}{
+		{
+			name: "first error",
+			use: func(t *testing.T, cancel context.CancelFunc, stream grpc.ClientStream) {
+				for {
+					err := stream.RecvMsg(&healthpb.HealthCheckResponse{})
+					if err != nil {
+						assert.Equal(t, codes.Unimplemented, status.Code(err))
+						return
+					}
+				}
+			},
+		},
+		{
+			name: "cancel without recv",
+			use: func(t *testing.T, cancel context.CancelFunc, stream grpc.ClientStream) {
+				cancel()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(UseBroadcast(context.Background()), 3*time.Second)
+			defer cancel()
+			cs, err := interceptor(ctx, desc, nil, method, grpc.NewClientStream)
+			require.NoError(t, err)
+			require.NoError(t, cs.SendMsg(&healthpb.HealthCheckRequest{}))
+			require.NoError(t, cs.CloseSend())
+			tc.use(t, cancel, cs)
+			for _, cc := range cs.(*broadcastStream).conns {
+				assert.Eventually(t, func() bool {
+					return cc.GetState() == connectivity.Shutdown
+				}, time.Second, 10*time.Millisecond)
+			}
+		})
+	}
+}
+
+type memRegistry struct {
+	registry.Registry
+	instances []registry.ServiceInstance
+}
+
+func (m *memRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	return m.instances, nil
+}
@@ -2,8 +2,10 @@ package broadcast
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"micro/registry"
 	"reflect"
 	"sync"
@@ -28,6 +30,15 @@ func NewClusterBuilder(r registry.Registry, service string, dialOptions...grpc.D
 	}
 }
 
+// WithTLS 使用 TLS 连接每一个节点
+// 节点是直接用地址连接的, 所以要把 authority 设置为服务名, 这样 SNI 和证书校验用的都是服务名
+func (b *ClusterBuilder) WithTLS(cfg *tls.Config) *ClusterBuilder {
+	b.dialOptions = append(b.dialOptions,
+		grpc.WithTransportCredentials(credentials.NewTLS(cfg)),
+		grpc.WithAuthority(b.service))
+	return b
+}
+
 func (b ClusterBuilder) BuildUnaryInterceptor() grpc.UnaryClientInterceptor {
 	// method: users.UserService/GetByID
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, 
@@ -0,0 +1,209 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"micro/registry"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Inspector 能在调试接口里面展示内部状态的组件, 例如负载均衡器和限流器
+// 返回值会被序列化成 JSON
+type Inspector interface {
+	Inspect() any
+}
+
+// maxDebugEvents 每个服务保留最近的注册中心事件数量
+const maxDebugEvents = 20
+
+// debugState 客户端每个服务的状态, key 是服务名
+// nil 也可以用, 什么都不记录
+type debugState struct {
+	mutex    sync.Mutex
+	services map[string]*serviceDebug
+}
+
+func newDebugState() *debugState {
+	return &debugState{services: make(map[string]*serviceDebug)}
+}
+
+func (d *debugState) service(name string) *serviceDebug {
+	if d == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	s, ok := d.services[name]
+	if !ok {
+		s = &serviceDebug{subConns: make(map[string]string)}
+		d.services[name] = s
+	}
+	return s
+}
+
+func (d *debugState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	res := make(map[string]serviceSnapshot, len(d.services))
+	for name, s := range d.services {
+		res[name] = s.snapshot()
+	}
+	d.mutex.Unlock()
+	writeDebugJSON(w, map[string]any{"services": res})
+}
+
+type registryEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+}
+
+// serviceDebug 一个服务的节点列表, 连接状态和负载均衡器
+type serviceDebug struct {
+	mutex sync.Mutex
+	// 服务发现
+	instances  []registry.ServiceInstance
+	resolved   time.Time
+	resolveErr error
+	events     []registryEvent
+	// 负载均衡
+	subConns map[string]string
+	picker   balancer.Picker
+	state    connectivity.State
+	picked   time.Time
+}
+
+func (s *serviceDebug) resolvedInstances(instances []registry.ServiceInstance, err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resolveErr = err
+	if err == nil {
+		s.instances = instances
+		s.resolved = time.Now()
+	}
+}
+
+func (s *serviceDebug) registryEvent(e registry.Event) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, registryEvent{Time: time.Now(), Type: e.Type})
+	if len(s.events) > maxDebugEvents {
+		s.events = s.events[len(s.events)-maxDebugEvents:]
+	}
+}
+
+func (s *serviceDebug) subConnState(addr string, state connectivity.State) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state == connectivity.Shutdown {
+		delete(s.subConns, addr)
+		return
+	}
+	s.subConns[addr] = state.String()
+}
+
+func (s *serviceDebug) pickerState(state balancer.State) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.picker = state.Picker
+	s.state = state.ConnectivityState
+	s.picked = time.Now()
+}
+
+type serviceSnapshot struct {
+	Instances  []registry.ServiceInstance `json:"instances"`
+	ResolvedAt time.Time                  `json:"resolved_at"`
+	ResolveErr string                     `json:"resolve_error,omitempty"`
+	Events     []registryEvent            `json:"registry_events"`
+	SubConns   map[string]string          `json:"subconns"`
+	Picker     string                     `json:"picker"`
+	State      string                     `json:"state"`
+	PickedAt   time.Time                  `json:"picker_updated_at"`
+	PickerInfo any                        `json:"picker_state,omitempty"`
+}
+
+func (s *serviceDebug) snapshot() serviceSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := serviceSnapshot{
+		Instances:  s.instances,
+		ResolvedAt: s.resolved,
+		Events:     append([]registryEvent(nil), s.events...),
+		SubConns:   make(map[string]string, len(s.subConns)),
+		State:      s.state.String(),
+		PickedAt:   s.picked,
+	}
+	if s.resolveErr != nil {
+		res.ResolveErr = s.resolveErr.Error()
+	}
+	for addr, state := range s.subConns {
+		res.SubConns[addr] = state
+	}
+	if s.picker != nil {
+		res.Picker = fmt.Sprintf("%T", s.picker)
+		if i, ok := s.picker.(Inspector); ok {
+			res.PickerInfo = i.Inspect()
+		}
+	}
+	return res
+}
+
+// debugBalancerBuilder 包装 ClientWithPickBuilder 传入的负载均衡器, 记录连接状态和当前的 picker
+type debugBalancerBuilder struct {
+	balancer.Builder
+	debug *debugState
+}
+
+func (b *debugBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return b.Builder.Build(&debugClientConn{
+		ClientConn: cc,
+		service:    b.debug.service(opts.Target.Endpoint()),
+	}, opts)
+}
+
+type debugClientConn struct {
+	balancer.ClientConn
+	service *serviceDebug
+}
+
+func (c *debugClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	if len(addrs) > 0 {
+		addr, listener := addrs[0].Addr, opts.StateListener
+		opts.StateListener = func(state balancer.SubConnState) {
+			c.service.subConnState(addr, state.ConnectivityState)
+			if listener != nil {
+				listener(state)
+			}
+		}
+	}
+	return c.ClientConn.NewSubConn(addrs, opts)
+}
+
+func (c *debugClientConn) UpdateState(state balancer.State) {
+	c.service.pickerState(state)
+	c.ClientConn.UpdateState(state)
+}
+
+func writeDebugJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
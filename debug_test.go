@@ -0,0 +1,121 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"micro/balance/round_robin"
+	"micro/ratelimit"
+	"micro/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_DebugHandler(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	adminAddr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	server, err := NewServer("user-service",
+		ServerWithRegistry(&memRegistry{}),
+		ServerWithAdvertiseAddr("127.0.0.1:8081"),
+		ServerWithInspector("limiter", ratelimit.NewFixWindowLimiter(time.Second, 100)),
+		ServerWithDebugHandler(adminAddr))
+	require.NoError(t, err)
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- server.Start("127.0.0.1:0")
+	}()
+
+	var res struct {
+		Service    string                    `json:"service"`
+		Instance   *registry.ServiceInstance `json:"instance"`
+		Health     string                    `json:"health"`
+		Components map[string]map[string]any `json:"components"`
+	}
+	require.Eventually(t, func() bool {
+		return getDebugJSON("http://"+adminAddr+"/debug/micro", &res) == nil && res.Instance != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "user-service", res.Service)
+	assert.Equal(t, "127.0.0.1:8081", res.Instance.Address)
+	assert.Equal(t, "SERVING", res.Health)
+	assert.Equal(t, "fix_window", res.Components["limiter"]["limiter"])
+	assert.Equal(t, float64(100), res.Components["limiter"]["rate"])
+
+	require.NoError(t, server.Close())
+	assert.NoError(t, <-startErr)
+}
+
+func TestClient_DebugHandler(t *testing.T) {
+	server, err := NewServer("user-service", ServerWithHealth())
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	r := &staticRegistry{
+		instances: []registry.ServiceInstance{
+			{Name: "user-service", Address: lis.Addr().String(), Weight: 10},
+			// 被摘除的节点不会建立连接
+			{Name: "user-service", Address: "127.0.0.1:1", Metadata: map[string]string{registry.MetadataDrained: "true"}},
+		},
+		events: make(chan registry.Event, 1),
+	}
+	client := NewClient(ClientInsecure(), ClientWithRegistry(r, time.Second),
+		ClientWithPickBuilder("debug_round_robin", &round_robin.Builder{}))
+	cc, err := client.Dial(context.Background(), "user-service")
+	require.NoError(t, err)
+	defer cc.Close()
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	r.events <- registry.Event{Type: "UPDATE"}
+
+	srv := httptest.NewServer(client.DebugHandler())
+	defer srv.Close()
+	var res struct {
+		Services map[string]serviceSnapshot `json:"services"`
+	}
+	require.Eventually(t, func() bool {
+		return getDebugJSON(srv.URL, &res) == nil && len(res.Services["user-service"].Events) == 1
+	}, time.Second, 10*time.Millisecond)
+	s := res.Services["user-service"]
+	assert.Equal(t, "UPDATE", s.Events[0].Type)
+	assert.Equal(t, r.instances, s.Instances)
+	assert.Equal(t, map[string]string{lis.Addr().String(): "READY"}, s.SubConns)
+	assert.Equal(t, "*round_robin.Balancer", s.Picker)
+	assert.Equal(t, "READY", s.State)
+	assert.Equal(t, []any{lis.Addr().String()}, s.PickerInfo.(map[string]any)["targets"])
+}
+
+func getDebugJSON(url string, v any) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// staticRegistry 返回固定节点列表的注册中心, 事件由测试发送
+type staticRegistry struct {
+	registry.Registry
+	instances []registry.ServiceInstance
+	events    chan registry.Event
+}
+
+func (s *staticRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	return s.instances, nil
+}
+
+func (s *staticRegistry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	return s.events, nil
+}
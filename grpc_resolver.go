@@ -4,17 +4,43 @@ import (
 	"context"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
+	"micro/observability"
 	"micro/registry"
 	"time"
 )
 
+type ResolverOption func(b *grpcResolverBuilder)
+
 type grpcResolverBuilder struct {
 	r registry.Registry
 	timeout time.Duration
+	sink observability.Sink
+	logger observability.Logger
+	// Client 设置, 记录节点列表和注册中心事件
+	debug *debugState
+}
+
+func NewRegistryBuilder(r registry.Registry, timeout time.Duration, opts ...ResolverOption) (*grpcResolverBuilder, error) {
+	res := &grpcResolverBuilder{r: r, timeout: timeout, sink: observability.NopSink{},
+		logger: observability.LoggerOrDefault(nil)}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
+}
+
+// ResolverWithSink 上报服务发现的次数和节点数量
+func ResolverWithSink(s observability.Sink) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.sink = observability.SinkOrNop(s)
+	}
 }
 
-func NewRegistryBuilder(r registry.Registry, timeout time.Duration) (*grpcResolverBuilder, error) {
-	return &grpcResolverBuilder{r: r, timeout: timeout}, nil
+// ResolverWithLogger 默认使用 slog.Default()
+func ResolverWithLogger(l observability.Logger) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.logger = observability.LoggerOrDefault(l)
+	}
 }
 
 func (g *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn,
@@ -24,6 +50,10 @@ func (g *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientCo
 		target: target,
 		timeout: g.timeout,
 		r: g.r,
+		sink: g.sink,
+		logger: g.logger,
+		debug: g.debug.service(target.Endpoint()),
+		close: make(chan struct{}),
 	}
 	r.resolve()
 	// 开启注册中心的事件监听
@@ -46,6 +76,9 @@ type grpcResolver struct {
 	cc resolver.ClientConn
 	// ResolverNow() 服务发现的过期时间
 	timeout time.Duration
+	sink observability.Sink
+	logger observability.Logger
+	debug *serviceDebug
 	close chan struct{}
 }
 
@@ -57,11 +90,17 @@ func (g *grpcResolver) ResolveNow(options resolver.ResolveNowOptions) {
 func (g *grpcResolver) watch() {
 	events, err := g.r.Subscribe(g.target.Endpoint())
 	if err != nil {
+		g.logger.Error("订阅注册中心失败", "service", g.target.Endpoint(), "error", err)
 		g.cc.ReportError(err)
 	}
 	for {
 		select {
-		case <-events:
+		case e, ok := <-events:
+			if !ok {
+				// 注册中心已经关闭
+				return
+			}
+			g.debug.registryEvent(e)
 			// 直接全量从注册中心更新
 			g.resolve()
 		case <-g.close:
@@ -79,12 +118,18 @@ func (g *grpcResolver) resolve() {
 	defer cancel()
 	// 根据 endpoint 获取节点列表
 	instanses, err := g.r.ListServices(ctx, g.target.Endpoint())
+	g.debug.resolvedInstances(instanses, err)
 	if err != nil {
+		g.report("error")
+		g.logger.Warn("服务发现失败", "service", g.target.Endpoint(), "error", err)
 		g.cc.ReportError(err)
 		return
 	}
 	address := make([]resolver.Address, 0, len(instanses))
 	for _, si := range instanses {
+		if si.Drained() {
+			continue
+		}
 		address = append(address, resolver.Address{
 			Addr: si.Address,
 			// 拿到负载均衡的 attribute
@@ -99,6 +144,22 @@ func (g *grpcResolver) resolve() {
 	})
 	if err != nil {
 		// grpc 服务连接抽象出错, 就报告
+		g.report("error")
+		g.logger.Warn("更新节点列表失败", "service", g.target.Endpoint(), "error", err)
 		g.cc.ReportError(err)
+		return
 	}
+	g.report("ok")
+	g.logger.Debug("更新节点列表", "service", g.target.Endpoint(), "instances", len(address))
+	g.sink.SetGauge("resolver_instances", float64(len(address)), observability.Labels{
+		"service": g.target.Endpoint(),
+	})
+}
+
+// report 记录一次服务发现, result 是 ok 或者 error
+func (g *grpcResolver) report(result string) {
+	g.sink.IncCounter("resolver_updates_total", observability.Labels{
+		"service": g.target.Endpoint(),
+		"result":  result,
+	})
 }
\ No newline at end of file
@@ -55,17 +55,8 @@ func (c *Client) Send(data string) (string, error) {
 		return "", err
 	}
 	
-	respHeadBs := make([]byte, headerLength)
-	_, err = conn.Read(respHeadBs)
-	if err != nil {
-		return "", err
-	}
-
 	// 从连接中读
-	respLen := binary.BigEndian.Uint64(respHeadBs)
-	
-	respBs := make([]byte, respLen)
-	_, err = conn.Read(respBs)
+	respBs, err := readMsg(conn, DefaultMaxMsgSize)
 	if err != nil {
 		return "", err
 	}
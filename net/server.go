@@ -3,6 +3,7 @@ package net
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 )
@@ -85,9 +86,18 @@ func handleMsg(req []byte) []byte {
 	return res
 }
 
+// DefaultMaxMsgSize 消息长度的默认上限
+const DefaultMaxMsgSize = 16 << 20
+
+// ErrMsgTooLarge 长度字段超过上限, 可能是恶意的对端, 连接会被关闭
+var ErrMsgTooLarge = errors.New("micro: 消息长度超过上限")
+
 type Server struct {
 	//network string
 	//addr string
+
+	// MaxMsgSize 请求长度的上限, 为 0 的时候使用 DefaultMaxMsgSize
+	MaxMsgSize uint64
 }
 
 func (s *Server) Start(network, addr string) error {
@@ -114,19 +124,12 @@ func (s *Server) Start(network, addr string) error {
 // 2. 请求数据：
 // 响应也是这个规范
 func (s *Server) handleConn(conn net.Conn) error {
+	maxSize := s.MaxMsgSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxMsgSize
+	}
 	for {
-		// 先从连接中读取长度字段
-		lenBs := make([]byte, headerLength)
-		_, err := conn.Read(lenBs)
-		if err != nil {
-			return err
-		}
-		
-		// 消息长度
-		length := binary.BigEndian.Uint64(lenBs)
-		
-		reqBs := make([]byte, length)
-		_, err = conn.Read(reqBs)
+		reqBs, err := readMsg(conn, maxSize)
 		if err != nil {
 			return err
 		}
@@ -145,3 +148,25 @@ func (s *Server) handleConn(conn net.Conn) error {
 		}
 	}
 }
+
+// readMsg 一次 Read 不一定能读满, 要用 io.ReadFull
+// 长度字段是对端给的, 超过上限直接拒绝, 避免分配很大的内存
+func readMsg(r io.Reader, maxSize uint64) ([]byte, error) {
+	lenBs := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, lenBs); err != nil {
+		return nil, err
+	}
+	// 消息长度
+	length := binary.BigEndian.Uint64(lenBs)
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: %d > %d", ErrMsgTooLarge, length, maxSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -1,12 +1,15 @@
 package net
 
 import (
+	"bytes"
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"micro/net/mocks"
 	"net"
 	"testing"
+	"testing/iotest"
 )
 
 func TestHandleConn(t *testing.T) {
@@ -37,3 +40,41 @@ func TestHandleConn(t *testing.T) {
 		})
 	}
 }
+
+func TestReadMsg(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    []byte
+		maxSize uint64
+
+		wantData []byte
+		wantErr  error
+	}{
+		{
+			name:     "ok",
+			data:     []byte{0, 0, 0, 0, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'},
+			maxSize:  DefaultMaxMsgSize,
+			wantData: []byte("hello"),
+		},
+		{
+			name:    "truncated",
+			data:    []byte{0, 0, 0, 0, 0, 0, 0, 5, 'h', 'e'},
+			maxSize: DefaultMaxMsgSize,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "too large",
+			data:    []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			maxSize: DefaultMaxMsgSize,
+			wantErr: ErrMsgTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := readMsg(iotest.OneByteReader(bytes.NewReader(tc.data)), tc.maxSize)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantData, data)
+		})
+	}
+}
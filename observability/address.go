@@ -3,13 +3,28 @@ package observability
 import "net"
 
 // 拿到 IP 地址
+// 先看默认路由走哪个网卡, UDP 的 Dial 不会真的发包, 但是没有路由的时候会失败
+// 这时候退化为从网卡里面找第一个非回环的 IPv4 地址, 保证断网的时候也能拿到
 func GetOutboundIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err == nil {
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	return interfaceIP()
+}
+
+func interfaceIP() string {
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return ""
 	}
-	defer conn.Close()
-	
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		return ipNet.IP.String()
+	}
+	return ""
 }
@@ -0,0 +1,79 @@
+// Package stream observability 里面的客户端流拦截器共用的包装
+package stream
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+)
+
+// ClientStream 在流结束的时候调用一次 end, 流结束是指下面任意一种情况:
+//
+//	RecvMsg 返回 error, io.EOF 表示正常结束
+//	SendMsg 返回 io.EOF 以外的 error
+//	只有一个响应的流 (客户端流和普通调用) 收到了响应, 也就是 CloseSend 之后的 RecvMsg
+//	ctx 被取消
+//
+// 监听 ctx 用的是 context.AfterFunc, 不会启动 goroutine.
+// 调用方既不取消 ctx 也不读到结束的话, end 不会被调用, 这种情况 grpc 自己也会泄漏流
+type ClientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	end  func(err error)
+	// OnSend 和 OnRecv 在成功收发消息之后调用
+	OnSend func(m any)
+	OnRecv func(m any)
+
+	once sync.Once
+	stop func() bool
+}
+
+func NewClientStream(ctx context.Context, cs grpc.ClientStream, desc *grpc.StreamDesc,
+	end func(err error)) *ClientStream {
+	res := &ClientStream{ClientStream: cs, desc: desc, end: end}
+	res.stop = context.AfterFunc(ctx, func() {
+		res.finish(status.FromContextError(ctx.Err()).Err())
+	})
+	return res
+}
+
+func (s *ClientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	switch {
+	case err == nil:
+		if s.OnSend != nil {
+			s.OnSend(m)
+		}
+	case err != io.EOF:
+		// io.EOF 的时候真正的错误要从 RecvMsg 拿
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *ClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		if s.OnRecv != nil {
+			s.OnRecv(m)
+		}
+		if !s.desc.ServerStreams {
+			s.finish(nil)
+		}
+	}
+	return err
+}
+
+func (s *ClientStream) finish(err error) {
+	s.once.Do(func() {
+		s.stop()
+		s.end(err)
+	})
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestClientStream(t *testing.T) {
+	testCases := []struct {
+		name string
+		desc *grpc.StreamDesc
+		cs   *mockStream
+		// 对流做的操作
+		do func(s *ClientStream, cancel context.CancelFunc)
+
+		wantEnded bool
+		wantErr   error
+		wantSent  int
+		wantRecv  int
+	}{
+		{
+			name: "server stream eof",
+			desc: &grpc.StreamDesc{ServerStreams: true},
+			cs:   &mockStream{recv: []error{nil, nil, io.EOF}},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				for s.RecvMsg(nil) == nil {
+				}
+			},
+			wantEnded: true,
+			wantRecv:  2,
+		},
+		{
+			name: "server stream error",
+			desc: &grpc.StreamDesc{ServerStreams: true},
+			cs:   &mockStream{recv: []error{status.Error(codes.Internal, "boom")}},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				_ = s.RecvMsg(nil)
+			},
+			wantEnded: true,
+			wantErr:   status.Error(codes.Internal, "boom"),
+		},
+		{
+			// 客户端流 CloseSend 之后收到响应就结束了, 调用方不会再读到 io.EOF
+			name: "client stream",
+			desc: &grpc.StreamDesc{ClientStreams: true},
+			cs:   &mockStream{recv: []error{nil}},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				_ = s.SendMsg(nil)
+				_ = s.SendMsg(nil)
+				_ = s.CloseSend()
+				_ = s.RecvMsg(nil)
+			},
+			wantEnded: true,
+			wantSent:  2,
+			wantRecv:  1,
+		},
+		{
+			name: "send error",
+			desc: &grpc.StreamDesc{ClientStreams: true},
+			cs:   &mockStream{send: errors.New("broken pipe")},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				_ = s.SendMsg(nil)
+			},
+			wantEnded: true,
+			wantErr:   errors.New("broken pipe"),
+		},
+		{
+			// io.EOF 表示服务端已经结束了, 要等 RecvMsg 拿到真正的错误
+			name: "send eof",
+			desc: &grpc.StreamDesc{ClientStreams: true},
+			cs:   &mockStream{send: io.EOF},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				_ = s.SendMsg(nil)
+			},
+		},
+		{
+			name: "cancel",
+			desc: &grpc.StreamDesc{ServerStreams: true},
+			cs:   &mockStream{},
+			do: func(s *ClientStream, cancel context.CancelFunc) {
+				cancel()
+			},
+			wantEnded: true,
+			wantErr:   status.Error(codes.Canceled, context.Canceled.Error()),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ended := make(chan error, 2)
+			s := NewClientStream(ctx, tc.cs, tc.desc, func(err error) {
+				ended <- err
+			})
+			var sent, recv int
+			s.OnSend = func(m any) { sent++ }
+			s.OnRecv = func(m any) { recv++ }
+			tc.do(s, cancel)
+			select {
+			case err := <-ended:
+				assert.True(t, tc.wantEnded)
+				assert.Equal(t, tc.wantErr, err)
+				// 结束之后再取消不会重复调用 end
+				cancel()
+				time.Sleep(10 * time.Millisecond)
+				assert.Empty(t, ended)
+			case <-time.After(100 * time.Millisecond):
+				assert.False(t, tc.wantEnded)
+			}
+			assert.Equal(t, tc.wantSent, sent)
+			assert.Equal(t, tc.wantRecv, recv)
+		})
+	}
+}
+
+// TestClientStream_NoGoroutine 调用方不取消 ctx, 也不读到结束, 不会留下 goroutine
+func TestClientStream_NoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		s := NewClientStream(ctx, &mockStream{}, &grpc.StreamDesc{ClientStreams: true}, func(err error) {})
+		_ = s.CloseSend()
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+type mockStream struct {
+	grpc.ClientStream
+	recv []error
+	send error
+}
+
+func (m *mockStream) RecvMsg(msg any) error {
+	if len(m.recv) == 0 {
+		return io.EOF
+	}
+	err := m.recv[0]
+	m.recv = m.recv[1:]
+	return err
+}
+
+func (m *mockStream) SendMsg(msg any) error {
+	return m.send
+}
+
+func (m *mockStream) CloseSend() error {
+	return nil
+}
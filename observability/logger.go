@@ -0,0 +1,33 @@
+package observability
+
+import "log/slog"
+
+// Logger 框架内部打日志的接口, 方法和 *slog.Logger 一致, 可以直接传入 slog.Default()
+// args 是交替出现的 key 和 value, 例如 logger.Info("注册成功", "service", name)
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// NopLogger 什么都不打
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...any) {}
+
+func (NopLogger) Info(msg string, args ...any) {}
+
+func (NopLogger) Warn(msg string, args ...any) {}
+
+func (NopLogger) Error(msg string, args ...any) {}
+
+// LoggerOrDefault l 为 nil 的时候返回 slog.Default()
+func LoggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math/rand/v2"
+	"micro/observability"
+	"time"
+)
+
+// AccessLogBuilder 访问日志, 记录方法, 对端, 耗时, 状态码, 请求 ID 和 trace ID
+// 出错的请求和慢请求一定会记录, 其它的请求按照 SampleRate 采样
+type AccessLogBuilder struct {
+	// Logger 为 nil 的时候使用 slog.Default()
+	Logger observability.Logger
+	// SampleRate 正常请求的采样率, 小于等于 0 或者大于等于 1 的时候全部记录
+	SampleRate float64
+	// SlowThreshold 耗时超过这个值的请求用 Warn 级别记录, 0 表示不区分慢请求
+	SlowThreshold time.Duration
+}
+
+func (b *AccessLogBuilder) BuildServer() grpc.UnaryServerInterceptor {
+	logger := observability.LoggerOrDefault(b.Logger)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp any, err error) {
+		id := incomingRequestID(ctx)
+		ctx = ContextWithRequestID(ctx, id)
+		// 把请求 ID 返回给客户端, 方便排查
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+		startTime := time.Now()
+		resp, err = handler(ctx, req)
+		b.log(logger, "grpc 服务端请求", ctx, info.FullMethod, id, startTime, err)
+		return
+	}
+}
+
+func (b *AccessLogBuilder) BuildStreamServer() grpc.StreamServerInterceptor {
+	logger := observability.LoggerOrDefault(b.Logger)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) (err error) {
+		id := incomingRequestID(ss.Context())
+		ctx := ContextWithRequestID(ss.Context(), id)
+		_ = ss.SetHeader(metadata.Pairs(RequestIDKey, id))
+		startTime := time.Now()
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		b.log(logger, "grpc 服务端请求", ctx, info.FullMethod, id, startTime, err)
+		return
+	}
+}
+
+func (b *AccessLogBuilder) BuildClient() grpc.UnaryClientInterceptor {
+	logger := observability.LoggerOrDefault(b.Logger)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		ctx, id := outgoingRequestID(ctx)
+		var p peer.Peer
+		startTime := time.Now()
+		err = invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		b.log(logger, "grpc 客户端请求", peer.NewContext(ctx, &p), method, id, startTime, err)
+		return
+	}
+}
+
+// Record 给其它传输层的适配器用, 例如 micro/rpc/middleware
+func (b *AccessLogBuilder) Record(ctx context.Context, msg string, method string, id string,
+	startTime time.Time, err error) {
+	b.log(observability.LoggerOrDefault(b.Logger), msg, ctx, method, id, startTime, err)
+}
+
+func (b *AccessLogBuilder) log(logger observability.Logger, msg string, ctx context.Context,
+	method string, id string, startTime time.Time, err error) {
+	duration := time.Since(startTime)
+	code := status.Code(err)
+	slow := b.SlowThreshold > 0 && duration >= b.SlowThreshold
+	if err == nil && !slow && !b.sampled() {
+		return
+	}
+	args := []any{"method", method, "duration", duration, "code", code.String(), "request_id", id}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		args = append(args, "peer", p.Addr.String())
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		args = append(args, "trace_id", sc.TraceID().String())
+	}
+	if slow {
+		args = append(args, "slow", true)
+	}
+	if err != nil {
+		args = append(args, "error", err.Error())
+	}
+	switch {
+	case serverError(code):
+		logger.Error(msg, args...)
+	case err != nil || slow:
+		logger.Warn(msg, args...)
+	default:
+		logger.Info(msg, args...)
+	}
+}
+
+func (b *AccessLogBuilder) sampled() bool {
+	if b.SampleRate <= 0 || b.SampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < b.SampleRate
+}
+
+// serverError 服务端自己的问题, 其它的错误码一般是调用方的问题
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.Unimplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// serverStream 替换 context, 让业务代码可以拿到请求 ID
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccessLogBuilder_Log(t *testing.T) {
+	testCases := []struct {
+		name     string
+		builder  *AccessLogBuilder
+		duration time.Duration
+		err      error
+
+		wantLevel string
+	}{
+		{
+			name:      "ok",
+			builder:   &AccessLogBuilder{},
+			wantLevel: "INFO",
+		},
+		{
+			name:    "not sampled",
+			builder: &AccessLogBuilder{SampleRate: 0.0000001},
+		},
+		{
+			name:      "error always logged",
+			builder:   &AccessLogBuilder{SampleRate: 0.0000001},
+			err:       status.Error(codes.NotFound, "not found"),
+			wantLevel: "WARN",
+		},
+		{
+			name:      "server error",
+			builder:   &AccessLogBuilder{},
+			err:       errors.New("db down"),
+			wantLevel: "ERROR",
+		},
+		{
+			name:      "slow",
+			builder:   &AccessLogBuilder{SampleRate: 0.0000001, SlowThreshold: time.Millisecond},
+			duration:  time.Second,
+			wantLevel: "WARN",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &memLogger{}
+			tc.builder.log(l, "access", context.Background(), "/UserService/GetById", "id",
+				time.Now().Add(-tc.duration), tc.err)
+			if tc.wantLevel == "" {
+				assert.Empty(t, l.records)
+				return
+			}
+			require.Len(t, l.records, 1)
+			assert.Equal(t, tc.wantLevel, l.records[0].level)
+			assert.Equal(t, "/UserService/GetById", l.records[0].attrs["method"])
+			assert.Equal(t, status.Code(tc.err).String(), l.records[0].attrs["code"])
+		})
+	}
+}
+
+func TestAccessLogBuilder_RequestID(t *testing.T) {
+	serverLogger := &memLogger{}
+	var gotID string
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		(&AccessLogBuilder{Logger: serverLogger}).BuildServer(),
+		func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			gotID = RequestIDFromContext(ctx)
+			return handler(ctx, req)
+		}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	clientLogger := &memLogger{}
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor((&AccessLogBuilder{Logger: clientLogger}).BuildClient()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	// 沿用上游的请求 ID
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	var header metadata.MD
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", gotID)
+	assert.Equal(t, []string{"req-1"}, header.Get(RequestIDKey))
+	require.Len(t, serverLogger.records, 1)
+	assert.Equal(t, "req-1", serverLogger.records[0].attrs["request_id"])
+	assert.Contains(t, serverLogger.records[0].attrs["peer"], "127.0.0.1:")
+	require.Len(t, clientLogger.records, 1)
+	assert.Equal(t, lis.Addr().String(), clientLogger.records[0].attrs["peer"])
+
+	// 没有的时候生成一个
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Len(t, gotID, 32)
+	assert.Equal(t, gotID, clientLogger.records[1].attrs["request_id"])
+}
+
+func TestIncomingRequestID(t *testing.T) {
+	testCases := []struct {
+		name string
+		id   string
+
+		wantID string
+	}{
+		{
+			name:   "valid",
+			id:     "req-1",
+			wantID: "req-1",
+		},
+		{
+			name: "empty",
+		},
+		{
+			name: "too long",
+			id:   strings.Repeat("a", MaxRequestIDLength+1),
+		},
+		{
+			name: "line break",
+			id:   "req-1\nlevel=ERROR",
+		},
+		{
+			name: "invalid utf8",
+			id:   "req-\xff",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDKey, tc.id))
+			id := incomingRequestID(ctx)
+			if tc.wantID == "" {
+				// 重新生成的
+				assert.Len(t, id, 32)
+				return
+			}
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+}
+
+type record struct {
+	level string
+	msg   string
+	attrs map[string]string
+}
+
+type memLogger struct {
+	mutex   sync.Mutex
+	records []record
+}
+
+func (m *memLogger) log(level, msg string, args []any) {
+	attrs := make(map[string]string, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records = append(m.records, record{level: level, msg: msg, attrs: attrs})
+}
+
+func (m *memLogger) Debug(msg string, args ...any) { m.log("DEBUG", msg, args) }
+
+func (m *memLogger) Info(msg string, args ...any) { m.log("INFO", msg, args) }
+
+func (m *memLogger) Warn(msg string, args ...any) { m.log("WARN", msg, args) }
+
+func (m *memLogger) Error(msg string, args ...any) { m.log("ERROR", msg, args) }
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/grpc/metadata"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RequestIDKey 请求 ID 在 metadata 里面的 key, 客户端没有带的时候由服务端生成
+const RequestIDKey = "x-request-id"
+
+// MaxRequestIDLength 上游传过来的请求 ID 的最大长度, 超过了就重新生成, 避免日志被撑爆
+const MaxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// incomingRequestID 服务端优先使用上游传过来的请求 ID, 不合法的话重新生成一个
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(RequestIDKey); len(vals) > 0 && ValidRequestID(vals[0]) {
+			return vals[0]
+		}
+	}
+	return NewRequestID()
+}
+
+// ValidRequestID 请求 ID 是客户端传过来的, 会原样写进日志, 不能太长也不能有换行之类的控制字符
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength || !utf8.ValidString(id) {
+		return false
+	}
+	for _, c := range id {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// outgoingRequestID 客户端沿用 context 里面的请求 ID, 这样一个请求经过的所有服务都是同一个 ID
+func outgoingRequestID(ctx context.Context) (context.Context, string) {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if vals := md.Get(RequestIDKey); len(vals) > 0 && vals[0] != "" {
+			return ctx, vals[0]
+		}
+	}
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		id = NewRequestID()
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDKey, id), id
+}
+
+// NewRequestID 随机生成一个请求 ID
+func NewRequestID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
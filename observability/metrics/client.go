@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"micro/observability/internal/stream"
+	"sync"
+	"time"
+)
+
+// ClientMetricsBuilder 客户端的 RED 指标, 也就是请求数, 错误数和响应时间
+// target 是实际调用的服务端节点, 可以用来看负载均衡是否均匀, 例如
+//
+//	sum by (target) (rate(client_requests_total[1m]))
+//	sum(rate(client_requests_total{code!="OK"}[1m])) / sum(rate(client_requests_total[1m]))
+//	histogram_quantile(0.99, sum by (le) (rate(client_request_duration_seconds_bucket[1m])))
+type ClientMetricsBuilder struct {
+	Namespace string
+	Subsystem string
+	// Registerer 默认是 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+	// Buckets 响应时间的分桶, 单位是秒, 默认是 prometheus.DefBuckets
+	Buckets []float64
+
+	once     sync.Once
+	active   *prometheus.GaugeVec
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func (b *ClientMetricsBuilder) init() {
+	reg := b.Registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	b.active = register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: b.Namespace,
+		Subsystem: b.Subsystem,
+		Name:      "client_active_requests",
+		Help:      "当前正在等待响应的请求数量",
+	}, []string{"method"}))
+	b.requests = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: b.Namespace,
+		Subsystem: b.Subsystem,
+		Name:      "client_requests_total",
+		Help:      "请求数量, code 是 grpc 的状态码, target 是服务端节点",
+	}, []string{"method", "code", "target"}))
+	b.duration = register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: b.Namespace,
+		Subsystem: b.Subsystem,
+		Name:      "client_request_duration_seconds",
+		Help:      "响应时间, 流是整个流的持续时间",
+		Buckets:   b.Buckets,
+	}, []string{"method", "code", "target"}))
+}
+
+func (b *ClientMetricsBuilder) Build() grpc.UnaryClientInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		startTime := time.Now()
+		b.active.WithLabelValues(method).Inc()
+		var p peer.Peer
+		defer func() {
+			b.observe(method, startTime, &p, err)
+		}()
+		err = invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		return
+	}
+}
+
+// BuildStream 流结束的时候才记录, 也就是 RecvMsg 返回 error 或者 context 被取消
+func (b *ClientMetricsBuilder) BuildStream() grpc.StreamClientInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		startTime := time.Now()
+		b.active.WithLabelValues(method).Inc()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			b.observe(method, startTime, &peer.Peer{}, err)
+			return nil, err
+		}
+		p, ok := peer.FromContext(cs.Context())
+		if !ok {
+			p = &peer.Peer{}
+		}
+		res := stream.NewClientStream(ctx, cs, desc, func(err error) {
+			b.observe(method, startTime, p, err)
+		})
+		return res, nil
+	}
+}
+
+func (b *ClientMetricsBuilder) observe(method string, startTime time.Time, p *peer.Peer, err error) {
+	b.active.WithLabelValues(method).Dec()
+	// 连接都没有建立起来的时候没有节点
+	target := ""
+	if p.Addr != nil {
+		target = p.Addr.String()
+	}
+	code := status.Code(err).String()
+	b.requests.WithLabelValues(method, code, target).Inc()
+	b.duration.WithLabelValues(method, code, target).Observe(time.Since(startTime).Seconds())
+}
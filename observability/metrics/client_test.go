@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMetricsBuilder(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sb := &ServerMetricsBuilder{Registerer: reg}
+	server := grpc.NewServer(grpc.UnaryInterceptor(sb.Build()), grpc.StreamInterceptor(sb.BuildStream()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	// 同一个 Registerer 可以 Build 多次
+	cb := &ClientMetricsBuilder{Registerer: reg}
+	_ = (&ClientMetricsBuilder{Registerer: reg}).Build()
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(cb.Build()), grpc.WithStreamInterceptor(cb.BuildStream()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Error(t, err)
+
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	watchCancel()
+
+	const check = "/grpc.health.v1.Health/Check"
+	const watch = "/grpc.health.v1.Health/Watch"
+	target := lis.Addr().String()
+	assert.Equal(t, 1.0, testutil.ToFloat64(cb.requests.WithLabelValues(check, "OK", target)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(cb.requests.WithLabelValues(check, "NotFound", target)))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(cb.requests.WithLabelValues(watch, "Canceled", target)) == 1 &&
+			testutil.ToFloat64(sb.requests.WithLabelValues(watch, "Canceled")) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(cb.active.WithLabelValues(watch)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(sb.active.WithLabelValues(watch)))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(sb.requests.WithLabelValues(check, "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sb.requests.WithLabelValues(check, "NotFound")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sb.msgCnt.WithLabelValues(watch, "received")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sb.msgCnt.WithLabelValues(watch, "sent")))
+
+	// 每个方法, 状态码, 节点一条直方图
+	cnt, err := testutil.GatherAndCount(reg, "client_request_duration_seconds", "server_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 6, cnt)
+}
@@ -5,70 +5,127 @@ import (
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	"micro/observability"
+	"sync"
 	"time"
 )
 
 type ServerMetricsBuilder struct {
 	Namespace string
 	Subsystem string
-	Port int
+	Port      int
+	// Registerer 默认是 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+	// Buckets 响应时间的分桶, 单位是秒, 默认是 prometheus.DefBuckets
+	Buckets []float64
+
+	// Build 和 BuildStream 共用同一组指标
+	once     sync.Once
+	active   *prometheus.GaugeVec
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	msgCnt   *prometheus.CounterVec
 }
 
-func (b *ServerMetricsBuilder) Build() grpc.UnaryServerInterceptor {
+func (b *ServerMetricsBuilder) init() {
 	addr := observability.GetOutboundIP()
 	if b.Port != 0 {
 		addr = fmt.Sprintf("%s:%d", addr, b.Port)
 	}
-	reqGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: b.Namespace,
-		Subsystem: b.Subsystem,
-		Name: "active_request_cnt",
-		Help: "当前正在处理的请求数量",
-		ConstLabels: map[string]string{
-			"component": "server",
-			"address": addr,
-		},
-	}, []string{"service"})
-	errCnt := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: b.Namespace,
-		Subsystem: b.Subsystem,
-		Name: "count errors",
-		Help: "错误数统计",
-		ConstLabels: map[string]string{
-			"component": "server",
-			"address": addr,
-			// ...
-		},
-	}, []string{"service"})
+	reg := b.Registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	constLabels := map[string]string{
+		"address": addr,
+	}
+	b.active = register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        "server_active_requests",
+		Help:        "当前正在处理的请求数量",
+		ConstLabels: constLabels,
+	}, []string{"method"}))
+	b.requests = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        "server_requests_total",
+		Help:        "请求数量, code 是 grpc 的状态码, 可以用来算错误率",
+		ConstLabels: constLabels,
+	}, []string{"method", "code"}))
+	b.duration = register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        "server_request_duration_seconds",
+		Help:        "响应时间, 流是整个流的持续时间",
+		ConstLabels: constLabels,
+		Buckets:     b.Buckets,
+	}, []string{"method", "code"}))
+	// 流上收发的消息数量, direction 是 received 或者 sent
+	b.msgCnt = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        "server_stream_msgs_total",
+		Help:        "流上收发的消息数量",
+		ConstLabels: constLabels,
+	}, []string{"method", "direction"}))
+}
 
-	response := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace: b.Namespace,
-		Subsystem: b.Subsystem,
-		Name: "summary response time",
-		Help: "响应时间统计",
-		ConstLabels: map[string]string{
-			"component": "server",
-			"address": addr,
-			// ...
-		},
-	}, []string{"service"})
-	
-	prometheus.MustRegister(reqGauge, errCnt, response)
-	
-	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, 
+func (b *ServerMetricsBuilder) Build() grpc.UnaryServerInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (resp any, err error) {
-		startTime := time.Now()
-		reqGauge.WithLabelValues(info.FullMethod).Add(1)
-		defer func() {
-			reqGauge.WithLabelValues(info.FullMethod).Add(-1)
-			if err != nil {
-				errCnt.WithLabelValues(info.FullMethod).Add(1)
-			}
-			response.WithLabelValues(info.FullMethod).
-				Observe(float64(time.Now().Sub(startTime).Milliseconds()))
-		}()
+		defer b.observe(info.FullMethod, time.Now(), &err)()
 		resp, err = handler(ctx, req)
-		return 
+		return
 	}
-}
\ No newline at end of file
+}
+
+// BuildStream 一个流算一个请求, 响应时间是整个流的持续时间, 另外统计每个消息
+func (b *ServerMetricsBuilder) BuildStream() grpc.StreamServerInterceptor {
+	b.once.Do(b.init)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) (err error) {
+		defer b.observe(info.FullMethod, time.Now(), &err)()
+		err = handler(srv, &serverStream{
+			ServerStream: ss,
+			received:     b.msgCnt.WithLabelValues(info.FullMethod, "received"),
+			sent:         b.msgCnt.WithLabelValues(info.FullMethod, "sent"),
+		})
+		return
+	}
+}
+
+// observe 在请求开始的时候调用, 返回的方法在请求结束的时候调用
+func (b *ServerMetricsBuilder) observe(method string, startTime time.Time, err *error) func() {
+	b.active.WithLabelValues(method).Inc()
+	return func() {
+		b.active.WithLabelValues(method).Dec()
+		code := status.Code(*err).String()
+		b.requests.WithLabelValues(method, code).Inc()
+		b.duration.WithLabelValues(method, code).Observe(time.Since(startTime).Seconds())
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received.Inc()
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.Inc()
+	}
+	return err
+}
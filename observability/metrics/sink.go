@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"micro/observability"
+	"sort"
+	"sync"
+)
+
+// PrometheusSink 把框架内部的指标注册到 prometheus
+// 指标在第一次上报的时候创建, label 的名字取第一次上报的 labels 的 key
+type PrometheusSink struct {
+	namespace string
+	reg       prometheus.Registerer
+
+	mutex    sync.Mutex
+	counters map[string]*prometheus.CounterVec
+	gauges   map[string]*prometheus.GaugeVec
+}
+
+var _ observability.Sink = &PrometheusSink{}
+
+// NewPrometheusSink reg 为 nil 的时候使用 prometheus.DefaultRegisterer
+func NewPrometheusSink(namespace string, reg prometheus.Registerer) *PrometheusSink {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	return &PrometheusSink{
+		namespace: namespace,
+		reg:       reg,
+		counters:  make(map[string]*prometheus.CounterVec, 8),
+		gauges:    make(map[string]*prometheus.GaugeVec, 8),
+	}
+}
+
+func (p *PrometheusSink) IncCounter(name string, labels observability.Labels) {
+	p.mutex.Lock()
+	vec, ok := p.counters[name]
+	if !ok {
+		vec = register(p.reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: p.namespace,
+			Name:      name,
+			Help:      "micro 内部指标 " + name,
+		}, labelNames(labels)))
+		p.counters[name] = vec
+	}
+	p.mutex.Unlock()
+	// label 对不上就丢掉, 监控不能影响业务
+	if c, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		c.Inc()
+	}
+}
+
+func (p *PrometheusSink) SetGauge(name string, value float64, labels observability.Labels) {
+	p.mutex.Lock()
+	vec, ok := p.gauges[name]
+	if !ok {
+		vec = register(p.reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: p.namespace,
+			Name:      name,
+			Help:      "micro 内部指标 " + name,
+		}, labelNames(labels)))
+		p.gauges[name] = vec
+	}
+	p.mutex.Unlock()
+	if g, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		g.Set(value)
+	}
+}
+
+func labelNames(labels observability.Labels) []string {
+	res := make([]string, 0, len(labels))
+	for k := range labels {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/observability"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusSink(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink := NewPrometheusSink("micro", reg)
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8081"})
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8081"})
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8082"})
+	// label 对不上的直接丢掉
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin"})
+	sink.SetGauge("resolver_instances", 3, observability.Labels{"service": "user-service"})
+	sink.SetGauge("resolver_instances", 2, observability.Labels{"service": "user-service"})
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP micro_picker_picks_total micro 内部指标 picker_picks_total
+# TYPE micro_picker_picks_total counter
+micro_picker_picks_total{picker="round_robin",target="127.0.0.1:8081"} 2
+micro_picker_picks_total{picker="round_robin",target="127.0.0.1:8082"} 1
+# HELP micro_resolver_instances micro 内部指标 resolver_instances
+# TYPE micro_resolver_instances gauge
+micro_resolver_instances{service="user-service"} 2
+`))
+	require.NoError(t, err)
+
+	// 同一个 Registerer 上再建一个 Sink 也不会 panic, 共用同一组指标
+	other := NewPrometheusSink("micro", reg)
+	other.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8082"})
+	assert.Equal(t, 2.0, testutil.ToFloat64(sink.counters["picker_picks_total"].WithLabelValues("round_robin", "127.0.0.1:8082")))
+}
@@ -0,0 +1,30 @@
+package opentelemetry
+
+import (
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// metadataCarrier 让 propagator 可以读写 grpc 的 metadata
+// 不能用 propagation.HeaderCarrier, 它会把 key 转成 Traceparent 这种形式, 而 metadata 的 key 都是小写
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	res := make([]string, 0, len(c))
+	for key := range c {
+		res = append(res, strings.ToLower(key))
+	}
+	return res
+}
@@ -2,43 +2,91 @@ package opentelemetry
 
 import (
 	"context"
-	"fmt"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"micro/observability"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"micro/observability/internal/stream"
 )
 
 type ClientOtelBuilder struct {
 	Tracer trace.Tracer
-	Port int
+	// Payload 不为 nil 的时候记录请求和响应的内容
+	Payload *PayloadCapture
 }
 
-func (b *ClientOtelBuilder) Build() grpc.UnaryServerInterceptor {
+func (b *ClientOtelBuilder) Build() grpc.UnaryClientInterceptor {
 	if b.Tracer == nil {
 		b.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
 	}
-	addr := observability.GetOutboundIP()
-	if b.Port != 0 {
-		addr = fmt.Sprintf("%s:%d", addr, b.Port)
-	}
-	return func(ctx context.Context, req interface{},
-		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		// 客服端这里不需要 tracing 关联上游
-		spanCtx, span := b.Tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindClient))
-		span.SetAttributes(attribute.String("address", addr))
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		// 客服端这里不需要 tracing 关联上游, 只需要把自己传给下游
+		ctx, span := b.start(ctx, method)
+		messageEvent(span, semconv.MessageTypeSent, 1, req, b.Payload)
+		var p peer.Peer
 		defer func() {
-			if err != nil {
-				span.SetStatus(codes.Error, err.Error())
-				span.RecordError(err)
+			span.SetAttributes(peerAttributes(p.Addr)...)
+			if err == nil {
+				messageEvent(span, semconv.MessageTypeReceived, 1, reply, b.Payload)
 			}
-			span.End()
+			endSpan(span, err)
 		}()
-		resp, err = handler(spanCtx, req)
+		err = invoker(b.inject(ctx), method, req, reply, cc, append(opts, grpc.Peer(&p))...)
 		return
 	}
 }
 
+// BuildStream 流结束的时候 span 才结束, 也就是 RecvMsg 返回 error 或者 context 被取消
+func (b *ClientOtelBuilder) BuildStream() grpc.StreamClientInterceptor {
+	if b.Tracer == nil {
+		b.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := b.start(ctx, method)
+		cs, err := streamer(b.inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		// 流建立之后 context 里面就有对端地址了, grpc.Peer 要等流结束才会设置
+		if p, ok := peer.FromContext(cs.Context()); ok {
+			span.SetAttributes(peerAttributes(p.Addr)...)
+		}
+		// 收发消息的序号, SendMsg 和 RecvMsg 可以在两个 goroutine 里面同时调用, 各自只改自己的
+		var sent, received int
+		res := stream.NewClientStream(ctx, cs, desc, func(err error) {
+			endSpan(span, err)
+		})
+		res.OnSend = func(m any) {
+			sent++
+			messageEvent(span, semconv.MessageTypeSent, sent, m, b.Payload)
+		}
+		res.OnRecv = func(m any) {
+			received++
+			messageEvent(span, semconv.MessageTypeReceived, received, m, b.Payload)
+		}
+		return res, nil
+	}
+}
+
+func (b *ClientOtelBuilder) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return b.Tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(rpcAttributes(method)...))
+}
 
+// inject 把 traceparent 和 baggage 放到 metadata 里面, 服务端通过 extract 关联上
+func (b *ClientOtelBuilder) inject(ctx context.Context) context.Context {
+	carrier := metadataCarrier(metadata.MD{})
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	kv := make([]string, 0, 2*len(carrier))
+	for key, vals := range carrier {
+		for _, val := range vals {
+			kv = append(kv, key, val)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
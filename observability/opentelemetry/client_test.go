@@ -0,0 +1,136 @@
+package opentelemetry
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientOtelBuilder(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(instrumentationName)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// 服务端记录收到的 baggage
+	var gotBaggage string
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor((&ServerOtelBuilder{Tracer: tracer}).Build(),
+			func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				gotBaggage = baggage.FromContext(ctx).Member("tenant").Value()
+				return handler(ctx, req)
+			}),
+		grpc.StreamInterceptor((&ServerOtelBuilder{Tracer: tracer}).BuildStream()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	b := &ClientOtelBuilder{Tracer: tracer}
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(b.Build()), grpc.WithStreamInterceptor(b.BuildStream()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+
+	member, err := baggage.NewMember("tenant", "a")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(baggage.ContextWithBaggage(context.Background(), bag), time.Second)
+	defer cancel()
+
+	t.Run("unary", func(t *testing.T) {
+		exporter.Reset()
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "a", gotBaggage)
+		assertLinked(t, exporter, "/grpc.health.v1.Health/Check", codes.OK)
+	})
+
+	t.Run("unary error", func(t *testing.T) {
+		exporter.Reset()
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+		require.Error(t, err)
+		assertLinked(t, exporter, "/grpc.health.v1.Health/Check", codes.NotFound)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		exporter.Reset()
+		streamCtx, streamCancel := context.WithCancel(ctx)
+		stream, err := client.Watch(streamCtx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		// Watch 不会主动结束, 取消之后两边的 span 都会结束
+		streamCancel()
+		assertLinked(t, exporter, "/grpc.health.v1.Health/Watch", codes.Canceled)
+	})
+}
+
+// assertLinked 服务端的 span 是客户端 span 的子 span
+func assertLinked(t *testing.T, exporter *tracetest.InMemoryExporter, name string, code codes.Code) {
+	require.Eventually(t, func() bool {
+		return len(exporter.GetSpans()) == 2
+	}, time.Second, 10*time.Millisecond)
+	var client, server tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, name, span.Name)
+		switch span.SpanKind {
+		case trace.SpanKindClient:
+			client = span
+		case trace.SpanKindServer:
+			server = span
+		}
+	}
+	require.True(t, client.SpanContext.IsValid())
+	assert.Equal(t, client.SpanContext.TraceID(), server.Parent.TraceID())
+	assert.Equal(t, client.SpanContext.SpanID(), server.Parent.SpanID())
+
+	service, method := path.Split(name)
+	for _, span := range []tracetest.SpanStub{client, server} {
+		attrs := attribute.NewSet(span.Attributes...)
+		for key, want := range map[attribute.Key]any{
+			"rpc.system":           "grpc",
+			"rpc.service":          strings.Trim(service, "/"),
+			"rpc.method":           method,
+			"rpc.grpc.status_code": int64(code),
+			"net.peer.name":        "127.0.0.1",
+		} {
+			val, ok := attrs.Value(key)
+			assert.True(t, ok, key)
+			assert.Equal(t, want, val.AsInterface(), key)
+		}
+		// 请求一定有, 出错的时候没有响应
+		msgs := make(map[string]bool, 2)
+		for _, e := range span.Events {
+			eventAttrs := attribute.NewSet(e.Attributes...)
+			typ, _ := eventAttrs.Value("message.type")
+			msgs[typ.AsString()] = true
+		}
+		request, response := "SENT", "RECEIVED"
+		if span.SpanKind == trace.SpanKindServer {
+			request, response = response, request
+		}
+		assert.True(t, msgs[request])
+		assert.Equal(t, code != codes.NotFound, msgs[response])
+	}
+}
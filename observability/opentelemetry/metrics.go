@@ -0,0 +1,252 @@
+package opentelemetry
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"micro/observability/internal/stream"
+	"sync"
+	"time"
+)
+
+// ServerMetricsBuilder 通过 OTel metrics API 记录服务端的 RPC 指标, 指标名遵循 RPC 语义约定
+//
+//	rpc.server.duration 响应时间, 单位毫秒, 流是整个流的持续时间
+//	rpc.server.request.size, rpc.server.response.size 每个消息的大小, 单位字节
+//	rpc.server.active_requests 正在处理的请求数
+//
+// 可以替代 metrics.ServerMetricsBuilder, 通过 OTLP 上报
+type ServerMetricsBuilder struct {
+	// Meter 为 nil 的时候使用全局的 MeterProvider
+	Meter metric.Meter
+
+	once    sync.Once
+	metrics *rpcMetrics
+}
+
+func (b *ServerMetricsBuilder) init() {
+	b.metrics = newRPCMetrics(b.Meter, "server")
+}
+
+func (b *ServerMetricsBuilder) Build() grpc.UnaryServerInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp any, err error) {
+		attrs := rpcAttributes(info.FullMethod)
+		defer b.metrics.start(ctx, attrs)(&err)
+		b.metrics.recordRequest(ctx, attrs, req)
+		resp, err = handler(ctx, req)
+		if err == nil {
+			b.metrics.recordResponse(ctx, attrs, resp)
+		}
+		return
+	}
+}
+
+func (b *ServerMetricsBuilder) BuildStream() grpc.StreamServerInterceptor {
+	b.once.Do(b.init)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) (err error) {
+		attrs := rpcAttributes(info.FullMethod)
+		defer b.metrics.start(ss.Context(), attrs)(&err)
+		err = handler(srv, &metricsServerStream{ServerStream: ss, metrics: b.metrics, attrs: attrs})
+		return
+	}
+}
+
+// StartCall 给没有 grpc 状态码的传输层用, 例如 micro/rpc/middleware
+// 返回的方法在调用结束的时候调用
+func (b *ServerMetricsBuilder) StartCall(ctx context.Context, service, method string, requestSize int) func(responseSize int, failed bool) {
+	b.once.Do(b.init)
+	return b.metrics.startCall(ctx, service, method, requestSize)
+}
+
+// ClientMetricsBuilder 通过 OTel metrics API 记录客户端的 RPC 指标
+// 指标和 ServerMetricsBuilder 一样, 前缀是 rpc.client
+type ClientMetricsBuilder struct {
+	// Meter 为 nil 的时候使用全局的 MeterProvider
+	Meter metric.Meter
+
+	once    sync.Once
+	metrics *rpcMetrics
+}
+
+func (b *ClientMetricsBuilder) init() {
+	b.metrics = newRPCMetrics(b.Meter, "client")
+}
+
+func (b *ClientMetricsBuilder) Build() grpc.UnaryClientInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		attrs := rpcAttributes(method)
+		defer b.metrics.start(ctx, attrs)(&err)
+		b.metrics.recordRequest(ctx, attrs, req)
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			b.metrics.recordResponse(ctx, attrs, reply)
+		}
+		return
+	}
+}
+
+// BuildStream 流结束的时候记录响应时间, 也就是 RecvMsg 返回 error 或者 context 被取消
+func (b *ClientMetricsBuilder) BuildStream() grpc.StreamClientInterceptor {
+	b.once.Do(b.init)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		attrs := rpcAttributes(method)
+		end := b.metrics.start(ctx, attrs)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			end(&err)
+			return nil, err
+		}
+		res := stream.NewClientStream(ctx, cs, desc, func(err error) {
+			end(&err)
+		})
+		res.OnSend = func(m any) {
+			b.metrics.recordRequest(context.Background(), attrs, m)
+		}
+		res.OnRecv = func(m any) {
+			b.metrics.recordResponse(context.Background(), attrs, m)
+		}
+		return res, nil
+	}
+}
+
+// StartCall 同 ServerMetricsBuilder.StartCall
+func (b *ClientMetricsBuilder) StartCall(ctx context.Context, service, method string, requestSize int) func(responseSize int, failed bool) {
+	b.once.Do(b.init)
+	return b.metrics.startCall(ctx, service, method, requestSize)
+}
+
+type rpcMetrics struct {
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+	active       metric.Int64UpDownCounter
+}
+
+// newRPCMetrics side 是 server 或者 client, 创建失败的时候退化为什么都不做
+func newRPCMetrics(meter metric.Meter, side string) *rpcMetrics {
+	if meter == nil {
+		meter = otel.GetMeterProvider().Meter(instrumentationName)
+	}
+	res, err := createRPCMetrics(meter, "rpc."+side+".")
+	if err != nil {
+		otel.Handle(err)
+		res, _ = createRPCMetrics(noop.NewMeterProvider().Meter(instrumentationName), "")
+	}
+	return res
+}
+
+func createRPCMetrics(meter metric.Meter, prefix string) (*rpcMetrics, error) {
+	var (
+		res rpcMetrics
+		err error
+	)
+	res.duration, err = meter.Float64Histogram(prefix+"duration",
+		metric.WithUnit("ms"), metric.WithDescription("RPC 的响应时间"))
+	if err != nil {
+		return nil, err
+	}
+	res.requestSize, err = meter.Int64Histogram(prefix+"request.size",
+		metric.WithUnit("By"), metric.WithDescription("请求消息的大小, 没有压缩"))
+	if err != nil {
+		return nil, err
+	}
+	res.responseSize, err = meter.Int64Histogram(prefix+"response.size",
+		metric.WithUnit("By"), metric.WithDescription("响应消息的大小, 没有压缩"))
+	if err != nil {
+		return nil, err
+	}
+	res.active, err = meter.Int64UpDownCounter(prefix+"active_requests",
+		metric.WithUnit("{request}"), metric.WithDescription("正在处理的请求数"))
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// start 在请求开始的时候调用, 返回的方法在请求结束的时候调用
+// 用 context.WithoutCancel 是因为请求被取消的时候也要记下来
+func (m *rpcMetrics) start(ctx context.Context, attrs []attribute.KeyValue) func(err *error) {
+	ctx = context.WithoutCancel(ctx)
+	set := metric.WithAttributes(attrs...)
+	m.active.Add(ctx, 1, set)
+	startTime := time.Now()
+	return func(err *error) {
+		m.active.Add(ctx, -1, set)
+		s, _ := status.FromError(*err)
+		m.duration.Record(ctx, float64(time.Since(startTime))/float64(time.Millisecond),
+			metric.WithAttributes(append(attrs, semconv.RPCGRPCStatusCodeKey.Int(int(s.Code())))...))
+	}
+}
+
+// recordRequest 只有 proto 的消息才能知道大小
+func (m *rpcMetrics) recordRequest(ctx context.Context, attrs []attribute.KeyValue, msg any) {
+	if pm, ok := msg.(proto.Message); ok {
+		m.requestSize.Record(ctx, int64(proto.Size(pm)), metric.WithAttributes(attrs...))
+	}
+}
+
+func (m *rpcMetrics) recordResponse(ctx context.Context, attrs []attribute.KeyValue, msg any) {
+	if pm, ok := msg.(proto.Message); ok {
+		m.responseSize.Record(ctx, int64(proto.Size(pm)), metric.WithAttributes(attrs...))
+	}
+}
+
+// startCall 这种调用没有状态码, 只区分有没有出错
+// 大小是序列化之后的大小, responseSize 小于 0 表示没有响应
+func (m *rpcMetrics) startCall(ctx context.Context, service, method string, requestSize int) func(responseSize int, failed bool) {
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("micro"),
+		semconv.RPCServiceKey.String(service),
+		semconv.RPCMethodKey.String(method),
+	}
+	ctx = context.WithoutCancel(ctx)
+	set := metric.WithAttributes(attrs...)
+	m.active.Add(ctx, 1, set)
+	m.requestSize.Record(ctx, int64(requestSize), set)
+	startTime := time.Now()
+	return func(responseSize int, failed bool) {
+		m.active.Add(ctx, -1, set)
+		if responseSize >= 0 {
+			m.responseSize.Record(ctx, int64(responseSize), set)
+		}
+		m.duration.Record(ctx, float64(time.Since(startTime))/float64(time.Millisecond),
+			metric.WithAttributes(append(attrs, ErrorKey.Bool(failed))...))
+	}
+}
+
+// ErrorKey StartCall 记录的调用是否出错
+const ErrorKey = attribute.Key("rpc.micro.error")
+
+type metricsServerStream struct {
+	grpc.ServerStream
+	metrics *rpcMetrics
+	attrs   []attribute.KeyValue
+}
+
+func (s *metricsServerStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.metrics.recordRequest(s.Context(), s.attrs, m)
+	}
+	return err
+}
+
+func (s *metricsServerStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.metrics.recordResponse(s.Context(), s.attrs, m)
+	}
+	return err
+}
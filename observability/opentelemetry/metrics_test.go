@@ -0,0 +1,141 @@
+package opentelemetry
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMetricsBuilder(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(instrumentationName)
+
+	sb := &ServerMetricsBuilder{Meter: meter}
+	server := grpc.NewServer(grpc.UnaryInterceptor(sb.Build()), grpc.StreamInterceptor(sb.BuildStream()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	cb := &ClientMetricsBuilder{Meter: meter}
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(cb.Build()), grpc.WithStreamInterceptor(cb.BuildStream()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Error(t, err)
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	watchCancel()
+
+	check := rpcAttributes("/grpc.health.v1.Health/Check")
+	watch := rpcAttributes("/grpc.health.v1.Health/Watch")
+	var got map[string]metricdata.Aggregation
+	// 服务端感知到流被取消是异步的
+	require.Eventually(t, func() bool {
+		got = collect(t, reader)
+		return histogramCount(got["rpc.server.duration"],
+			append(watch, semconv.RPCGRPCStatusCodeKey.Int(int(codes.Canceled)))) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	for _, side := range []string{"server", "client"} {
+		duration := got["rpc."+side+".duration"]
+		assert.Equal(t, uint64(1), histogramCount(duration,
+			append(check, semconv.RPCGRPCStatusCodeKey.Int(int(codes.NotFound)))), side)
+		assert.Equal(t, uint64(1), histogramCount(duration,
+			append(watch, semconv.RPCGRPCStatusCodeKey.Int(int(codes.Canceled)))), side)
+		// 出错的请求没有响应
+		assert.Equal(t, uint64(1), histogramCount(got["rpc."+side+".request.size"], check), side)
+		assert.Equal(t, uint64(0), histogramCount(got["rpc."+side+".response.size"], check), side)
+		assert.Equal(t, uint64(1), histogramCount(got["rpc."+side+".response.size"], watch), side)
+		assert.Equal(t, int64(0), sumValue(got["rpc."+side+".active_requests"], watch), side)
+	}
+}
+
+func TestMetricsBuilder_StartCall(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(instrumentationName)
+
+	(&ServerMetricsBuilder{Meter: meter}).StartCall(context.Background(), "user-service", "GetById", 10)(20, false)
+	(&ClientMetricsBuilder{Meter: meter}).StartCall(context.Background(), "user-service", "GetById", 10)(-1, true)
+
+	got := collect(t, reader)
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("micro"),
+		semconv.RPCServiceKey.String("user-service"),
+		semconv.RPCMethodKey.String("GetById"),
+	}
+	assert.Equal(t, uint64(1), histogramCount(got["rpc.server.duration"], append(attrs, ErrorKey.Bool(false))))
+	assert.Equal(t, uint64(1), histogramCount(got["rpc.server.response.size"], attrs))
+	assert.Equal(t, uint64(1), histogramCount(got["rpc.client.duration"], append(attrs, ErrorKey.Bool(true))))
+	// 没有响应就不记录响应的大小
+	assert.Equal(t, uint64(0), histogramCount(got["rpc.client.response.size"], attrs))
+	for _, side := range []string{"server", "client"} {
+		assert.Equal(t, uint64(1), histogramCount(got["rpc."+side+".request.size"], attrs), side)
+		assert.Equal(t, int64(0), sumValue(got["rpc."+side+".active_requests"], attrs), side)
+	}
+}
+
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	res := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			res[m.Name] = m.Data
+		}
+	}
+	return res
+}
+
+func histogramCount(data metricdata.Aggregation, attrs []attribute.KeyValue) uint64 {
+	set := attribute.NewSet(attrs...)
+	switch h := data.(type) {
+	case metricdata.Histogram[float64]:
+		for _, dp := range h.DataPoints {
+			if dp.Attributes.Equals(&set) {
+				return dp.Count
+			}
+		}
+	case metricdata.Histogram[int64]:
+		for _, dp := range h.DataPoints {
+			if dp.Attributes.Equals(&set) {
+				return dp.Count
+			}
+		}
+	}
+	return 0
+}
+
+func sumValue(data metricdata.Aggregation, attrs []attribute.KeyValue) int64 {
+	set := attribute.NewSet(attrs...)
+	sum, _ := data.(metricdata.Sum[int64])
+	for _, dp := range sum.DataPoints {
+		if dp.Attributes.Equals(&set) {
+			return dp.Value
+		}
+	}
+	return -1
+}
@@ -0,0 +1,83 @@
+package opentelemetry
+
+import (
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const payloadKey = attribute.Key("message.payload")
+
+// PayloadCapture 把请求和响应的内容记录到 span 的 message 事件上
+// 会增加序列化的开销和链路数据的大小, 一般只在排查问题的时候打开
+type PayloadCapture struct {
+	// Redact 需要脱敏的字段, 按照 proto 里面的字段名匹配, 嵌套的字段也会脱敏, 例如 password
+	Redact []string
+	// MaxSize 最多记录多少字节, 超过截断, 默认 1024
+	MaxSize int
+}
+
+const redacted = "***"
+
+func (p *PayloadCapture) format(msg any) string {
+	var (
+		data []byte
+		err  error
+	)
+	if pm, ok := msg.(proto.Message); ok {
+		data, err = protojson.MarshalOptions{UseProtoNames: true}.Marshal(pm)
+	} else {
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if len(p.Redact) > 0 {
+		data = p.redact(data)
+	}
+	maxSize := p.MaxSize
+	if maxSize <= 0 {
+		maxSize = 1024
+	}
+	if len(data) > maxSize {
+		return string(data[:maxSize]) + "..."
+	}
+	return string(data)
+}
+
+// redact 解析出 JSON 之后把敏感字段替换掉
+func (p *PayloadCapture) redact(data []byte) []byte {
+	var val any
+	if err := json.Unmarshal(data, &val); err != nil {
+		// 脱敏失败不能把原文记下来
+		return []byte(redacted)
+	}
+	fields := make(map[string]struct{}, len(p.Redact))
+	for _, f := range p.Redact {
+		fields[f] = struct{}{}
+	}
+	res, err := json.Marshal(redactValue(val, fields))
+	if err != nil {
+		return []byte(redacted)
+	}
+	return res
+}
+
+func redactValue(val any, fields map[string]struct{}) any {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, sub := range v {
+			if _, ok := fields[key]; ok {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(sub, fields)
+		}
+	case []any:
+		for i, sub := range v {
+			v[i] = redactValue(sub, fields)
+		}
+	}
+	return val
+}
@@ -0,0 +1,50 @@
+package opentelemetry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"micro/proto/gen"
+	"testing"
+)
+
+func TestPayloadCapture_Format(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload *PayloadCapture
+		msg     any
+
+		want string
+	}{
+		{
+			name:    "proto",
+			payload: &PayloadCapture{},
+			msg:     &gen.GetByIdReq{Id: 123},
+			want:    `{"id":"123"}`,
+		},
+		{
+			name:    "redact nested",
+			payload: &PayloadCapture{Redact: []string{"password"}},
+			msg: map[string]any{
+				"name":  "Tom",
+				"users": []any{map[string]any{"password": "123456"}},
+			},
+			want: `{"name":"Tom","users":[{"password":"***"}]}`,
+		},
+		{
+			name:    "truncate",
+			payload: &PayloadCapture{MaxSize: 5},
+			msg:     map[string]any{"name": "Tom"},
+			want:    `{"nam...`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.payload.format(tc.msg)
+			if tc.payload.MaxSize == 0 {
+				assert.JSONEq(t, tc.want, got)
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
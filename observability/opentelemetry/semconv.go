@@ -0,0 +1,70 @@
+package opentelemetry
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rpcAttributes /users.UserService/GetById => rpc.service=users.UserService, rpc.method=GetById
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	res := []attribute.KeyValue{semconv.RPCSystemGRPC}
+	name := strings.TrimPrefix(fullMethod, "/")
+	idx := strings.LastIndexByte(name, '/')
+	if idx < 0 {
+		return append(res, semconv.RPCMethodKey.String(name))
+	}
+	return append(res, semconv.RPCServiceKey.String(name[:idx]), semconv.RPCMethodKey.String(name[idx+1:]))
+}
+
+// peerAttributes 对端的地址, 客户端是服务端的地址, 服务端是客户端的地址
+func peerAttributes(addr net.Addr) []attribute.KeyValue {
+	if addr == nil {
+		return nil
+	}
+	return hostPortAttributes(addr.String(), semconv.NetPeerNameKey, semconv.NetPeerPortKey)
+}
+
+func hostPortAttributes(addr string, hostKey, portKey attribute.Key) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if addr == "" {
+			return nil
+		}
+		return []attribute.KeyValue{hostKey.String(addr)}
+	}
+	res := []attribute.KeyValue{hostKey.String(host)}
+	if portNum, err := strconv.Atoi(port); err == nil {
+		res = append(res, portKey.Int(portNum))
+	}
+	return res
+}
+
+// messageEvent 记录收发的消息, 大小是序列化之后没有压缩的大小
+func messageEvent(span trace.Span, typ attribute.KeyValue, id int, msg any, payload *PayloadCapture) {
+	attrs := []attribute.KeyValue{typ, semconv.MessageIDKey.Int(id)}
+	if pm, ok := msg.(proto.Message); ok {
+		attrs = append(attrs, semconv.MessageUncompressedSizeKey.Int(proto.Size(pm)))
+	}
+	if payload != nil {
+		attrs = append(attrs, payloadKey.String(payload.format(msg)))
+	}
+	span.AddEvent("message", trace.WithAttributes(attrs...))
+}
+
+// endSpan 记录状态码之后结束 span
+func endSpan(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(s.Code())))
+	if err != nil {
+		span.SetStatus(codes.Error, s.Message())
+		span.RecordError(err)
+	}
+	span.End()
+}
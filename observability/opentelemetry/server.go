@@ -2,14 +2,13 @@ package opentelemetry
 
 import (
 	"context"
-	"fmt"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"micro/observability"
 )
 
@@ -18,39 +17,101 @@ const instrumentationName = "micro/observability/opentelemetry"
 type ServerOtelBuilder struct {
 	Tracer trace.Tracer
 	Port int
+	// Payload 不为 nil 的时候记录请求和响应的内容
+	Payload *PayloadCapture
 }
 
 func (b *ServerOtelBuilder) Build() grpc.UnaryServerInterceptor {
-	if b.Tracer == nil {
-		b.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
-	}
-	addr := observability.GetOutboundIP()
-	if b.Port != 0 {
-		addr = fmt.Sprintf("%s:%d", addr, b.Port)
-	}
-	
+	hostAttrs := b.init()
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (resp any, err error) {
 		// 服务端的 tracing 需要关联上游
-		ctx = b.extract(ctx)
-		spanCtx, span := b.Tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
-		span.SetAttributes(attribute.String("address", addr))
+		spanCtx, span := b.start(ctx, info.FullMethod, hostAttrs)
+		messageEvent(span, semconv.MessageTypeReceived, 1, req, b.Payload)
 		defer func() {
-			if err != nil {
-				span.SetStatus(codes.Error, err.Error())
-				span.RecordError(err)
+			if err == nil {
+				messageEvent(span, semconv.MessageTypeSent, 1, resp, b.Payload)
 			}
-			span.End()
+			endSpan(span, err)
 		}()
 		resp, err = handler(spanCtx, req)
-		return 
+		return
+	}
+}
+
+// BuildStream 一个流一个 span, 每个消息记录为 span 上的事件
+func (b *ServerOtelBuilder) BuildStream() grpc.StreamServerInterceptor {
+	hostAttrs := b.init()
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) (err error) {
+		spanCtx, span := b.start(ss.Context(), info.FullMethod, hostAttrs)
+		defer func() {
+			endSpan(span, err)
+		}()
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: spanCtx, span: span, payload: b.Payload})
+		return
 	}
 }
 
+// init 返回本机地址的属性
+func (b *ServerOtelBuilder) init() []attribute.KeyValue {
+	if b.Tracer == nil {
+		b.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+	res := []attribute.KeyValue{semconv.NetHostNameKey.String(observability.GetOutboundIP())}
+	if b.Port != 0 {
+		res = append(res, semconv.NetHostPortKey.Int(b.Port))
+	}
+	return res
+}
+
+func (b *ServerOtelBuilder) start(ctx context.Context, fullMethod string,
+	hostAttrs []attribute.KeyValue) (context.Context, trace.Span) {
+	attrs := append(rpcAttributes(fullMethod), hostAttrs...)
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, peerAttributes(p.Addr)...)
+	}
+	return b.Tracer.Start(b.extract(ctx), fullMethod,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// serverStream 替换 context, 让业务代码可以继续往下传递 span
+type serverStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	span    trace.Span
+	payload *PayloadCapture
+	// 收发消息的序号
+	received int
+	sent     int
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+		messageEvent(s.span, semconv.MessageTypeReceived, s.received, m, s.payload)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+		messageEvent(s.span, semconv.MessageTypeSent, s.sent, m, s.payload)
+	}
+	return err
+}
+
 func (b *ServerOtelBuilder) extract(ctx context.Context) context.Context {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		md = metadata.MD{}
 	}
-	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(md))
-}
\ No newline at end of file
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
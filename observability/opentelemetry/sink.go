@@ -0,0 +1,89 @@
+package opentelemetry
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"micro/observability"
+	"sync"
+)
+
+// MetricsSink 把框架内部的指标上报到 OpenTelemetry
+// 当前版本的 otel 没有同步的 Gauge, SetGauge 只是记下最新的值, 采集的时候由回调上报
+type MetricsSink struct {
+	meter metric.Meter
+
+	mutex    sync.Mutex
+	counters map[string]metric.Int64Counter
+	gauges   map[string]map[attribute.Distinct]gaugeValue
+}
+
+type gaugeValue struct {
+	attrs attribute.Set
+	value float64
+}
+
+var _ observability.Sink = &MetricsSink{}
+
+// NewMetricsSink meter 为 nil 的时候使用全局的 MeterProvider
+func NewMetricsSink(meter metric.Meter) *MetricsSink {
+	if meter == nil {
+		meter = otel.GetMeterProvider().Meter(instrumentationName)
+	}
+	return &MetricsSink{
+		meter:    meter,
+		counters: make(map[string]metric.Int64Counter, 8),
+		gauges:   make(map[string]map[attribute.Distinct]gaugeValue, 8),
+	}
+}
+
+func (m *MetricsSink) IncCounter(name string, labels observability.Labels) {
+	m.mutex.Lock()
+	c, ok := m.counters[name]
+	if !ok {
+		var err error
+		c, err = m.meter.Int64Counter(name)
+		if err != nil {
+			m.mutex.Unlock()
+			otel.Handle(err)
+			return
+		}
+		m.counters[name] = c
+	}
+	m.mutex.Unlock()
+	c.Add(context.Background(), 1, metric.WithAttributeSet(attributeSet(labels)))
+}
+
+func (m *MetricsSink) SetGauge(name string, value float64, labels observability.Labels) {
+	attrs := attributeSet(labels)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	values, ok := m.gauges[name]
+	if !ok {
+		values = make(map[attribute.Distinct]gaugeValue, 4)
+		_, err := m.meter.Float64ObservableGauge(name,
+			metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+				m.mutex.Lock()
+				defer m.mutex.Unlock()
+				for _, v := range m.gauges[name] {
+					o.Observe(v.value, metric.WithAttributeSet(v.attrs))
+				}
+				return nil
+			}))
+		if err != nil {
+			otel.Handle(err)
+			return
+		}
+		m.gauges[name] = values
+	}
+	values[attrs.Equivalent()] = gaugeValue{attrs: attrs, value: value}
+}
+
+func attributeSet(labels observability.Labels) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return attribute.NewSet(kvs...)
+}
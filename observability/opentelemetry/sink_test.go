@@ -0,0 +1,40 @@
+package opentelemetry
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"micro/observability"
+	"testing"
+)
+
+func TestMetricsSink(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(instrumentationName)
+	sink := NewMetricsSink(meter)
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8081"})
+	sink.IncCounter("picker_picks_total", observability.Labels{"picker": "round_robin", "target": "127.0.0.1:8081"})
+	sink.SetGauge("resolver_instances", 3, observability.Labels{"service": "user-service"})
+	sink.SetGauge("resolver_instances", 2, observability.Labels{"service": "user-service"})
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	got := make(map[string]metricdata.Aggregation, 2)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		got[m.Name] = m.Data
+	}
+
+	sum := got["picker_picks_total"].(metricdata.Sum[int64])
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, int64(2), sum.DataPoints[0].Value)
+	assert.Equal(t, attribute.NewSet(attribute.String("picker", "round_robin"),
+		attribute.String("target", "127.0.0.1:8081")), sum.DataPoints[0].Attributes)
+
+	gauge := got["resolver_instances"].(metricdata.Gauge[float64])
+	require.Len(t, gauge.DataPoints, 1)
+	assert.Equal(t, 2.0, gauge.DataPoints[0].Value)
+}
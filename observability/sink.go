@@ -0,0 +1,28 @@
+package observability
+
+// Sink 框架内部组件(负载均衡, 服务发现, 注册中心, 限流)上报指标的接口
+// metrics.PrometheusSink 和 opentelemetry.MetricsSink 分别对接 prometheus 和 OpenTelemetry
+// 同一个 name 的 labels 的 key 必须一致, 实现需要并发安全
+type Sink interface {
+	// IncCounter 计数器加一, 例如选中某个节点的次数
+	IncCounter(name string, labels Labels)
+	// SetGauge 设置当前值, 例如节点列表的长度
+	SetGauge(name string, value float64, labels Labels)
+}
+
+type Labels map[string]string
+
+// NopSink 什么都不做, 没有配置 Sink 的时候使用
+type NopSink struct{}
+
+func (NopSink) IncCounter(name string, labels Labels) {}
+
+func (NopSink) SetGauge(name string, value float64, labels Labels) {}
+
+// SinkOrNop s 为 nil 的时候返回 NopSink
+func SinkOrNop(s Sink) Sink {
+	if s == nil {
+		return NopSink{}
+	}
+	return s
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"sync/atomic"
 	"time"
 )
 
 type FixWindowLimiter struct {
+	reporter
 	// 窗口的起始时间
 	timestamp int64
 	// 窗口大小
@@ -29,21 +31,16 @@ func NewFixWindowLimiter(interval time.Duration, rate int64) *FixWindowLimiter {
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (f *FixWindowLimiter) WithSink(sink observability.Sink) *FixWindowLimiter {
+	f.reporter = reporter{limiter: "fix_window", sink: sink}
+	return f
+}
+
 func (f *FixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		cur := time.Now().UnixNano()
-		timestamp := atomic.LoadInt64(&f.timestamp)
-		cnt := atomic.LoadInt64(&f.cnt)
-		if timestamp + f.interval < cur {
-			// 开新窗口
-			// 用原子操作
-			if atomic.CompareAndSwapInt64(&f.timestamp, timestamp, cur) {
-				atomic.CompareAndSwapInt64(&f.cnt, cnt, 0)
-			}
-		}
-		cnt = atomic.AddInt64(&f.cnt, 1)
-		if cnt > f.rate {
-			err = errors.New("触发瓶颈了")
+		if err = f.acquire(ctx); err != nil {
+			f.rejected(info.FullMethod)
 			return
 		}
 		resp, err = handler(ctx, req)
@@ -51,6 +48,29 @@ func (f *FixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor
 	}
 }
 
+// BuildStreamServerInterceptor 一个流算一个请求
+func (f *FixWindowLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(f.acquire, f.rejected)
+}
+
+func (f *FixWindowLimiter) acquire(ctx context.Context) error {
+	cur := time.Now().UnixNano()
+	timestamp := atomic.LoadInt64(&f.timestamp)
+	cnt := atomic.LoadInt64(&f.cnt)
+	if timestamp + f.interval < cur {
+		// 开新窗口
+		// 用原子操作
+		if atomic.CompareAndSwapInt64(&f.timestamp, timestamp, cur) {
+			atomic.CompareAndSwapInt64(&f.cnt, cnt, 0)
+		}
+	}
+	cnt = atomic.AddInt64(&f.cnt, 1)
+	if cnt > f.rate {
+		return errors.New("触发瓶颈了")
+	}
+	return nil
+}
+
 //func (f *FixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 //	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 //		f.mutex.Lock()
@@ -71,3 +91,14 @@ func (f *FixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor
 //		return 
 //	}
 //}
+
+// Inspect 调试接口展示的内部状态
+func (f *FixWindowLimiter) Inspect() any {
+	return map[string]any{
+		"limiter":      "fix_window",
+		"window_start": time.Unix(0, atomic.LoadInt64(&f.timestamp)),
+		"interval":     time.Duration(f.interval).String(),
+		"count":        atomic.LoadInt64(&f.cnt),
+		"rate":         f.rate,
+	}
+}
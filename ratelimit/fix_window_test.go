@@ -3,9 +3,11 @@ package ratelimit
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"micro/proto/gen"
 	"testing"
 	"time"
@@ -33,3 +35,28 @@ func TestFixWindowLimiter_BuildServerInterceptor(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, &gen.GetByIdResp{}, resp)
 }
+
+func TestFixWindowLimiter_Sink(t *testing.T) {
+	sink := &memSink{}
+	interceptor := NewFixWindowLimiter(time.Minute, 1).WithSink(sink).BuildServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/UserService/GetById"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return &gen.GetByIdResp{}, nil
+	}
+	_, err := interceptor(context.Background(), &gen.GetByIdReq{}, info, handler)
+	require.NoError(t, err)
+	assert.Empty(t, sink.calls)
+
+	_, err = interceptor(context.Background(), &gen.GetByIdReq{}, info, handler)
+	require.Error(t, err)
+	assert.Equal(t, []string{"ratelimit_rejected_total{limiter=fix_window,method=/UserService/GetById}"}, sink.calls)
+}
+
+type memSink struct {
+	observability.NopSink
+	calls []string
+}
+
+func (m *memSink) IncCounter(name string, labels observability.Labels) {
+	m.calls = append(m.calls, fmt.Sprintf("%s{limiter=%s,method=%s}", name, labels["limiter"], labels["method"]))
+}
@@ -3,10 +3,12 @@ package ratelimit
 import (
 	"context"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"time"
 )
 
 type LeakyBucketLimiter struct {
+	reporter
 	producer *time.Ticker
 }
 
@@ -16,19 +18,42 @@ func NewLeakyBucketLimiter(interval time.Duration) *LeakyBucketLimiter {
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (l *LeakyBucketLimiter) WithSink(sink observability.Sink) *LeakyBucketLimiter {
+	l.reporter = reporter{limiter: "leaky_bucket", sink: sink}
+	return l
+}
+
 func (l *LeakyBucketLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		select {
-		case <-ctx.Done():
-			err = ctx.Err()
-			return 
-		case <-l.producer.C:
-			resp, err = handler(ctx, req)
+		if err = l.acquire(ctx); err != nil {
+			l.rejected(info.FullMethod)
+			return
 		}
+		resp, err = handler(ctx, req)
 		return 
 	}
 }
 
+// BuildStreamServerInterceptor 一个流等一个漏出的请求
+func (l *LeakyBucketLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(l.acquire, l.rejected)
+}
+
+// BuildStreamMessageInterceptor 流上每收到一个消息都要等, 把消息处理速度限制在固定的速率
+func (l *LeakyBucketLimiter) BuildStreamMessageInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamMessageInterceptor(l.acquire, l.rejected)
+}
+
+func (l *LeakyBucketLimiter) acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.producer.C:
+		return nil
+	}
+}
+
 func (l *LeakyBucketLimiter) Close() error {
 	l.producer.Stop()
 	return nil
@@ -0,0 +1,19 @@
+package ratelimit
+
+import "micro/observability"
+
+// reporter 嵌入到每个限流器里面, 上报被拒绝的请求
+type reporter struct {
+	limiter string
+	sink    observability.Sink
+}
+
+func (r reporter) rejected(method string) {
+	if r.sink == nil {
+		return
+	}
+	r.sink.IncCounter("ratelimit_rejected_total", observability.Labels{
+		"limiter": r.limiter,
+		"method":  method,
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"time"
 )
 
@@ -13,6 +14,7 @@ import (
 var luaFixWindow string
 
 type RedisFixWindowLimiter struct {
+	reporter
 	client redis.Cmdable
 	
 	service string
@@ -30,19 +32,20 @@ func NewRedisFixWindowLimiter(client redis.Cmdable, service string, interval tim
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (r *RedisFixWindowLimiter) WithSink(sink observability.Sink) *RedisFixWindowLimiter {
+	r.reporter = reporter{limiter: "redis_fix_window", sink: sink}
+	return r
+}
+
 func (r *RedisFixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		// 我预期 lua 脚本会返回一个 bool 值，告诉我要不要限流
 		// 使用 FullMethod，那就是单一方法上限流，比如说 GetById
 		// 使用服务名来限流，那就是在单一服务上 users.UserService
 		// 使用应用名，user-service
-		limit, err := r.limit(ctx)
-		if err != nil {
-			return 
-		}
-		if limit {
-			err = errors.New("触及了瓶颈")
-			//resp= 默认值
+		if err = r.acquire(ctx); err != nil {
+			r.rejected(info.FullMethod)
 			return
 		}
 		resp, err = handler(ctx, req)
@@ -50,6 +53,22 @@ func (r *RedisFixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterce
 	}
 }
 
+// BuildStreamServerInterceptor 一个流算一个请求
+func (r *RedisFixWindowLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(r.acquire, r.rejected)
+}
+
+func (r *RedisFixWindowLimiter) acquire(ctx context.Context) error {
+	limit, err := r.limit(ctx)
+	if err != nil {
+		return err
+	}
+	if limit {
+		return errors.New("触及了瓶颈")
+	}
+	return nil
+}
+
 func (r *RedisFixWindowLimiter) limit(ctx context.Context) (bool, error) {
 	return r.client.Eval(ctx, luaFixWindow, []string{r.service}, r.interval, r.rate).Bool()
 }
\ No newline at end of file
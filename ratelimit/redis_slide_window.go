@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"time"
 )
 
@@ -14,6 +15,7 @@ import (
 var luaSlideWindow string
 
 type RedisSlideWindowLimiter struct {
+	reporter
 	client redis.Cmdable
 	// 例如 user-service
 	service string
@@ -32,6 +34,12 @@ func NewRedisSlideWindowLimiter(client redis.Cmdable, service string,
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (t *RedisSlideWindowLimiter) WithSink(sink observability.Sink) *RedisSlideWindowLimiter {
+	t.reporter = reporter{limiter: "redis_slide_window", sink: sink}
+	return t
+}
+
 func (t *RedisSlideWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (resp interface{}, err error) {
@@ -39,12 +47,8 @@ func (t *RedisSlideWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInter
 		// 使用 FullMethod，那就是单一方法上限流，比如说 GetById
 		// 使用服务名来限流，那就是在单一服务上 users.UserService
 		// 使用应用名，user-service
-		limit, err := t.limit(ctx)
-		if err != nil {
-			return
-		}
-		if limit {
-			err = errors.New("触及了瓶颈")
+		if err = t.acquire(ctx); err != nil {
+			t.rejected(info.FullMethod)
 			return
 		}
 		resp, err = handler(ctx, req)
@@ -52,6 +56,22 @@ func (t *RedisSlideWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInter
 	}
 }
 
+// BuildStreamServerInterceptor 一个流算一个请求
+func (t *RedisSlideWindowLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(t.acquire, t.rejected)
+}
+
+func (t *RedisSlideWindowLimiter) acquire(ctx context.Context) error {
+	limit, err := t.limit(ctx)
+	if err != nil {
+		return err
+	}
+	if limit {
+		return errors.New("触及了瓶颈")
+	}
+	return nil
+}
+
 func (t *RedisSlideWindowLimiter) limit(ctx context.Context) (bool, error){
 	// redis 传时间戳, 要用 ms
 	return t.client.Eval(ctx, luaSlideWindow, []string{t.service},
@@ -5,11 +5,13 @@ import (
 	"context"
 	"errors"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"sync"
 	"time"
 )
 
 type SlideWindowLimiter struct {
+	reporter
 	queue *list.List
 	interval int64
 	rate int
@@ -24,37 +26,63 @@ func NewSlideWindowLimiter(interval time.Duration, rate int) *SlideWindowLimiter
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (s *SlideWindowLimiter) WithSink(sink observability.Sink) *SlideWindowLimiter {
+	s.reporter = reporter{limiter: "slide_window", sink: sink}
+	return s
+}
+
 func (s *SlideWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		now := time.Now().UnixNano()
-		boundary := now - s.interval
-		
-		// 快路径: 窗口计数没有超过时不进行删除
-		s.mutex.Lock()  
-		length := s.queue.Len()
-		if length < s.rate {
-			resp, err = handler(ctx, req)
-			s.queue.PushBack(now)
-			s.mutex.Unlock()
-			return 
-		}
-		
-		// 慢路径
-		timestamp := s.queue.Front()
-		for timestamp != nil && timestamp.Value.(int64) < boundary {
-			s.queue.Remove(timestamp)
-			timestamp = s.queue.Front()
-		}
-		length = s.queue.Len()
-		if length >= s.rate {
-			err = errors.New("触发瓶颈了")
-			s.mutex.Unlock()
+		if err = s.acquire(ctx); err != nil {
+			s.rejected(info.FullMethod)
 			return
 		}
 		resp, err = handler(ctx, req)
-		// 记住了请求的时间戳
-		s.queue.PushBack(now)
-		s.mutex.Unlock()
 		return
 	}
 }
+
+// BuildStreamServerInterceptor 一个流算一个请求
+func (s *SlideWindowLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(s.acquire, s.rejected)
+}
+
+// acquire 只在判断窗口的时候加锁, 不能把整个流都锁住
+func (s *SlideWindowLimiter) acquire(ctx context.Context) error {
+	now := time.Now().UnixNano()
+	boundary := now - s.interval
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// 快路径: 窗口计数没有超过时不进行删除
+	if s.queue.Len() < s.rate {
+		s.queue.PushBack(now)
+		return nil
+	}
+
+	// 慢路径
+	timestamp := s.queue.Front()
+	for timestamp != nil && timestamp.Value.(int64) < boundary {
+		s.queue.Remove(timestamp)
+		timestamp = s.queue.Front()
+	}
+	if s.queue.Len() >= s.rate {
+		return errors.New("触发瓶颈了")
+	}
+	// 记住了请求的时间戳
+	s.queue.PushBack(now)
+	return nil
+}
+
+// Inspect 调试接口展示的内部状态, count 里面可能还有已经滑出窗口但是没有删掉的请求
+func (s *SlideWindowLimiter) Inspect() any {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return map[string]any{
+		"limiter":  "slide_window",
+		"interval": time.Duration(s.interval).String(),
+		"count":    s.queue.Len(),
+		"rate":     s.rate,
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"google.golang.org/grpc"
+)
+
+// buildStreamServerInterceptor 建立流的时候限流, 流上的消息不再限流
+func buildStreamServerInterceptor(acquire func(ctx context.Context) error,
+	rejected func(method string)) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := acquire(ss.Context()); err != nil {
+			rejected(info.FullMethod)
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// buildStreamMessageInterceptor 流上每收到一个消息都要限流
+// 被限流的时候 RecvMsg 返回 error, 由业务决定是结束流还是继续
+func buildStreamMessageInterceptor(acquire func(ctx context.Context) error,
+	rejected func(method string)) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &limitedStream{
+			ServerStream: ss,
+			acquire:      acquire,
+			rejected: func() {
+				rejected(info.FullMethod)
+			},
+		})
+	}
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	acquire  func(ctx context.Context) error
+	rejected func()
+}
+
+func (s *limitedStream) RecvMsg(m any) error {
+	if err := s.acquire(s.Context()); err != nil {
+		s.rejected()
+		return err
+	}
+	return s.ServerStream.RecvMsg(m)
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter_BuildStreamInterceptor(t *testing.T) {
+	testCases := []struct {
+		name        string
+		interceptor func(l *TokenBucketLimiter) grpc.StreamServerInterceptor
+		tokens      int
+
+		wantErr  error
+		wantRecv []error
+	}{
+		{
+			name: "stream limited",
+			interceptor: func(l *TokenBucketLimiter) grpc.StreamServerInterceptor {
+				return l.BuildStreamServerInterceptor()
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "stream",
+			interceptor: func(l *TokenBucketLimiter) grpc.StreamServerInterceptor {
+				return l.BuildStreamServerInterceptor()
+			},
+			tokens:   1,
+			wantRecv: []error{nil, nil, nil},
+		},
+		{
+			name: "message",
+			interceptor: func(l *TokenBucketLimiter) grpc.StreamServerInterceptor {
+				return l.BuildStreamMessageInterceptor()
+			},
+			tokens:   2,
+			wantRecv: []error{nil, nil, context.DeadlineExceeded},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &TokenBucketLimiter{
+				tokens: make(chan struct{}, tc.tokens),
+				close:  make(chan struct{}),
+			}
+			for i := 0; i < tc.tokens; i++ {
+				l.tokens <- struct{}{}
+			}
+			// 没有令牌的时候不要一直等
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			ss := &mockServerStream{ctx: ctx}
+			var recv []error
+			err := tc.interceptor(l)(nil, ss, &grpc.StreamServerInfo{},
+				func(srv any, stream grpc.ServerStream) error {
+					for i := 0; i < 3; i++ {
+						recv = append(recv, stream.RecvMsg(nil))
+					}
+					return nil
+				})
+			assert.True(t, errors.Is(err, tc.wantErr))
+			assert.Equal(t, tc.wantRecv, recv)
+		})
+	}
+}
+
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (m *mockServerStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockServerStream) RecvMsg(msg any) error {
+	return nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"google.golang.org/grpc"
+	"micro/observability"
 	"time"
 )
 
 type TokenBucketLimiter struct {
+	reporter
 	tokens chan struct{}
 	close  chan struct{}
 }
@@ -40,24 +42,50 @@ func NewTokenBucketLimiter(capacity int, interval time.Duration) *TokenBucketLim
 	}
 }
 
+// WithSink 上报被拒绝的请求 ratelimit_rejected_total, 在使用限流器之前调用
+func (t *TokenBucketLimiter) WithSink(sink observability.Sink) *TokenBucketLimiter {
+	t.reporter = reporter{limiter: "token_bucket", sink: sink}
+	return t
+}
+
 func (t *TokenBucketLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		select {
-		case <-t.close:
-			// 你已经关掉故障检测了
-			//resp, err = handler(ctx, req)
-			err = errors.New("缺乏保护，拒绝请求")
-		case <-ctx.Done():
-			err = ctx.Err()
+		if err = t.acquire(ctx); err != nil {
+			t.rejected(info.FullMethod)
 			return
-		case <-t.tokens:
-			resp, err = handler(ctx, req)
 		}
+		resp, err = handler(ctx, req)
 		return 
 	}
 }
 
+// BuildStreamServerInterceptor 一个流拿一个令牌
+func (t *TokenBucketLimiter) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamServerInterceptor(t.acquire, t.rejected)
+}
+
+// BuildStreamMessageInterceptor 流上每收到一个消息拿一个令牌, 适合长时间的双向流
+func (t *TokenBucketLimiter) BuildStreamMessageInterceptor() grpc.StreamServerInterceptor {
+	return buildStreamMessageInterceptor(t.acquire, t.rejected)
+}
+
+func (t *TokenBucketLimiter) acquire(ctx context.Context) error {
+	select {
+	case <-t.close:
+		// 你已经关掉故障检测了
+		return errors.New("缺乏保护，拒绝请求")
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.tokens:
+		return nil
+	}
+}
+
 func (t *TokenBucketLimiter) Close() error {
 	close(t.close)
 	return nil
-}
\ No newline at end of file
+}
+// Inspect 调试接口展示的内部状态
+func (t *TokenBucketLimiter) Inspect() any {
+	return map[string]any{"limiter": "token_bucket", "available": len(t.tokens), "capacity": cap(t.tokens)}
+}
@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"maps"
+	"micro/registry"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler 注册中心的运维接口, 挂载的时候可以配合 http.StripPrefix 使用
+//
+//	GET  /services                                 所有服务名
+//	GET  /services/{name}                          服务的所有节点
+//	PUT  /services/{name}/instances/{id}/weight    调整节点权重, body 为 {"weight": 10}, 被摘除的节点会恢复
+//	POST /services/{name}/instances/{id}/drain     摘除节点, 带上 registry.MetadataDrained
+//
+// {id} 是节点的 InstanceID, 没有 InstanceID 的节点用 Address
+type Handler struct {
+	r       registry.Registry
+	auth    Authenticator
+	timeout time.Duration
+	mux     *http.ServeMux
+}
+
+// Authenticator 返回 error 的时候拒绝请求
+type Authenticator func(req *http.Request) error
+
+var errUnauthorized = errors.New("micro: 没有权限")
+
+// BearerToken 要求请求带上 Authorization: Bearer token
+func BearerToken(token string) Authenticator {
+	return func(req *http.Request) error {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return errUnauthorized
+		}
+		return nil
+	}
+}
+
+// NewHandler 运维接口能摘除节点, 所以必须传入 auth, 为 nil 的时候拒绝所有请求
+func NewHandler(r registry.Registry, auth Authenticator) *Handler {
+	res := &Handler{
+		r:       r,
+		auth:    auth,
+		timeout: 3 * time.Second,
+		mux:     http.NewServeMux(),
+	}
+	res.mux.HandleFunc("GET /services", res.listServiceNames)
+	res.mux.HandleFunc("GET /services/{name}", res.listServices)
+	res.mux.HandleFunc("PUT /services/{name}/instances/{id}/weight", res.setWeight)
+	res.mux.HandleFunc("POST /services/{name}/instances/{id}/drain", res.drain)
+	return res
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.auth == nil || h.auth(req) != nil {
+		writeError(w, errUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, req)
+}
+
+func (h *Handler) listServiceNames(w http.ResponseWriter, req *http.Request) {
+	c, ok := h.r.(registry.Catalog)
+	if !ok {
+		writeError(w, registry.ErrNotSupported)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+	names, err := c.ListServiceNames(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, names)
+}
+
+func (h *Handler) listServices(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+	ins, err := h.r.ListServices(ctx, req.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, ins)
+}
+
+func (h *Handler) setWeight(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Weight uint32 `json:"weight"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+	si, err := h.find(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	si.Weight = body.Weight
+	if si.Drained() {
+		if d, ok := h.r.(registry.Drainer); ok {
+			if err = d.Drain(ctx, si, false); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		si.Metadata = maps.Clone(si.Metadata)
+		delete(si.Metadata, registry.MetadataDrained)
+	}
+	if err = h.update(ctx, si); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, si)
+}
+
+// drain 不用 UnRegister, 不然节点续约或者重启之后又会出现, 也看不到哪些节点被摘除了
+// 注册中心实现了 registry.Drainer 的时候摘除状态单独保存, 节点重新注册也不会丢
+// 否则只能把权重设为 0 并且改 Metadata, 节点自己重新注册的时候会被覆盖掉
+func (h *Handler) drain(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+	si, err := h.find(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if d, ok := h.r.(registry.Drainer); ok {
+		if err = d.Drain(ctx, si, true); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, markDrained(si))
+		return
+	}
+	si.Weight = 0
+	si = markDrained(si)
+	if err = h.update(ctx, si); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, si)
+}
+
+func markDrained(si registry.ServiceInstance) registry.ServiceInstance {
+	si.Metadata = maps.Clone(si.Metadata)
+	if si.Metadata == nil {
+		si.Metadata = make(map[string]string, 1)
+	}
+	si.Metadata[registry.MetadataDrained] = "true"
+	return si
+}
+
+// update 优先原地更新, 否则会把节点绑定到当前进程的生命周期上
+func (h *Handler) update(ctx context.Context, si registry.ServiceInstance) error {
+	if u, ok := h.r.(registry.Updater); ok {
+		return u.Update(ctx, si)
+	}
+	return h.r.Register(ctx, si)
+}
+
+var errInstanceNotFound = errors.New("micro: 节点不存在")
+
+func (h *Handler) find(ctx context.Context, req *http.Request) (registry.ServiceInstance, error) {
+	ins, err := h.r.ListServices(ctx, req.PathValue("name"))
+	if err != nil {
+		return registry.ServiceInstance{}, err
+	}
+	id := req.PathValue("id")
+	for _, si := range ins {
+		if si.InstanceID == id || (si.InstanceID == "" && si.Address == id) {
+			return si, nil
+		}
+	}
+	return registry.ServiceInstance{}, errInstanceNotFound
+}
+
+func writeJSON(w http.ResponseWriter, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(val)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errUnauthorized):
+		code = http.StatusUnauthorized
+	case errors.Is(err, errInstanceNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, registry.ErrNotSupported):
+		code = http.StatusNotImplemented
+	}
+	http.Error(w, err.Error(), code)
+}
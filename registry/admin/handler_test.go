@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/registry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	r := &memRegistry{instances: []registry.ServiceInstance{
+		{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 10},
+		{Name: "user-service", Address: "127.0.0.1:8082", Weight: 10},
+	}}
+	h := NewHandler(r, BearerToken("secret"))
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+
+		wantCode int
+		wantBody string
+		wantIns  []registry.ServiceInstance
+	}{
+		{
+			name:     "no token",
+			method:   http.MethodGet,
+			path:     "/services",
+			token:    "-",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong token",
+			method:   http.MethodPost,
+			path:     "/services/user-service/instances/user-0/drain",
+			token:    "Bearer wrong",
+			wantCode: http.StatusUnauthorized,
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 10},
+				{Name: "user-service", Address: "127.0.0.1:8082", Weight: 10},
+			},
+		},
+		{
+			name:     "list service names",
+			method:   http.MethodGet,
+			path:     "/services",
+			wantCode: http.StatusOK,
+			wantBody: `["user-service"]`,
+		},
+		{
+			name:     "set weight by instance id",
+			method:   http.MethodPut,
+			path:     "/services/user-service/instances/user-0/weight",
+			body:     `{"weight": 20}`,
+			wantCode: http.StatusOK,
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 20},
+				{Name: "user-service", Address: "127.0.0.1:8082", Weight: 10},
+			},
+		},
+		{
+			name:     "drain by address",
+			method:   http.MethodPost,
+			path:     "/services/user-service/instances/127.0.0.1:8082/drain",
+			wantCode: http.StatusOK,
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 20},
+				{Name: "user-service", Address: "127.0.0.1:8082",
+					Metadata: map[string]string{registry.MetadataDrained: "true"}},
+			},
+		},
+		{
+			name:     "set weight undrains",
+			method:   http.MethodPut,
+			path:     "/services/user-service/instances/127.0.0.1:8082/weight",
+			body:     `{"weight": 5}`,
+			wantCode: http.StatusOK,
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 20},
+				{Name: "user-service", Address: "127.0.0.1:8082", Weight: 5, Metadata: map[string]string{}},
+			},
+		},
+		{
+			name:     "instance not found",
+			method:   http.MethodPost,
+			path:     "/services/user-service/instances/127.0.0.1:8083/drain",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			switch tc.token {
+			case "":
+				req.Header.Set("Authorization", "Bearer secret")
+			case "-":
+			default:
+				req.Header.Set("Authorization", tc.token)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.JSONEq(t, tc.wantBody, recorder.Body.String())
+			}
+			if tc.wantIns != nil {
+				recorder = httptest.NewRecorder()
+				req = httptest.NewRequest(http.MethodGet, "/services/user-service", nil)
+				req.Header.Set("Authorization", "Bearer secret")
+				h.ServeHTTP(recorder, req)
+				var ins []registry.ServiceInstance
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&ins))
+				assert.Equal(t, tc.wantIns, ins)
+			}
+		})
+	}
+}
+
+func TestHandler_NoAuth(t *testing.T) {
+	h := NewHandler(&memRegistry{}, nil)
+	req := httptest.NewRequest(http.MethodGet, "/services", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+type memRegistry struct {
+	registry.Registry
+	instances []registry.ServiceInstance
+}
+
+func (m *memRegistry) ListServiceNames(ctx context.Context) ([]string, error) {
+	return []string{"user-service"}, nil
+}
+
+func (m *memRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	return m.instances, nil
+}
+
+func (m *memRegistry) Update(ctx context.Context, si registry.ServiceInstance) error {
+	for i, ins := range m.instances {
+		if ins.Address == si.Address {
+			m.instances[i] = si
+		}
+	}
+	return nil
+}
+
+func TestHandler_DrainSurvivesRegister(t *testing.T) {
+	r := &drainRegistry{
+		memRegistry: memRegistry{instances: []registry.ServiceInstance{
+			{Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 10},
+		}},
+		drained: map[string]bool{},
+	}
+	h := NewHandler(r, BearerToken("secret"))
+
+	req := httptest.NewRequest(http.MethodPost, "/services/user-service/instances/user-0/drain", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	// 节点续约或者重启之后重新注册, 把节点信息整个覆盖掉
+	require.NoError(t, r.Register(context.Background(), registry.ServiceInstance{
+		Name: "user-service", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 10,
+	}))
+	ins, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	require.Len(t, ins, 1)
+	assert.True(t, ins[0].Drained())
+	assert.Equal(t, uint32(10), ins[0].Weight)
+
+	req = httptest.NewRequest(http.MethodPut, "/services/user-service/instances/user-0/weight",
+		strings.NewReader(`{"weight":5}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	ins, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.False(t, ins[0].Drained())
+	assert.Equal(t, uint32(5), ins[0].Weight)
+}
+
+// drainRegistry 模拟单独保存摘除状态的注册中心
+type drainRegistry struct {
+	memRegistry
+	drained map[string]bool
+}
+
+func (d *drainRegistry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	for i, ins := range d.instances {
+		if ins.Address == si.Address {
+			d.instances[i] = si
+			return nil
+		}
+	}
+	d.instances = append(d.instances, si)
+	return nil
+}
+
+func (d *drainRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	res := make([]registry.ServiceInstance, 0, len(d.instances))
+	for _, si := range d.instances {
+		if d.drained[si.Address] {
+			si = markDrained(si)
+		}
+		res = append(res, si)
+	}
+	return res, nil
+}
+
+func (d *drainRegistry) Drain(ctx context.Context, si registry.ServiceInstance, drained bool) error {
+	d.drained[si.Address] = drained
+	return nil
+}
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"micro/registry"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Option func(r *Registry)
+
+// Registry 注册中心的装饰器, 缓存最后一次正确的节点列表
+// 注册中心不可用的时候使用缓存, 可选地把缓存持久化到本地快照文件, 重启之后也能用
+// 同时提供推空保护: 一次性消失的节点超过阈值时, 认为是注册中心出了问题, 继续使用旧列表
+// 保护最多持续 hold 这么久, 之后还是这样就认为是真的变了, 例如蓝绿发布所有节点都换了
+type Registry struct {
+	registry.Registry
+
+	// 本地快照文件, 为空则不持久化
+	snapshot string
+	// 后台刷新的间隔, 0 则不刷新
+	interval time.Duration
+	timeout  time.Duration
+	// 一次性消失的节点比例超过该值就保留旧列表, 0 则不保护
+	threshold float64
+	hold      time.Duration
+
+	mutex    sync.RWMutex
+	services map[string][]registry.ServiceInstance
+	// 开始保留旧列表的时间
+	protecting map[string]time.Time
+	// 只是从快照加载的, 还没有从注册中心拿到过, 不做推空保护
+	snapshotOnly map[string]struct{}
+	// 保证快照按顺序写入
+	saveMutex sync.Mutex
+	// 上次写快照失败了, 列表没变也要重写
+	unsaved atomic.Bool
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRegistry(r registry.Registry, opts ...Option) (*Registry, error) {
+	res := &Registry{
+		Registry:     r,
+		interval:     30 * time.Second,
+		timeout:      3 * time.Second,
+		hold:         time.Minute,
+		services:     make(map[string][]registry.ServiceInstance, 8),
+		protecting:   make(map[string]time.Time, 8),
+		snapshotOnly: make(map[string]struct{}, 8),
+		close:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.snapshot != "" {
+		if err := res.load(); err != nil {
+			return nil, err
+		}
+	}
+	if res.interval > 0 {
+		go res.refresh()
+	}
+	return res, nil
+}
+
+// WithSnapshot 把节点列表持久化到本地文件
+func WithSnapshot(path string) Option {
+	return func(r *Registry) {
+		r.snapshot = path
+	}
+}
+
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Registry) {
+		r.timeout = timeout
+	}
+}
+
+// WithProtectThreshold 例如 0.5, 就是一次性消失了一半以上的节点就保留旧列表
+func WithProtectThreshold(threshold float64) Option {
+	return func(r *Registry) {
+		r.threshold = threshold
+	}
+}
+
+// WithProtectHold 推空保护最多持续多久, 默认一分钟, 超过之后使用注册中心的新列表
+func WithProtectHold(hold time.Duration) Option {
+	return func(r *Registry) {
+		r.hold = hold
+	}
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	ins, err := r.Registry.ListServices(ctx, serviceName)
+	if err != nil {
+		r.mutex.RLock()
+		cached, ok := r.services[serviceName]
+		r.mutex.RUnlock()
+		if ok {
+			// 注册中心不可用, 使用最后一次正确的结果
+			return clone(cached), nil
+		}
+		return nil, err
+	}
+	return r.update(serviceName, ins), nil
+}
+
+func (r *Registry) ListServiceNames(ctx context.Context) ([]string, error) {
+	c, ok := r.Registry.(registry.Catalog)
+	if !ok {
+		return nil, registry.ErrNotSupported
+	}
+	return c.ListServiceNames(ctx)
+}
+
+func (r *Registry) Update(ctx context.Context, si registry.ServiceInstance) error {
+	u, ok := r.Registry.(registry.Updater)
+	if !ok {
+		return registry.ErrNotSupported
+	}
+	return u.Update(ctx, si)
+}
+
+func (r *Registry) Drain(ctx context.Context, si registry.ServiceInstance, drained bool) error {
+	d, ok := r.Registry.(registry.Drainer)
+	if !ok {
+		return registry.ErrNotSupported
+	}
+	return d.Drain(ctx, si, drained)
+}
+
+// update 返回的都是副本, 调用者修改了也不会影响缓存
+func (r *Registry) update(serviceName string, ins []registry.ServiceInstance) []registry.ServiceInstance {
+	r.mutex.Lock()
+	old, ok := r.services[serviceName]
+	if _, loaded := r.snapshotOnly[serviceName]; ok && !loaded && r.protect(old, ins) {
+		since, protecting := r.protecting[serviceName]
+		if !protecting {
+			since = time.Now()
+			r.protecting[serviceName] = since
+		}
+		if time.Since(since) < r.hold {
+			r.mutex.Unlock()
+			return clone(old)
+		}
+	}
+	delete(r.protecting, serviceName)
+	delete(r.snapshotOnly, serviceName)
+	changed := !ok || !reflect.DeepEqual(old, ins)
+	if changed {
+		r.services[serviceName] = clone(ins)
+	}
+	r.mutex.Unlock()
+	if r.snapshot != "" && (changed || r.unsaved.Load()) {
+		// 快照写失败不影响服务发现, 下次更新再写
+		_ = r.save()
+	}
+	return ins
+}
+
+// clone Metadata 也要复制, 不然调用者改了 map 还是会影响缓存
+func clone(ins []registry.ServiceInstance) []registry.ServiceInstance {
+	if ins == nil {
+		return nil
+	}
+	res := make([]registry.ServiceInstance, len(ins))
+	for i, si := range ins {
+		si.Metadata = maps.Clone(si.Metadata)
+		res[i] = si
+	}
+	return res
+}
+
+// protect 判断是否触发推空保护
+func (r *Registry) protect(old, cur []registry.ServiceInstance) bool {
+	if r.threshold <= 0 || len(old) == 0 {
+		return false
+	}
+	addrs := make(map[string]struct{}, len(cur))
+	for _, si := range cur {
+		addrs[si.Address] = struct{}{}
+	}
+	vanished := 0
+	for _, si := range old {
+		if _, ok := addrs[si.Address]; !ok {
+			vanished++
+		}
+	}
+	return float64(vanished)/float64(len(old)) > r.threshold
+}
+
+// refresh 定期刷新已经查询过的服务, 保证注册中心挂掉的时候缓存不会太旧
+func (r *Registry) refresh() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.RLock()
+			names := make([]string, 0, len(r.services))
+			for name := range r.services {
+				names = append(names, name)
+			}
+			r.mutex.RUnlock()
+			for _, name := range names {
+				ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+				ins, err := r.Registry.ListServices(ctx, name)
+				cancel()
+				if err == nil {
+					r.update(name, ins)
+				}
+			}
+		case <-r.close:
+			return
+		}
+	}
+}
+
+func (r *Registry) load() error {
+	data, err := os.ReadFile(r.snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var services map[string][]registry.ServiceInstance
+	if err = json.Unmarshal(data, &services); err != nil {
+		return err
+	}
+	for name, ins := range services {
+		r.services[name] = ins
+		r.snapshotOnly[name] = struct{}{}
+	}
+	return nil
+}
+
+func (r *Registry) save() (err error) {
+	r.saveMutex.Lock()
+	defer r.saveMutex.Unlock()
+	defer func() {
+		r.unsaved.Store(err != nil)
+	}()
+	r.mutex.RLock()
+	data, err := json.Marshal(r.services)
+	r.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再 rename, 避免进程崩溃留下写了一半的快照
+	tmp, err := os.CreateTemp(filepath.Dir(r.snapshot), filepath.Base(r.snapshot)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.snapshot)
+}
+
+func (r *Registry) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.close)
+	})
+	return r.Registry.Close()
+}
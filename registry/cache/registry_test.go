@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/registry"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ListServices(t *testing.T) {
+	ins := []registry.ServiceInstance{
+		{Name: "user-service", Address: "127.0.0.1:8081"},
+		{Name: "user-service", Address: "127.0.0.1:8082"},
+		{Name: "user-service", Address: "127.0.0.1:8083"},
+	}
+	testCases := []struct {
+		name      string
+		threshold float64
+		cur       []registry.ServiceInstance
+		err       error
+
+		wantRes []registry.ServiceInstance
+	}{
+		{
+			name:    "registry down",
+			err:     errors.New("etcd down"),
+			wantRes: ins,
+		},
+		{
+			name:      "empty push",
+			threshold: 0.5,
+			cur:       []registry.ServiceInstance{},
+			wantRes:   ins,
+		},
+		{
+			name:      "below threshold",
+			threshold: 0.5,
+			cur:       ins[:2],
+			wantRes:   ins[:2],
+		},
+		{
+			name:    "no protection",
+			cur:     []registry.ServiceInstance{},
+			wantRes: []registry.ServiceInstance{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &memRegistry{instances: ins}
+			r, err := NewRegistry(backend, WithProtectThreshold(tc.threshold), WithRefreshInterval(0))
+			require.NoError(t, err)
+			res, err := r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Equal(t, ins, res)
+
+			backend.set(tc.cur, tc.err)
+			res, err = r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestRegistry_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	ins := []registry.ServiceInstance{{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10}}
+	r, err := NewRegistry(&memRegistry{instances: ins}, WithSnapshot(path), WithRefreshInterval(0))
+	require.NoError(t, err)
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+
+	// 重启之后注册中心不可用, 从快照恢复
+	r, err = NewRegistry(&memRegistry{err: errors.New("etcd down")}, WithSnapshot(path), WithRefreshInterval(0))
+	require.NoError(t, err)
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, ins, res)
+
+	// 快照里面没有的服务还是返回错误
+	_, err = r.ListServices(context.Background(), "order-service")
+	assert.Equal(t, errors.New("etcd down"), err)
+}
+
+func TestRegistry_ProtectHold(t *testing.T) {
+	old := []registry.ServiceInstance{{Address: "10.0.0.1:8081"}, {Address: "10.0.0.2:8081"}}
+	// 蓝绿发布, 所有节点都换了
+	cur := []registry.ServiceInstance{{Address: "10.0.1.1:8081"}, {Address: "10.0.1.2:8081"}}
+	backend := &memRegistry{instances: old}
+	r, err := NewRegistry(backend, WithProtectThreshold(0.5), WithProtectHold(50*time.Millisecond),
+		WithRefreshInterval(0))
+	require.NoError(t, err)
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+
+	backend.set(cur, nil)
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, old, res)
+
+	// 超过 hold 之后还是新列表, 就用新列表
+	time.Sleep(60 * time.Millisecond)
+	res, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, cur, res)
+}
+
+func TestRegistry_SnapshotNotProtected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	stale := []registry.ServiceInstance{{Address: "10.0.0.1:8081"}}
+	r, err := NewRegistry(&memRegistry{instances: stale}, WithSnapshot(path), WithRefreshInterval(0))
+	require.NoError(t, err)
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+
+	// 重启之后快照里面的节点都不在了, 注册中心的列表优先
+	cur := []registry.ServiceInstance{{Address: "10.0.1.1:8081"}}
+	r, err = NewRegistry(&memRegistry{instances: cur}, WithSnapshot(path), WithProtectThreshold(0.5),
+		WithRefreshInterval(0))
+	require.NoError(t, err)
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, cur, res)
+}
+
+func TestRegistry_SnapshotUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	backend := &memRegistry{instances: []registry.ServiceInstance{{Address: "127.0.0.1:8081"}}}
+	r, err := NewRegistry(backend, WithSnapshot(path), WithRefreshInterval(0))
+	require.NoError(t, err)
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	require.FileExists(t, path)
+
+	// 列表没变就不重写快照
+	require.NoError(t, os.Remove(path))
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+
+	backend.set([]registry.ServiceInstance{{Address: "127.0.0.1:8082"}}, nil)
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+}
+
+func TestRegistry_ListServicesCopy(t *testing.T) {
+	backend := &memRegistry{instances: []registry.ServiceInstance{
+		{Address: "127.0.0.1:8081", Metadata: map[string]string{"version": "v1"}},
+	}}
+	r, err := NewRegistry(backend, WithRefreshInterval(0))
+	require.NoError(t, err)
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	res[0].Address = "changed"
+	res[0].Metadata["version"] = "changed"
+
+	backend.set(nil, errors.New("etcd down"))
+	res, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	res[0].Weight = 100
+	res, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{
+		{Address: "127.0.0.1:8081", Metadata: map[string]string{"version": "v1"}},
+	}, res)
+}
+
+func TestRegistry_Refresh(t *testing.T) {
+	backend := &memRegistry{instances: []registry.ServiceInstance{{Address: "127.0.0.1:8081"}}}
+	r, err := NewRegistry(backend, WithRefreshInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+
+	ins := []registry.ServiceInstance{{Address: "127.0.0.1:8082"}}
+	backend.set(ins, nil)
+	time.Sleep(50 * time.Millisecond)
+	// 后台刷新之后, 注册中心挂了也能拿到最新的列表
+	backend.set(nil, errors.New("etcd down"))
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, ins, res)
+}
+
+type memRegistry struct {
+	registry.Registry
+	mutex     sync.Mutex
+	instances []registry.ServiceInstance
+	err       error
+}
+
+func (m *memRegistry) set(ins []registry.ServiceInstance, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.instances, m.err = ins, err
+}
+
+func (m *memRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.instances, m.err
+}
+
+func (m *memRegistry) Close() error {
+	return nil
+}
@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"micro/registry"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errReadOnly = errors.New("micro: dns 注册中心是只读的, 不支持注册与注销")
+
+type Option func(r *Registry)
+
+// Registry 基于 DNS 记录的只读注册中心
+// 适用于本地开发, 以及 k8s headless service 这种没有额外注册中心的场景
+// 优先查 SRV 记录, 查不到再退化为 A/AAAA 记录 + 默认端口
+type Registry struct {
+	resolver *net.Resolver
+	// A 记录没有端口信息, 需要指定
+	port int
+	// DNS 没有推送能力, 只能轮询
+	interval time.Duration
+	timeout  time.Duration
+	// A 记录以及权重为 0 的 SRV 记录使用的权重
+	weight uint32
+
+	// 方便测试替换
+	lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	cancels []func()
+	mutex   sync.Mutex
+}
+
+func NewRegistry(opts ...Option) (*Registry, error) {
+	res := &Registry{
+		resolver: net.DefaultResolver,
+		interval: 10 * time.Second,
+		timeout:  3 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		_, srvs, err := res.resolver.LookupSRV(ctx, "", "", name)
+		return srvs, err
+	}
+	res.lookupHost = res.resolver.LookupHost
+	return res, nil
+}
+
+func WithResolver(resolver *net.Resolver) Option {
+	return func(r *Registry) {
+		r.resolver = resolver
+	}
+}
+
+// WithPort 只有 A 记录时使用的端口
+func WithPort(port int) Option {
+	return func(r *Registry) {
+		r.port = port
+	}
+}
+
+// WithInterval 轮询 DNS 的间隔
+func WithInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Registry) {
+		r.timeout = timeout
+	}
+}
+
+func WithWeight(weight uint32) Option {
+	return func(r *Registry) {
+		r.weight = weight
+	}
+}
+
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	return errReadOnly
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return errReadOnly
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	srvs, err := r.lookupSRV(ctx, serviceName)
+	if err == nil && len(srvs) > 0 {
+		res := make([]registry.ServiceInstance, 0, len(srvs))
+		for _, srv := range srvs {
+			weight := uint32(srv.Weight)
+			if weight == 0 {
+				weight = r.weight
+			}
+			res = append(res, registry.ServiceInstance{
+				Name:    serviceName,
+				Address: net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+				Weight:  weight,
+			})
+		}
+		sortInstances(res)
+		return res, nil
+	}
+
+	// 没有 SRV 记录, 退化为 A 记录
+	if r.port == 0 {
+		if err == nil {
+			err = errors.New("micro: 没有 SRV 记录, 且未指定 A 记录的端口")
+		}
+		return nil, err
+	}
+	hosts, err := r.lookupHost(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]registry.ServiceInstance, 0, len(hosts))
+	for _, host := range hosts {
+		res = append(res, registry.ServiceInstance{
+			Name:    serviceName,
+			Address: net.JoinHostPort(host, strconv.Itoa(r.port)),
+			Weight:  r.weight,
+		})
+	}
+	sortInstances(res)
+	return res, nil
+}
+
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mutex.Lock()
+	r.cancels = append(r.cancels, cancel)
+	r.mutex.Unlock()
+
+	// 事件只是通知 resolver 全量拉取, 缓冲一个就够了, 多余的直接合并
+	res := make(chan registry.Event, 1)
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		last, _ := r.list(ctx, serviceName)
+		for {
+			select {
+			case <-ticker.C:
+				cur, err := r.list(ctx, serviceName)
+				if err != nil {
+					// DNS 抖动, 保留上一次的结果
+					continue
+				}
+				if reflect.DeepEqual(last, cur) {
+					continue
+				}
+				last = cur
+				select {
+				case res <- registry.Event{Type: "UPDATE"}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return res, nil
+}
+
+func (r *Registry) list(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.ListServices(ctx, serviceName)
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	cancels := r.cancels
+	r.cancels = nil
+	r.mutex.Unlock()
+	for _, c := range cancels {
+		c()
+	}
+	return nil
+}
+
+// DNS 返回的顺序是随机的, 排序后才好比较
+func sortInstances(ins []registry.ServiceInstance) {
+	sort.Slice(ins, func(i, j int) bool {
+		return ins[i].Address < ins[j].Address
+	})
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/registry"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ListServices(t *testing.T) {
+	testCases := []struct {
+		name       string
+		opts       []Option
+		lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+		lookupHost func(ctx context.Context, host string) ([]string, error)
+
+		wantErr error
+		wantRes []registry.ServiceInstance
+	}{
+		{
+			name: "srv",
+			opts: []Option{WithWeight(1)},
+			lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+				return []*net.SRV{
+					{Target: "pod-b.user-service.default.svc.", Port: 8081, Weight: 0},
+					{Target: "pod-a.user-service.default.svc.", Port: 8081, Weight: 10},
+				}, nil
+			},
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "pod-a.user-service.default.svc:8081", Weight: 10},
+				{Name: "user-service", Address: "pod-b.user-service.default.svc:8081", Weight: 1},
+			},
+		},
+		{
+			name: "fallback to a",
+			opts: []Option{WithPort(8081)},
+			lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+				return nil, errors.New("no such host")
+			},
+			lookupHost: func(ctx context.Context, host string) ([]string, error) {
+				return []string{"10.0.0.2", "10.0.0.1"}, nil
+			},
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "10.0.0.1:8081"},
+				{Name: "user-service", Address: "10.0.0.2:8081"},
+			},
+		},
+		{
+			name: "no srv and no port",
+			lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+				return nil, nil
+			},
+			wantErr: errors.New("micro: 没有 SRV 记录, 且未指定 A 记录的端口"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewRegistry(tc.opts...)
+			require.NoError(t, err)
+			r.lookupSRV = tc.lookupSRV
+			r.lookupHost = tc.lookupHost
+			res, err := r.ListServices(context.Background(), "user-service")
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	r, err := NewRegistry(WithPort(8081), WithInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+	hosts := make(chan []string, 1)
+	hosts <- []string{"10.0.0.1"}
+	cur := []string{"10.0.0.1"}
+	r.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return nil, nil
+	}
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		select {
+		case cur = <-hosts:
+		default:
+		}
+		return cur, nil
+	}
+
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+	hosts <- []string{"10.0.0.1", "10.0.0.2"}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("没有收到变更事件")
+	}
+}
@@ -3,18 +3,42 @@ package etcd
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	"maps"
+	"micro/observability"
 	"micro/registry"
+	"path"
+	"strings"
 	"sync"
+	"time"
 )
 
+type Option func(r *Registry)
+
 type Registry struct {
 	c *clientv3.Client
 	sess *concurrency.Session
 	cancels []func()
 	mutex sync.Mutex
+
+	// 租约的过期时间, 单位秒
+	ttl int
+	// 通过该注册中心注册的节点, 租约丢失之后要重新注册
+	instances map[string]registry.ServiceInstance
+	// 租约丢失的回调, 可以用来打日志或者上报监控
+	onLeaseLost func()
+	// 上报租约状态和 watch 重连次数
+	sink observability.Sink
+	logger observability.Logger
+	close chan struct{}
+	closeOnce sync.Once
+
+	// key 的根前缀, 默认 /micro
+	prefix string
+	// 环境, 团队之类的命名空间, 不同命名空间的节点互相不可见
+	namespace []string
 }
 
 // 从配置中区加载
@@ -27,19 +51,165 @@ type Registry struct {
 //
 //}
 
-func NewRegistry(c *clientv3.Client) (*Registry, error) {
+func NewRegistry(c *clientv3.Client, opts ...Option) (*Registry, error) {
+	res := &Registry{
+		c: c,
+		ttl: 60,
+		prefix: "/micro",
+		instances: make(map[string]registry.ServiceInstance, 4),
+		close: make(chan struct{}),
+		sink: observability.NopSink{},
+		logger: observability.LoggerOrDefault(nil),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
 	// 根据客户端创建租约 session
-	sess, err := concurrency.NewSession(c)
+	sess, err := concurrency.NewSession(c, concurrency.WithTTL(res.ttl))
 	if err != nil {
 		return nil, err
 	}
-	return &Registry{
-		c: c,
-		sess: sess,
-	}, nil
+	res.sess = sess
+	res.leaseState(true)
+	go res.keepalive(sess)
+	return res, nil
+}
+
+// WithTTL 租约的过期时间, 单位秒
+func WithTTL(ttl int) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// WithPrefix key 的根前缀, 默认是 /micro
+func WithPrefix(prefix string) Option {
+	return func(r *Registry) {
+		r.prefix = "/" + strings.Trim(prefix, "/")
+	}
+}
+
+// WithNamespace 命名空间, 例如 WithNamespace("prod", "team-a")
+// 对应的 key 为 /micro/prod/team-a/<服务名>/<节点标识>
+func WithNamespace(segments ...string) Option {
+	return func(r *Registry) {
+		r.namespace = segments
+	}
+}
+
+// WithLeaseLostCallback 租约丢失时回调, 此时注册中心会自动重建租约并重新注册
+func WithLeaseLostCallback(fn func()) Option {
+	return func(r *Registry) {
+		r.onLeaseLost = fn
+	}
+}
+
+// WithSink 上报租约状态 registry_lease_state 和 watch 重连次数 registry_watch_reconnects_total
+func WithSink(s observability.Sink) Option {
+	return func(r *Registry) {
+		r.sink = observability.SinkOrNop(s)
+	}
 }
 
+// WithLogger 默认使用 slog.Default()
+func WithLogger(l observability.Logger) Option {
+	return func(r *Registry) {
+		r.logger = observability.LoggerOrDefault(l)
+	}
+}
+
+// leaseState 租约正常是 1, 丢失是 0
+func (r *Registry) leaseState(alive bool) {
+	labels := observability.Labels{"registry": "etcd"}
+	if alive {
+		r.sink.SetGauge("registry_lease_state", 1, labels)
+		return
+	}
+	r.sink.SetGauge("registry_lease_state", 0, labels)
+	r.sink.IncCounter("registry_lease_lost_total", labels)
+}
+
+// keepalive 监听租约, 网络抖动导致租约过期之后, 新建租约并重新注册所有节点
+// 否则节点会悄无声息地从注册中心消失, 并且再也不会回来
+func (r *Registry) keepalive(sess *concurrency.Session) {
+	for {
+		select {
+		case <-sess.Done():
+		case <-r.close:
+			return
+		}
+		// 租约丢了, 但可能是 Close 导致的
+		select {
+		case <-r.close:
+			return
+		default:
+		}
+		r.leaseState(false)
+		r.logger.Warn("etcd 租约丢失, 重建租约并重新注册", "ttl", r.ttl)
+		if r.onLeaseLost != nil {
+			r.onLeaseLost()
+		}
+		sess = r.renew()
+		if sess == nil {
+			return
+		}
+		r.leaseState(true)
+		r.logger.Info("etcd 租约已经重建", "lease", int64(sess.Lease()))
+	}
+}
+
+// renew 重建租约并重新注册, 直到成功或者注册中心被关闭
+func (r *Registry) renew() *concurrency.Session {
+	backoff := time.Second
+	for {
+		sess, err := concurrency.NewSession(r.c, concurrency.WithTTL(r.ttl))
+		if err == nil {
+			r.mutex.Lock()
+			r.sess = sess
+			instances := make([]registry.ServiceInstance, 0, len(r.instances))
+			for _, si := range r.instances {
+				instances = append(instances, si)
+			}
+			r.mutex.Unlock()
+			for _, si := range instances {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+				err = r.put(ctx, sess, si)
+				cancel()
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				return sess
+			}
+			// 重新注册失败, 放弃这个租约重来
+			_ = sess.Close()
+		}
+		r.logger.Warn("etcd 重建租约失败", "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-r.close:
+			return nil
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// Register 写入和记录都在锁里面, 和 renew 换租约互斥
+// 否则可能用旧的租约写入, 而 renew 拿到的列表里面又没有这个节点, 旧租约过期之后节点就丢了
 func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.put(ctx, r.sess, si); err != nil {
+		return err
+	}
+	r.instances[r.instanceKey(si)] = si
+	return nil
+}
+
+func (r *Registry) put(ctx context.Context, sess *concurrency.Session, si registry.ServiceInstance) error {
 	// 把节点信息以 json 格式写入
 	val, err := json.Marshal(si)
 	if err != nil {
@@ -48,11 +218,49 @@ func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) er
 	
 	// 把节点信息写入 etcd key:节点实例标识, val:json后的节点实例, 以及新建一个租约
 	// 第一个返回不好解析, 用处不大
-	_, err = r.c.Put(ctx, r.instanceKey(si), string(val), clientv3.WithLease(r.sess.Lease()))
+	_, err = r.c.Put(ctx, r.instanceKey(si), string(val), clientv3.WithLease(sess.Lease()))
+	return err
+}
+
+// Update 沿用节点原来的租约更新节点信息, 用于运维调整权重之类的场景
+// 节点是通过这个注册中心注册的话, 也要更新本地的副本, 否则租约丢失之后重新注册会把修改覆盖掉
+func (r *Registry) Update(ctx context.Context, si registry.ServiceInstance) error {
+	val, err := json.Marshal(si)
+	if err != nil {
+		return err
+	}
+	key := r.instanceKey(si)
+	_, err = r.c.Put(ctx, key, string(val), clientv3.WithIgnoreLease())
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	// 其它进程注册的节点不能放进来, 否则重新注册的时候会挂到这个进程的租约上
+	if _, ok := r.instances[key]; ok {
+		r.instances[key] = si
+	}
+	r.mutex.Unlock()
+	return nil
+}
+
+// Drain 摘除状态单独存在 <节点的 key>/drained 里面, 不挂租约
+// 所以重新注册和续约都不会覆盖, 节点 UnRegister 之后也保留, 重启之后还是摘除的
+func (r *Registry) Drain(ctx context.Context, si registry.ServiceInstance, drained bool) error {
+	var err error
+	if drained {
+		_, err = r.c.Put(ctx, r.instanceKey(si)+drainSuffix, "true")
+	} else {
+		_, err = r.c.Delete(ctx, r.instanceKey(si)+drainSuffix)
+	}
 	return err
 }
 
+const drainSuffix = "/drained"
+
 func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	delete(r.instances, r.instanceKey(si))
+	r.mutex.Unlock()
 	_, err := r.c.Delete(ctx, r.instanceKey(si))
 	return err
 }
@@ -63,18 +271,63 @@ func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]regi
 	if err != nil {
 		return nil, err
 	}
-	res := make([]registry.ServiceInstance, 0, len(getResp.Kvs))
-	for _, kv := range getResp.Kvs {
+	return parseInstances(getResp.Kvs)
+}
+
+// parseInstances 同一个前缀下面既有节点也有摘除状态, 把摘除状态合并到节点的 Metadata 里面
+func parseInstances(kvs []*mvccpb.KeyValue) ([]registry.ServiceInstance, error) {
+	drained := make(map[string]struct{})
+	for _, kv := range kvs {
+		if key, ok := strings.CutSuffix(string(kv.Key), drainSuffix); ok {
+			drained[key] = struct{}{}
+		}
+	}
+	res := make([]registry.ServiceInstance, 0, len(kvs)-len(drained))
+	for _, kv := range kvs {
+		if strings.HasSuffix(string(kv.Key), drainSuffix) {
+			continue
+		}
 		var si registry.ServiceInstance
-		err = json.Unmarshal(kv.Value, &si)
-		if err != nil {
+		if err := json.Unmarshal(kv.Value, &si); err != nil {
 			return nil, err
 		}
+		if _, ok := drained[string(kv.Key)]; ok {
+			si.Metadata = maps.Clone(si.Metadata)
+			if si.Metadata == nil {
+				si.Metadata = make(map[string]string, 1)
+			}
+			si.Metadata[registry.MetadataDrained] = "true"
+		}
 		res = append(res, si)
 	}
 	return res, nil
 }
 
+// ListServiceNames 只扫描 key, 从 key 中解析出服务名
+// 注意不使用命名空间的时候, 其它命名空间的第一段也会被当成服务名
+func (r *Registry) ListServiceNames(ctx context.Context) ([]string, error) {
+	base := r.serviceKey("")
+	getResp, err := r.c.Get(ctx, base, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(getResp.Kvs))
+	seen := make(map[string]struct{}, len(getResp.Kvs))
+	for _, kv := range getResp.Kvs {
+		// key 为 <base><服务名>/<节点标识>, 只剩摘除状态的服务不算
+		name, _, ok := strings.Cut(strings.TrimPrefix(string(kv.Key), base), "/")
+		if !ok || strings.HasSuffix(string(kv.Key), drainSuffix) {
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		res = append(res, name)
+	}
+	return res, nil
+}
+
 func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	r.mutex.Lock()
@@ -83,24 +336,46 @@ func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error)
 	
 	// 只要有 Leader 时的事件, 避免主从切换的误差
 	ctx = clientv3.WithRequireLeader(ctx)
-	watchResp := r.c.Watch(ctx, r.serviceKey(serviceName), clientv3.WithPrefix())
+	key := r.serviceKey(serviceName)
+	watchResp := r.c.Watch(ctx, key, clientv3.WithPrefix())
 	
 	res := make(chan registry.Event)
 	go func() {
 		for {
 			select {
-			case resp := <-watchResp:
-				if resp.Err() != nil {
-					//return
-					continue
-				}
-				if resp.Canceled {
+			case resp, ok := <-watchResp:
+				if ctx.Err() != nil {
 					return
 				}
+				if !ok || resp.Err() != nil {
+					// watch 断了, 例如没有 leader 或者 revision 被压缩, 等一会儿重新 watch
+					// 断开期间的变更可能丢了, 通知一下让订阅方全量刷新
+					r.logger.Warn("etcd watch 断开, 准备重连", "service", serviceName, "error", resp.Err())
+					r.sink.IncCounter("registry_watch_reconnects_total", observability.Labels{
+						"registry": "etcd",
+						"service":  serviceName,
+					})
+					select {
+					case <-time.After(time.Second):
+					case <-ctx.Done():
+						return
+					}
+					watchResp = r.c.Watch(ctx, key, clientv3.WithPrefix())
+					select {
+					case res <- registry.Event{}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
 				// 返回为一批事件
 				for range resp.Events {
 					// 只需要通知一下, 注册中心就会全量从 etcd... 更新节点信息
-					res <- registry.Event{}
+					select {
+					case res <- registry.Event{}:
+					case <-ctx.Done():
+						return
+					}
 				}
 			case <-ctx.Done():
 				return
@@ -111,24 +386,36 @@ func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error)
 }
 
 func (r *Registry) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.close)
+	})
 	r.mutex.Lock()
 	cancels := r.cancels
 	r.cancels = nil
+	sess := r.sess
 	r.mutex.Unlock()
 	for _, c := range cancels {
 		c()
 	}
+	r.sink.SetGauge("registry_lease_state", 0, observability.Labels{"registry": "etcd"})
 	// 关闭 etcd 的 session 就会关闭其租约
-	return r.sess.Close()
+	return sess.Close()
 }
 
 func (r *Registry) instanceKey(si registry.ServiceInstance) string {
-	// 可以考虑直接用 Address
-	// 也可以说在 si 里面引入一个 InstanceName 的字段
-	return fmt.Sprintf("/micro/%s/%s", si.Name, si.Address)
+	// 优先使用稳定的 InstanceID, 没有再退化为 Address
+	id := si.InstanceID
+	if id == "" {
+		id = si.Address
+	}
+	return r.serviceKey(si.Name) + id
 }
 
+// serviceKey 以 / 结尾, 避免前缀匹配的时候 user 匹配到 user-service
 func (r *Registry) serviceKey(sn string) string {
-	return fmt.Sprintf("/micro/%s", sn)
+	segments := make([]string, 0, len(r.namespace)+2)
+	segments = append(segments, r.prefix)
+	segments = append(segments, r.namespace...)
+	segments = append(segments, sn)
+	return path.Join(segments...) + "/"
 }
-
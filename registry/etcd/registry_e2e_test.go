@@ -0,0 +1,122 @@
+package etcd
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"micro/registry"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Renew(t *testing.T) {
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"localhost:2379"},
+	})
+	require.NoError(t, err)
+	lost := make(chan struct{}, 1)
+	r, err := NewRegistry(etcdClient, WithTTL(5), WithLeaseLostCallback(func() {
+		lost <- struct{}{}
+	}))
+	require.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	si := registry.ServiceInstance{Name: "renew-service", Address: "127.0.0.1:8081"}
+	require.NoError(t, r.Register(ctx, si))
+
+	// 模拟租约丢失
+	_, err = etcdClient.Revoke(ctx, r.sess.Lease())
+	require.NoError(t, err)
+	select {
+	case <-lost:
+	case <-ctx.Done():
+		t.Fatal("没有感知到租约丢失")
+	}
+
+	// 重新注册之后节点还在
+	assert.Eventually(t, func() bool {
+		ins, er := r.ListServices(ctx, "renew-service")
+		return er == nil && len(ins) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestRegistry_UpdateRenew(t *testing.T) {
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"localhost:2379"},
+	})
+	require.NoError(t, err)
+	lost := make(chan struct{}, 1)
+	r, err := NewRegistry(etcdClient, WithTTL(5), WithLeaseLostCallback(func() {
+		lost <- struct{}{}
+	}))
+	require.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	si := registry.ServiceInstance{Name: "update-service", Address: "127.0.0.1:8081", Weight: 10}
+	require.NoError(t, r.Register(ctx, si))
+	si.Weight = 0
+	require.NoError(t, r.Update(ctx, si))
+
+	_, err = etcdClient.Revoke(ctx, r.sess.Lease())
+	require.NoError(t, err)
+	select {
+	case <-lost:
+	case <-ctx.Done():
+		t.Fatal("没有感知到租约丢失")
+	}
+
+	// 重新注册的是修改之后的节点
+	assert.Eventually(t, func() bool {
+		ins, er := r.ListServices(ctx, "update-service")
+		return er == nil && len(ins) == 1 && ins[0].Weight == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestRegistry_DrainRenew(t *testing.T) {
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{"localhost:2379"},
+	})
+	require.NoError(t, err)
+	lost := make(chan struct{}, 1)
+	r, err := NewRegistry(etcdClient, WithTTL(5), WithLeaseLostCallback(func() {
+		lost <- struct{}{}
+	}))
+	require.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	si := registry.ServiceInstance{Name: "drain-service", Address: "127.0.0.1:8081", Weight: 10}
+	require.NoError(t, r.Register(ctx, si))
+	require.NoError(t, r.Drain(ctx, si, true))
+	defer func() {
+		_ = r.Drain(context.Background(), si, false)
+	}()
+
+	_, err = etcdClient.Revoke(ctx, r.sess.Lease())
+	require.NoError(t, err)
+	select {
+	case <-lost:
+	case <-ctx.Done():
+		t.Fatal("没有感知到租约丢失")
+	}
+
+	// 续约重新注册之后还是摘除的
+	assert.Eventually(t, func() bool {
+		ins, er := r.ListServices(ctx, "drain-service")
+		return er == nil && len(ins) == 1 && ins[0].Drained()
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// 模拟节点重启
+	require.NoError(t, r.UnRegister(ctx, si))
+	require.NoError(t, r.Register(ctx, si))
+	ins, err := r.ListServices(ctx, "drain-service")
+	require.NoError(t, err)
+	require.Len(t, ins, 1)
+	assert.True(t, ins[0].Drained())
+}
@@ -0,0 +1,68 @@
+package etcd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"micro/registry"
+	"testing"
+)
+
+func TestRegistry_Key(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+		si   registry.ServiceInstance
+
+		wantServiceKey  string
+		wantInstanceKey string
+	}{
+		{
+			name:            "default",
+			si:              registry.ServiceInstance{Name: "user", Address: "127.0.0.1:8081"},
+			wantServiceKey:  "/micro/user/",
+			wantInstanceKey: "/micro/user/127.0.0.1:8081",
+		},
+		{
+			name:            "instance id",
+			si:              registry.ServiceInstance{Name: "user", InstanceID: "user-0", Address: "127.0.0.1:8081"},
+			wantServiceKey:  "/micro/user/",
+			wantInstanceKey: "/micro/user/user-0",
+		},
+		{
+			name:            "prefix and namespace",
+			opts:            []Option{WithPrefix("company/"), WithNamespace("prod", "team-a")},
+			si:              registry.ServiceInstance{Name: "user", Address: "127.0.0.1:8081"},
+			wantServiceKey:  "/company/prod/team-a/user/",
+			wantInstanceKey: "/company/prod/team-a/user/127.0.0.1:8081",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Registry{prefix: "/micro"}
+			for _, opt := range tc.opts {
+				opt(r)
+			}
+			assert.Equal(t, tc.wantServiceKey, r.serviceKey(tc.si.Name))
+			assert.Equal(t, tc.wantInstanceKey, r.instanceKey(tc.si))
+		})
+	}
+}
+
+func TestParseInstances(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/micro/user/user-0"), Value: []byte(`{"Name":"user","InstanceID":"user-0","Address":"127.0.0.1:8081","Weight":10}`)},
+		{Key: []byte("/micro/user/user-0/drained"), Value: []byte("true")},
+		{Key: []byte("/micro/user/127.0.0.1:8082"), Value: []byte(`{"Name":"user","Address":"127.0.0.1:8082","Weight":10}`)},
+		// 节点已经下线, 只剩摘除状态
+		{Key: []byte("/micro/user/user-2/drained"), Value: []byte("true")},
+	}
+	ins, err := parseInstances(kvs)
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{
+		{Name: "user", InstanceID: "user-0", Address: "127.0.0.1:8081", Weight: 10,
+			Metadata: map[string]string{registry.MetadataDrained: "true"}},
+		{Name: "user", Address: "127.0.0.1:8082", Weight: 10},
+	}, ins)
+}
@@ -0,0 +1,220 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"micro/registry"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errReadOnly = errors.New("micro: 文件注册中心是只读的, 不支持注册与注销")
+
+type Option func(r *Registry)
+
+// Registry 基于本地文件的只读注册中心, 文件支持 yaml 和 json, 例如:
+//
+//	services:
+//	  user-service:
+//	    - address: 127.0.0.1:8081
+//	      weight: 10
+//	      group: A
+//	      metadata:
+//	        version: v2
+//
+// 通过轮询文件内容感知变更, 变更的服务会收到 Subscribe 事件
+type Registry struct {
+	path     string
+	interval time.Duration
+
+	mutex    sync.RWMutex
+	content  []byte
+	services map[string][]registry.ServiceInstance
+	subs     map[string][]chan registry.Event
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+type config struct {
+	Services map[string][]instance `json:"services" yaml:"services"`
+}
+
+type instance struct {
+	Address string `json:"address" yaml:"address"`
+	Weight  uint32 `json:"weight" yaml:"weight"`
+	Group   string `json:"group" yaml:"group"`
+
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+func NewRegistry(path string, opts ...Option) (*Registry, error) {
+	res := &Registry{
+		path:     path,
+		interval: 3 * time.Second,
+		subs:     make(map[string][]chan registry.Event, 4),
+		close:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	// 第一次加载失败直接返回, 避免启动时拿到一个空的注册中心
+	if _, err := res.reload(); err != nil {
+		return nil, err
+	}
+	go res.watch()
+	return res, nil
+}
+
+// WithInterval 轮询文件的间隔
+func WithInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	return errReadOnly
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return errReadOnly
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ins := r.services[serviceName]
+	res := make([]registry.ServiceInstance, len(ins))
+	copy(res, ins)
+	return res, nil
+}
+
+func (r *Registry) ListServiceNames(ctx context.Context) ([]string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	res := make([]string, 0, len(r.services))
+	for name := range r.services {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	// 事件只是通知 resolver 全量拉取, 缓冲一个就够了, 多余的直接合并
+	res := make(chan registry.Event, 1)
+	r.mutex.Lock()
+	r.subs[serviceName] = append(r.subs[serviceName], res)
+	r.mutex.Unlock()
+	return res, nil
+}
+
+func (r *Registry) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.close)
+	})
+	return nil
+}
+
+func (r *Registry) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := r.reload()
+			if err != nil {
+				// 文件写了一半或者格式有误, 保留上一次的结果
+				continue
+			}
+			r.notify(changed)
+		case <-r.close:
+			return
+		}
+	}
+}
+
+// reload 重新加载文件, 返回发生变化的服务名
+func (r *Registry) reload() ([]string, error) {
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	// 编辑器保存时可能先清空文件再写入, 读到空文件当作出错处理
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, errors.New("micro: 服务列表文件为空")
+	}
+	r.mutex.RLock()
+	same := bytes.Equal(content, r.content)
+	r.mutex.RUnlock()
+	if same {
+		return nil, nil
+	}
+
+	var cfg config
+	switch filepath.Ext(r.path) {
+	case ".json":
+		err = json.Unmarshal(content, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &cfg)
+	default:
+		err = errors.New("micro: 文件注册中心只支持 json 和 yaml")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string][]registry.ServiceInstance, len(cfg.Services))
+	for name, ins := range cfg.Services {
+		sis := make([]registry.ServiceInstance, 0, len(ins))
+		for _, in := range ins {
+			sis = append(sis, registry.ServiceInstance{
+				Name:    name,
+				Address: in.Address,
+				Weight:  in.Weight,
+				Group:   in.Group,
+
+				Metadata: in.Metadata,
+			})
+		}
+		services[name] = sis
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	changed := make([]string, 0, len(services))
+	for name, sis := range services {
+		if !reflect.DeepEqual(sis, r.services[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range r.services {
+		if _, ok := services[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	r.content = content
+	r.services = services
+	return changed, nil
+}
+
+func (r *Registry) notify(changed []string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, name := range changed {
+		for _, ch := range r.subs[name] {
+			select {
+			case ch <- registry.Event{Type: "UPDATE"}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package file
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/registry"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ListServices(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		content string
+
+		wantErr bool
+		wantRes []registry.ServiceInstance
+	}{
+		{
+			name: "yaml",
+			file: "services.yaml",
+			content: `
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+      weight: 10
+      group: A
+      metadata:
+        version: v2
+    - address: 127.0.0.1:8082
+`,
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10, Group: "A",
+					Metadata: map[string]string{"version": "v2"}},
+				{Name: "user-service", Address: "127.0.0.1:8082"},
+			},
+		},
+		{
+			name:    "json",
+			file:    "services.json",
+			content: `{"services": {"user-service": [{"address": "127.0.0.1:8081", "weight": 3}]}}`,
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 3},
+			},
+		},
+		{
+			name:    "unknown ext",
+			file:    "services.txt",
+			content: "user-service",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o644))
+			r, err := NewRegistry(path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer r.Close()
+			res, err := r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	// 先写临时文件再 rename, 避免读到写了一半的文件
+	write := func(content string) {
+		tmp := path + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o644))
+		require.NoError(t, os.Rename(tmp, path))
+	}
+	write(`
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+  order-service:
+    - address: 127.0.0.1:9091
+`)
+	r, err := NewRegistry(path, WithInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+
+	userEvents, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+	orderEvents, err := r.Subscribe("order-service")
+	require.NoError(t, err)
+
+	// 只改了 user-service
+	write(`
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+    - address: 127.0.0.1:8082
+  order-service:
+    - address: 127.0.0.1:9091
+`)
+	select {
+	case <-userEvents:
+	case <-time.After(time.Second):
+		t.Fatal("没有收到 user-service 的变更事件")
+	}
+	select {
+	case <-orderEvents:
+		t.Fatal("order-service 没有变更, 不应该收到事件")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Len(t, res, 2)
+	names, err := r.ListServiceNames(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order-service", "user-service"}, names)
+
+	// 格式错误或者空的文件保留上一次的结果
+	for _, content := range []string{"services: [", ""} {
+		write(content)
+		time.Sleep(50 * time.Millisecond)
+		res, err = r.ListServices(context.Background(), "user-service")
+		require.NoError(t, err)
+		assert.Len(t, res, 2)
+	}
+}
@@ -0,0 +1,424 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"micro/registry"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGroupLabel pod 上标识分组的 label
+	DefaultGroupLabel = "micro.io/group"
+	// DefaultWeightAnnotation pod 上标识权重的 annotation
+	DefaultWeightAnnotation = "micro.io/weight"
+	// DefaultMetadataPrefix 以此为前缀的 pod annotation 会被放进 Metadata, 前缀会被去掉
+	DefaultMetadataPrefix = "meta.micro.io/"
+)
+
+type Option func(r *Registry)
+
+// Registry 基于 k8s EndpointSlice 的注册中心
+// 服务名就是 k8s Service 的名字, endpoint 是否 ready 决定了节点在不在列表里
+// 分组, 权重以及 Metadata 从 pod 的 label 和 annotation 中获取, pod 按照 Service 的 selector 查询
+type Registry struct {
+	client    kubernetes.Interface
+	namespace string
+	// 多端口的 Service 需要指定端口名, 否则取第一个端口
+	portName string
+	// 当前 pod 的名字, 设置了 Register 才会回写 pod 的 annotation
+	podName string
+
+	groupLabel       string
+	weightAnnotation string
+	metadataPrefix   string
+
+	cancels []func()
+	mutex   sync.Mutex
+}
+
+func NewRegistry(client kubernetes.Interface, opts ...Option) (*Registry, error) {
+	res := &Registry{
+		client:           client,
+		namespace:        metav1.NamespaceDefault,
+		groupLabel:       DefaultGroupLabel,
+		weightAnnotation: DefaultWeightAnnotation,
+		metadataPrefix:   DefaultMetadataPrefix,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
+}
+
+func WithNamespace(namespace string) Option {
+	return func(r *Registry) {
+		r.namespace = namespace
+	}
+}
+
+func WithPortName(portName string) Option {
+	return func(r *Registry) {
+		r.portName = portName
+	}
+}
+
+// WithPodName 一般通过 downward API 把 pod 名字注入到环境变量中
+func WithPodName(podName string) Option {
+	return func(r *Registry) {
+		r.podName = podName
+	}
+}
+
+func WithGroupLabel(label string) Option {
+	return func(r *Registry) {
+		r.groupLabel = label
+	}
+}
+
+func WithWeightAnnotation(annotation string) Option {
+	return func(r *Registry) {
+		r.weightAnnotation = annotation
+	}
+}
+
+func WithMetadataPrefix(prefix string) Option {
+	return func(r *Registry) {
+		r.metadataPrefix = prefix
+	}
+}
+
+// Register 节点是否可见由 k8s 的 readiness 决定, 这里只把权重, 分组和 Metadata 回写到当前 pod 上
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	if r.podName == "" {
+		return nil
+	}
+	annotations := make(map[string]string, len(si.Metadata)+1)
+	if si.Weight > 0 {
+		annotations[r.weightAnnotation] = strconv.FormatUint(uint64(si.Weight), 10)
+	}
+	for key, val := range si.Metadata {
+		annotations[r.metadataPrefix+key] = val
+	}
+	meta := map[string]any{
+		"annotations": annotations,
+	}
+	if si.Group != "" {
+		meta["labels"] = map[string]string{r.groupLabel: si.Group}
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": meta})
+	if err != nil {
+		return err
+	}
+	_, err = r.client.CoreV1().Pods(r.namespace).
+		Patch(ctx, r.podName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// UnRegister 节点下线由 k8s 的 readiness 决定, 这里什么也不做
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return nil
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	slices, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: r.selector(serviceName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pods := r.pods(ctx, serviceName)
+	res := make([]registry.ServiceInstance, 0, 8)
+	// 同一个 pod 可能出现在多个 slice 里面 (例如 IPv4 和 IPv6)
+	seen := make(map[string]struct{}, 8)
+	for _, slice := range slices.Items {
+		port, ok := r.port(slice)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// ready 为 nil 的时候当作 ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+			addr := net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(port)))
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			si := registry.ServiceInstance{
+				Name:    serviceName,
+				Address: addr,
+			}
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+				// pod 可能刚好被删掉了, 节点本身还是可用的, 只是没有服务治理信息
+				if pod, ok := pods[ep.TargetRef.Name]; ok {
+					r.fillFromPod(pod, &si)
+				}
+			}
+			res = append(res, si)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return res, nil
+}
+
+// ListServiceNames 列出命名空间下所有有 EndpointSlice 的 Service
+func (r *Registry) ListServiceNames(ctx context.Context) ([]string, error) {
+	slices, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(slices.Items))
+	seen := make(map[string]struct{}, len(slices.Items))
+	for _, slice := range slices.Items {
+		name := slice.Labels[discoveryv1.LabelServiceName]
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// pods 用 Service 的 selector 一次列出所有 pod, 而不是每个 endpoint 查一次
+// 没有 selector 的 Service (手动维护的 EndpointSlice) 或者查询失败的时候返回 nil, 节点没有服务治理信息
+func (r *Registry) pods(ctx context.Context, serviceName string) map[string]*corev1.Pod {
+	selector, ok := r.podSelector(ctx, serviceName)
+	if !ok {
+		return nil
+	}
+	pods, err := r.client.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil
+	}
+	res := make(map[string]*corev1.Pod, len(pods.Items))
+	for i := range pods.Items {
+		res[pods.Items[i].Name] = &pods.Items[i]
+	}
+	return res
+}
+
+func (r *Registry) podSelector(ctx context.Context, serviceName string) (string, bool) {
+	svc, err := r.client.CoreV1().Services(r.namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil || len(svc.Spec.Selector) == 0 {
+		return "", false
+	}
+	return labels.SelectorFromSet(svc.Spec.Selector).String(), true
+}
+
+func (r *Registry) fillFromPod(pod *corev1.Pod, si *registry.ServiceInstance) {
+	si.Group = pod.Labels[r.groupLabel]
+	if val, ok := pod.Annotations[r.weightAnnotation]; ok {
+		weight, er := strconv.ParseUint(val, 10, 32)
+		if er == nil {
+			si.Weight = uint32(weight)
+		}
+	}
+	for key, val := range pod.Annotations {
+		if !strings.HasPrefix(key, r.metadataPrefix) {
+			continue
+		}
+		if si.Metadata == nil {
+			si.Metadata = make(map[string]string, 4)
+		}
+		si.Metadata[strings.TrimPrefix(key, r.metadataPrefix)] = val
+	}
+}
+
+func (r *Registry) port(slice discoveryv1.EndpointSlice) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if r.portName == "" || (p.Name != nil && *p.Name == r.portName) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// Subscribe 除了 EndpointSlice 还会 watch Service selector 选中的 pod
+// pod 上的 label 和 annotation 变了 EndpointSlice 不会变, 但是分组, 权重和 Metadata 变了
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mutex.Lock()
+	r.cancels = append(r.cancels, cancel)
+	r.mutex.Unlock()
+
+	opts := metav1.ListOptions{LabelSelector: r.selector(serviceName)}
+	watchSlices := func(ctx context.Context) (watch.Interface, error) {
+		return r.client.DiscoveryV1().EndpointSlices(r.namespace).Watch(ctx, opts)
+	}
+	sw, err := watchSlices(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Service 没有 selector 或者查询失败的时候不 watch pod, 和 ListServices 一样只是没有服务治理信息
+	var pw watch.Interface
+	var watchPods func(ctx context.Context) (watch.Interface, error)
+	var pods map[string]registry.ServiceInstance
+	if selector, ok := r.podSelector(ctx, serviceName); ok {
+		podOpts := metav1.ListOptions{LabelSelector: selector}
+		watchPods = func(ctx context.Context) (watch.Interface, error) {
+			return r.client.CoreV1().Pods(r.namespace).Watch(ctx, podOpts)
+		}
+		pods, pw = r.listWatchPods(ctx, podOpts)
+	}
+
+	res := make(chan registry.Event)
+	go func() {
+		defer func() {
+			if sw != nil {
+				sw.Stop()
+			}
+			if pw != nil {
+				pw.Stop()
+			}
+		}()
+		for {
+			var e watch.Event
+			var ok bool
+			select {
+			case e, ok = <-sw.ResultChan():
+				if !ok {
+					// apiserver 会定期断开 watch, 重新建立
+					sw = r.rewatch(ctx, watchSlices)
+					if sw == nil {
+						return
+					}
+					// 断开期间可能漏掉了事件, 让 resolver 全量拉取一次
+					e = watch.Event{Type: watch.Modified}
+				}
+			case e, ok = <-resultChan(pw):
+				if !ok {
+					pw = r.rewatch(ctx, watchPods)
+					if pw == nil {
+						return
+					}
+					e = watch.Event{Type: watch.Modified}
+				} else if !r.podChanged(pods, e) {
+					continue
+				}
+				// pod 变了对节点列表来说都是更新
+				e.Type = watch.Modified
+			case <-ctx.Done():
+				return
+			}
+			typ, ok := eventTypes[e.Type]
+			if !ok {
+				continue
+			}
+			select {
+			case res <- registry.Event{Type: typ}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return res, nil
+}
+
+var eventTypes = map[watch.EventType]string{
+	watch.Added:    "ADD",
+	watch.Modified: "UPDATE",
+	watch.Deleted:  "DELETE",
+}
+
+// listWatchPods 先列出 pod 记下现在的服务治理信息, 再从这个版本开始 watch
+func (r *Registry) listWatchPods(ctx context.Context, opts metav1.ListOptions) (map[string]registry.ServiceInstance, watch.Interface) {
+	list, err := r.client.CoreV1().Pods(r.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, nil
+	}
+	pods := make(map[string]registry.ServiceInstance, len(list.Items))
+	for i := range list.Items {
+		var si registry.ServiceInstance
+		r.fillFromPod(&list.Items[i], &si)
+		pods[list.Items[i].Name] = si
+	}
+	opts.ResourceVersion = list.ResourceVersion
+	w, err := r.client.CoreV1().Pods(r.namespace).Watch(ctx, opts)
+	if err != nil {
+		return nil, nil
+	}
+	return pods, w
+}
+
+// podChanged pod 的服务治理信息有没有变, pod 上下线以及 ready 状态由 EndpointSlice 负责
+func (r *Registry) podChanged(pods map[string]registry.ServiceInstance, e watch.Event) bool {
+	pod, ok := e.Object.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	if e.Type == watch.Deleted {
+		delete(pods, pod.Name)
+		return false
+	}
+	var si registry.ServiceInstance
+	r.fillFromPod(pod, &si)
+	old, ok := pods[pod.Name]
+	pods[pod.Name] = si
+	return e.Type == watch.Modified && (!ok || !reflect.DeepEqual(old, si))
+}
+
+func resultChan(w watch.Interface) <-chan watch.Event {
+	if w == nil {
+		return nil
+	}
+	return w.ResultChan()
+}
+
+func (r *Registry) rewatch(ctx context.Context, watchFn func(context.Context) (watch.Interface, error)) watch.Interface {
+	for {
+		w, err := watchFn(ctx)
+		if err == nil {
+			return w
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	cancels := r.cancels
+	r.cancels = nil
+	r.mutex.Unlock()
+	for _, c := range cancels {
+		c()
+	}
+	return nil
+}
+
+func (r *Registry) selector(serviceName string) string {
+	return discoveryv1.LabelServiceName + "=" + serviceName
+}
@@ -0,0 +1,210 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"micro/registry"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ListServices(t *testing.T) {
+	ready, notReady := true, false
+	port, portName := int32(8081), "grpc"
+	client := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "user-service", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "user-service"}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "user-service-a",
+				Namespace: "default",
+				Labels:    map[string]string{"app": "user-service", DefaultGroupLabel: "A"},
+				Annotations: map[string]string{
+					DefaultWeightAnnotation:           "10",
+					DefaultMetadataPrefix + "version": "v2",
+				},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "user-service-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "user-service"},
+			},
+			Ports: []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+			Endpoints: []discoveryv1.Endpoint{
+				{
+					Addresses:  []string{"10.0.0.1"},
+					Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+					TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: "user-service-a"},
+				},
+				{
+					Addresses:  []string{"10.0.0.2"},
+					Conditions: discoveryv1.EndpointConditions{Ready: &notReady},
+				},
+				{
+					// pod 已经没了
+					Addresses: []string{"10.0.0.3"},
+					TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "user-service-c"},
+				},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "order-service-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "order-service"},
+			},
+			Ports:     []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.0.1.1"}}},
+		},
+	)
+	// 不能每个 endpoint 查一次 pod
+	var podGets int
+	client.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		podGets++
+		return false, nil, nil
+	})
+	r, err := NewRegistry(client, WithPortName("grpc"))
+	require.NoError(t, err)
+
+	res, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Zero(t, podGets)
+	assert.Equal(t, []registry.ServiceInstance{
+		{
+			Name:     "user-service",
+			Address:  "10.0.0.1:8081",
+			Weight:   10,
+			Group:    "A",
+			Metadata: map[string]string{"version": "v2"},
+		},
+		{
+			Name:    "user-service",
+			Address: "10.0.0.3:8081",
+		},
+	}, res)
+
+	names, err := r.ListServiceNames(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order-service", "user-service"}, names)
+
+	// 没有对应名字的端口
+	r, err = NewRegistry(client, WithPortName("http"))
+	require.NoError(t, err)
+	res, err = r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestRegistry_Register(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-service-a", Namespace: "default"},
+	})
+	r, err := NewRegistry(client, WithPodName("user-service-a"))
+	require.NoError(t, err)
+	err = r.Register(context.Background(), registry.ServiceInstance{
+		Name:     "user-service",
+		Address:  "10.0.0.1:8081",
+		Weight:   10,
+		Group:    "A",
+		Metadata: map[string]string{"version": "v2"},
+	})
+	require.NoError(t, err)
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), "user-service-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{DefaultGroupLabel: "A"}, pod.Labels)
+	assert.Equal(t, map[string]string{
+		DefaultWeightAnnotation:           "10",
+		DefaultMetadataPrefix + "version": "v2",
+	}, pod.Annotations)
+
+	// 没有设置 pod 名字就什么也不做
+	r, err = NewRegistry(client)
+	require.NoError(t, err)
+	assert.NoError(t, r.Register(context.Background(), registry.ServiceInstance{Weight: 20}))
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := NewRegistry(client)
+	require.NoError(t, err)
+	defer r.Close()
+
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "user-service-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "user-service"},
+		},
+	}
+	_, err = client.DiscoveryV1().EndpointSlices("default").
+		Create(context.Background(), slice, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	select {
+	case e := <-events:
+		assert.Equal(t, registry.Event{Type: "ADD"}, e)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到 EndpointSlice 的变更事件")
+	}
+}
+
+func TestRegistry_SubscribePod(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "user-service", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "user-service"}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "user-service-a",
+				Namespace:   "default",
+				Labels:      map[string]string{"app": "user-service"},
+				Annotations: map[string]string{DefaultWeightAnnotation: "10"},
+			},
+		},
+	)
+	r, err := NewRegistry(client)
+	require.NoError(t, err)
+	defer r.Close()
+
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+
+	// pod 状态变了不用通知, EndpointSlice 会变
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), "user-service-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	pod.Status.Phase = corev1.PodRunning
+	_, err = client.CoreV1().Pods("default").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	select {
+	case e := <-events:
+		t.Fatalf("pod 状态变化不应该有事件: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = client.CoreV1().Pods("default").Patch(context.Background(), "user-service-a", types.MergePatchType,
+		[]byte(`{"metadata":{"annotations":{"micro.io/weight":"0"}}}`), metav1.PatchOptions{})
+	require.NoError(t, err)
+	select {
+	case e := <-events:
+		assert.Equal(t, registry.Event{Type: "UPDATE"}, e)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到 pod annotation 的变更事件")
+	}
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Multi 把多个注册中心组合成一个, 用于注册中心迁移
+// 写操作会同时写入所有的注册中心 (双注册)
+// 读操作会合并所有注册中心的结果, 同一个 Address 以优先级高的注册中心为准
+type Multi struct {
+	registries []Registry
+
+	cancels []func()
+	mutex   sync.Mutex
+}
+
+// NewMulti 优先级就是传入的顺序, 越靠前优先级越高.
+// 同一个 Address 在多个注册中心里面的时候, ListServices 返回最靠前的那个注册中心里面的实例,
+// 所以迁移的时候把旧的注册中心放在前面, 切换的时候再调换顺序
+func NewMulti(registries ...Registry) *Multi {
+	return &Multi{
+		registries: registries,
+	}
+}
+
+func (m *Multi) Register(ctx context.Context, si ServiceInstance) error {
+	return m.each(func(r Registry) error {
+		return r.Register(ctx, si)
+	})
+}
+
+func (m *Multi) UnRegister(ctx context.Context, si ServiceInstance) error {
+	return m.each(func(r Registry) error {
+		return r.UnRegister(ctx, si)
+	})
+}
+
+// ListServices 只要有一个注册中心可用就返回合并后的结果, 全部失败才返回 error
+// 迁移过程中某个注册中心挂了, 不应该影响服务发现
+func (m *Multi) ListServices(ctx context.Context, serviceName string) ([]ServiceInstance, error) {
+	results := make([][]ServiceInstance, len(m.registries))
+	errs := make([]error, len(m.registries))
+	var wg sync.WaitGroup
+	wg.Add(len(m.registries))
+	for i, r := range m.registries {
+		go func(i int, r Registry) {
+			defer wg.Done()
+			results[i], errs[i] = r.ListServices(ctx, serviceName)
+		}(i, r)
+	}
+	wg.Wait()
+
+	var (
+		res  []ServiceInstance
+		ok   bool
+		seen = make(map[string]struct{}, 8)
+	)
+	for i, ins := range results {
+		if errs[i] != nil {
+			continue
+		}
+		ok = true
+		for _, si := range ins {
+			if _, dup := seen[si.Address]; dup {
+				continue
+			}
+			seen[si.Address] = struct{}{}
+			res = append(res, si)
+		}
+	}
+	if !ok && len(m.registries) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return res, nil
+}
+
+// ListServiceNames 合并所有支持 Catalog 的注册中心的服务名
+func (m *Multi) ListServiceNames(ctx context.Context) ([]string, error) {
+	var (
+		res  []string
+		errs []error
+		ok   bool
+		seen = make(map[string]struct{}, 8)
+	)
+	for _, r := range m.registries {
+		c, is := r.(Catalog)
+		if !is {
+			continue
+		}
+		names, err := c.ListServiceNames(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ok = true
+		for _, name := range names {
+			if _, dup := seen[name]; dup {
+				continue
+			}
+			seen[name] = struct{}{}
+			res = append(res, name)
+		}
+	}
+	if !ok {
+		if len(errs) == 0 {
+			return nil, ErrNotSupported
+		}
+		return nil, errors.Join(errs...)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Update 更新所有支持 Updater 的注册中心, 不支持的退化为 Register
+func (m *Multi) Update(ctx context.Context, si ServiceInstance) error {
+	return m.each(func(r Registry) error {
+		if u, ok := r.(Updater); ok {
+			return u.Update(ctx, si)
+		}
+		return r.Register(ctx, si)
+	})
+}
+
+// Subscribe 汇聚所有注册中心的事件, 所有注册中心的 channel 都关闭之后关闭返回的 channel
+func (m *Multi) Subscribe(serviceName string) (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mutex.Lock()
+	m.cancels = append(m.cancels, cancel)
+	m.mutex.Unlock()
+
+	res := make(chan Event)
+	var (
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for _, r := range m.registries {
+		events, err := r.Subscribe(serviceName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return
+					}
+					select {
+					case res <- e:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	// 和 ListServices 一样, 有一个能订阅就可以
+	if len(errs) > 0 && len(errs) == len(m.registries) {
+		cancel()
+		return nil, errors.Join(errs...)
+	}
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
+	return res, nil
+}
+
+func (m *Multi) Close() error {
+	m.mutex.Lock()
+	cancels := m.cancels
+	m.cancels = nil
+	m.mutex.Unlock()
+	for _, c := range cancels {
+		c()
+	}
+	return m.each(func(r Registry) error {
+		return r.Close()
+	})
+}
+
+func (m *Multi) each(fn func(r Registry) error) error {
+	var errs []error
+	for _, r := range m.registries {
+		if err := fn(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMulti_ListServices(t *testing.T) {
+	testCases := []struct {
+		name       string
+		registries []Registry
+
+		wantErr error
+		wantRes []ServiceInstance
+	}{
+		{
+			name: "merge by priority",
+			registries: []Registry{
+				&memRegistry{instances: []ServiceInstance{
+					{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+				}},
+				&memRegistry{instances: []ServiceInstance{
+					{Name: "user-service", Address: "127.0.0.1:8081", Weight: 20},
+					{Name: "user-service", Address: "127.0.0.1:8082", Weight: 20},
+				}},
+			},
+			wantRes: []ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+				{Name: "user-service", Address: "127.0.0.1:8082", Weight: 20},
+			},
+		},
+		{
+			// 调换顺序之后以第二个注册中心为准
+			name: "reversed priority",
+			registries: []Registry{
+				&memRegistry{instances: []ServiceInstance{
+					{Name: "user-service", Address: "127.0.0.1:8081", Weight: 20},
+					{Name: "user-service", Address: "127.0.0.1:8082", Weight: 20},
+				}},
+				&memRegistry{instances: []ServiceInstance{
+					{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+				}},
+			},
+			wantRes: []ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 20},
+				{Name: "user-service", Address: "127.0.0.1:8082", Weight: 20},
+			},
+		},
+		{
+			name: "one failed",
+			registries: []Registry{
+				&memRegistry{err: errors.New("etcd down")},
+				&memRegistry{instances: []ServiceInstance{
+					{Name: "user-service", Address: "127.0.0.1:8082"},
+				}},
+			},
+			wantRes: []ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8082"},
+			},
+		},
+		{
+			name: "all failed",
+			registries: []Registry{
+				&memRegistry{err: errors.New("etcd down")},
+				&memRegistry{err: errors.New("consul down")},
+			},
+			wantErr: errors.Join(errors.New("etcd down"), errors.New("consul down")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMulti(tc.registries...)
+			res, err := m.ListServices(context.Background(), "user-service")
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestMulti_Register(t *testing.T) {
+	r1, r2 := &memRegistry{}, &memRegistry{}
+	m := NewMulti(r1, r2)
+	si := ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	require.NoError(t, m.Register(context.Background(), si))
+	assert.Equal(t, []ServiceInstance{si}, r1.instances)
+	assert.Equal(t, []ServiceInstance{si}, r2.instances)
+
+	require.NoError(t, m.UnRegister(context.Background(), si))
+	assert.Empty(t, r1.instances)
+	assert.Empty(t, r2.instances)
+
+	// 一个写失败了, 另一个还是要写进去
+	r1.err = errors.New("etcd down")
+	err := m.Register(context.Background(), si)
+	assert.Equal(t, errors.Join(errors.New("etcd down")), err)
+	assert.Equal(t, []ServiceInstance{si}, r2.instances)
+}
+
+func TestMulti_Subscribe(t *testing.T) {
+	r1, r2 := &memRegistry{events: make(chan Event)}, &memRegistry{events: make(chan Event)}
+	m := NewMulti(r1, r2)
+	events, err := m.Subscribe("user-service")
+	require.NoError(t, err)
+
+	for _, r := range []*memRegistry{r1, r2} {
+		go func(r *memRegistry) {
+			r.events <- Event{Type: "ADD"}
+		}(r)
+		select {
+		case e := <-events:
+			assert.Equal(t, Event{Type: "ADD"}, e)
+		case <-time.After(time.Second):
+			t.Fatal("没有收到事件")
+		}
+	}
+	require.NoError(t, m.Close())
+	assert.True(t, r1.closed)
+	assert.True(t, r2.closed)
+}
+
+func TestMulti_SubscribeClosed(t *testing.T) {
+	r1, r2 := &memRegistry{events: make(chan Event)}, &memRegistry{events: make(chan Event)}
+	m := NewMulti(r1, r2)
+	events, err := m.Subscribe("user-service")
+	require.NoError(t, err)
+
+	// 一个注册中心关闭了 channel, 不会一直转发零值, 另一个的事件照常收到
+	close(r1.events)
+	go func() {
+		r2.events <- Event{Type: "ADD"}
+	}()
+	select {
+	case e := <-events:
+		assert.Equal(t, Event{Type: "ADD"}, e)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到事件")
+	}
+
+	// 全部关闭之后返回的 channel 也关闭
+	close(r2.events)
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel 没有关闭")
+	}
+}
+
+// memRegistry 测试用的内存注册中心
+type memRegistry struct {
+	instances []ServiceInstance
+	events    chan Event
+	err       error
+	closed    bool
+}
+
+func (m *memRegistry) Register(ctx context.Context, si ServiceInstance) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.instances = append(m.instances, si)
+	return nil
+}
+
+func (m *memRegistry) UnRegister(ctx context.Context, si ServiceInstance) error {
+	if m.err != nil {
+		return m.err
+	}
+	res := m.instances[:0]
+	for _, ins := range m.instances {
+		if ins.Address != si.Address {
+			res = append(res, ins)
+		}
+	}
+	m.instances = res
+	return nil
+}
+
+func (m *memRegistry) ListServices(ctx context.Context, serviceName string) ([]ServiceInstance, error) {
+	return m.instances, m.err
+}
+
+func (m *memRegistry) Subscribe(serviceName string) (<-chan Event, error) {
+	return m.events, m.err
+}
+
+func (m *memRegistry) Close() error {
+	m.closed = true
+	return nil
+}
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
@@ -19,8 +20,23 @@ type Registry interface {
 	io.Closer
 }
 
+// Catalog 能列出所有服务名的注册中心, 用于运维管理
+type Catalog interface {
+	ListServiceNames(ctx context.Context) ([]string, error)
+}
+
+// Updater 能原地更新节点信息的注册中心
+// 和 Register 的区别在于不改变节点的生命周期, 例如 etcd 中不改变节点的租约
+type Updater interface {
+	Update(ctx context.Context, si ServiceInstance) error
+}
+
+var ErrNotSupported = errors.New("micro: 注册中心不支持该操作")
+
 type ServiceInstance struct {
 	Name string
+	// InstanceID 节点的唯一标识, 不随 Address 变化, 为空时使用 Address
+	InstanceID string
 	// Address 就是最关键的，定位信息
 	Address string
 
@@ -29,8 +45,23 @@ type ServiceInstance struct {
 	// 可以考虑再加一个分组字段
 	Group string
 
-	// 也可以用这个
-	//Attributes map[string]string
+	// 其余服务治理信息, 例如版本号, 机房
+	Metadata map[string]string
+}
+
+// MetadataDrained 被摘除的节点 Metadata 里面这个 key 的值是 "true", 服务发现的时候跳过
+// 和 UnRegister 不一样, 节点还在注册中心里面, 重新设置权重之后恢复
+const MetadataDrained = "drained"
+
+// Drainer 能把摘除状态和节点信息分开保存的注册中心
+// 节点续约或者重启之后重新注册不会清掉摘除状态, ListServices 返回的时候合并到 Metadata 里面
+type Drainer interface {
+	Drain(ctx context.Context, si ServiceInstance, drained bool) error
+}
+
+// Drained 节点是否被摘除了
+func (si ServiceInstance) Drained() bool {
+	return si.Metadata[MetadataDrained] == "true"
 }
 
 type Event struct {
@@ -5,6 +5,8 @@ import (
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/resolver"
 	"math"
+	"micro/balance"
+	"micro/observability"
 	"micro/route"
 	"sync/atomic"
 )
@@ -12,6 +14,7 @@ import (
 type Balancer struct {
 	connections []*activeConn
 	filter route.Filter
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -27,19 +30,23 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 		}
 	}
 	if res.cnt == math.MaxUint32 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	atomic.AddUint32(&res.cnt, 1)
+	b.metrics.Picked(res.c)
 	return balancer.PickResult{
 		SubConn: res.c,
 		Done: func(info balancer.DoneInfo) {
-			atomic.AddUint32(&res.cnt, -1)
+			// 无符号数减一
+			atomic.AddUint32(&res.cnt, ^uint32(0))
 		},
 	}, nil
 }
 
 type Builder struct {
 	Filter route.Filter
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -53,6 +60,7 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 	return &Balancer{
 		connections: conns,
 		filter: b.Filter,
+		metrics: balance.NewMetrics("route_least_active", b.Sink, info),
 	}
 }
 
@@ -63,4 +71,15 @@ type activeConn struct {
 	addr resolver.Address
 }
 
-
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	res := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		res = append(res, map[string]any{
+			"target": c.addr.Addr,
+			"group":  c.addr.Attributes.Value("group"),
+			"active": atomic.LoadUint32(&c.cnt),
+		})
+	}
+	return res
+}
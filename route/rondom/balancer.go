@@ -5,12 +5,15 @@ import (
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/resolver"
 	"math/rand"
+	"micro/balance"
+	"micro/observability"
 	"micro/route"
 )
 
 type Balancer struct {
 	connections []subConn
 	filter      route.Filter
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -22,9 +25,11 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 		candidates = append(candidates, c)
 	}
 	if len(candidates) == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	idx := rand.Intn(len(candidates))
+	b.metrics.Picked(candidates[idx].c)
 	return balancer.PickResult{
 		SubConn: candidates[idx].c,
 		Done: func(info balancer.DoneInfo) {
@@ -35,6 +40,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 
 type BalancerBuilder struct {
 	Filter route.Filter
+	Sink observability.Sink
 }
 
 func (b *BalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -48,6 +54,7 @@ func (b *BalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
 	return &Balancer{
 		connections: connections,
 		filter:      b.Filter,
+		metrics: balance.NewMetrics("route_random", b.Sink, info),
 	}
 }
 
@@ -55,3 +62,12 @@ type subConn struct {
 	c    balancer.SubConn
 	addr resolver.Address
 }
+
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	res := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		res = append(res, map[string]any{"target": c.addr.Addr, "group": c.addr.Attributes.Value("group")})
+	}
+	return res
+}
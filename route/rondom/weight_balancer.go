@@ -5,12 +5,15 @@ import (
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/resolver"
 	"math/rand"
+	"micro/balance"
+	"micro/observability"
 	"micro/route"
 )
 
 type WeightBalancer struct {
 	connections []*weightConn
 	filter      route.Filter
+	metrics *balance.Metrics
 }
 
 func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -25,6 +28,7 @@ func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 	}
 
 	if len(candidates) == 0 {
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 
@@ -37,6 +41,7 @@ func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 			break
 		}
 	}
+	b.metrics.Picked(candidates[idx].c)
 	return balancer.PickResult{
 		SubConn: candidates[idx].c,
 		Done: func(info balancer.DoneInfo) {
@@ -47,6 +52,7 @@ func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 
 type WeightBalancerBuilder struct {
 	Filter route.Filter
+	Sink observability.Sink
 }
 
 func (b *WeightBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -62,6 +68,7 @@ func (b *WeightBalancerBuilder) Build(info base.PickerBuildInfo) balancer.Picker
 	return &WeightBalancer{
 		connections: cs,
 		filter:      b.Filter,
+		metrics: balance.NewMetrics("route_weight_random", b.Sink, info),
 	}
 }
 
@@ -70,3 +77,16 @@ type weightConn struct {
 	weight uint32
 	addr   resolver.Address
 }
+
+// Inspect 调试接口展示的内部状态
+func (b *WeightBalancer) Inspect() any {
+	res := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		res = append(res, map[string]any{
+			"target": c.addr.Addr,
+			"group":  c.addr.Attributes.Value("group"),
+			"weight": c.weight,
+		})
+	}
+	return res
+}
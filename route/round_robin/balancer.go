@@ -4,6 +4,8 @@ import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/resolver"
+	"micro/balance"
+	"micro/observability"
 	"micro/route"
 	"sync/atomic"
 )
@@ -13,6 +15,7 @@ type Balancer struct {
 	index int32
 	length int32
 	filter route.Filter
+	metrics *balance.Metrics
 }
 
 func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -25,11 +28,13 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	}
 	if len(candidates) == 0 {
 		// 没有任何符合条件的节点，就用默认节点
+		b.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 	// 保证准确性, 使用原子操作读取数据
 	idx := atomic.AddInt32(&b.index, 1)
 	c := candidates[int(idx) % len(candidates)]
+	b.metrics.Picked(c.c)
 	return balancer.PickResult{
 		SubConn: c.c,
 		Done: func(info balancer.DoneInfo) {
@@ -40,6 +45,7 @@ func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 
 type Builder struct {
 	Filter route.Filter
+	Sink observability.Sink
 }
 
 func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -55,10 +61,20 @@ func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
 		index:       -1,  // 从第一个开始轮询
 		length: int32(len(info.ReadySCs)),
 		filter: b.Filter,
+		metrics: balance.NewMetrics("route_round_robin", b.Sink, info),
 	}
 }
 
 type subConn struct{
 	c balancer.SubConn
 	addr resolver.Address
-}
\ No newline at end of file
+}
+
+// Inspect 调试接口展示的内部状态
+func (b *Balancer) Inspect() any {
+	conns := make([]map[string]any, 0, len(b.connections))
+	for _, c := range b.connections {
+		conns = append(conns, map[string]any{"target": c.addr.Addr, "group": c.addr.Attributes.Value("group")})
+	}
+	return map[string]any{"connections": conns, "index": atomic.LoadInt32(&b.index)}
+}
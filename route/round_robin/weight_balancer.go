@@ -5,6 +5,8 @@ import (
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/resolver"
 	"math"
+	"micro/balance"
+	"micro/observability"
 	"micro/route"
 	"sync"
 )
@@ -12,6 +14,7 @@ import (
 type WeightBalancer struct {
 	connections []*weightConn
 	filter route.Filter
+	metrics *balance.Metrics
 }
 
 func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
@@ -29,6 +32,7 @@ func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 		}
 	}
 	if res == nil {
+		w.metrics.NoSubConn()
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 
@@ -36,6 +40,7 @@ func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 	// 根据加权轮询算法更新权重
 	res.currentWeight -= totalWeight
 	res.mutex.Unlock()
+	w.metrics.Picked(res.c)
 	return balancer.PickResult{
 		SubConn: res.c,
 		Done: func(info balancer.DoneInfo) {
@@ -58,7 +63,8 @@ func (w *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, erro
 }
 
 type WeightBalancerBulider struct {
-	Filter route.Filter	
+	Filter route.Filter
+	Sink observability.Sink
 }
 
 func (w *WeightBalancerBulider) Build(info base.PickerBuildInfo) balancer.Picker {
@@ -79,6 +85,7 @@ func (w *WeightBalancerBulider) Build(info base.PickerBuildInfo) balancer.Picker
 	return &WeightBalancer{
 		connections: cs,
 		filter: w.Filter,
+		metrics: balance.NewMetrics("route_weight_round_robin", w.Sink, info),
 	}
 }
 
@@ -89,4 +96,21 @@ type weightConn struct {
 	currentWeight   uint32 // 当前权重
 	efficientWeight uint32 // 有效权重
 	addr resolver.Address
-}
\ No newline at end of file
+}
+
+// Inspect 调试接口展示的内部状态
+func (w *WeightBalancer) Inspect() any {
+	res := make([]map[string]any, 0, len(w.connections))
+	for _, c := range w.connections {
+		c.mutex.Lock()
+		res = append(res, map[string]any{
+			"target":           c.addr.Addr,
+			"group":            c.addr.Attributes.Value("group"),
+			"weight":           c.weight,
+			"current_weight":   c.currentWeight,
+			"efficient_weight": c.efficientWeight,
+		})
+		c.mutex.Unlock()
+	}
+	return res
+}
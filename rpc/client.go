@@ -3,11 +3,10 @@ package rpc
 import (
 	"context"
 	"errors"
-	"github.com/silenceper/pool"
+	"micro/rpc/compress"
 	"micro/rpc/message"
 	"micro/rpc/serialize"
 	"micro/rpc/serialize/json"
-	"net"
 	"reflect"
 	"strconv"
 	"time"
@@ -15,7 +14,7 @@ import (
 
 // InitService 要为 GetById 之类的函数类型的字段赋值
 func (c *Client) InitService(service Service) error {
-	return setFuncField(service, c, c.serializer)
+	return setFuncField(service, chain(c, c.middlewares), c.serializer)
 }
 
 func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
@@ -46,14 +45,14 @@ func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
 					return []reflect.Value{retVal, reflect.ValueOf(err)}
 				}
 				
-				meta := make(map[string]string, 2)
+				meta := make(message.Meta, 2)
 				// 是否设置超时
 				if deadline, ok := ctx.Deadline(); ok {
-					meta["deadline"] = strconv.FormatInt(deadline.UnixMilli(), 10)
+					meta.Set("deadline", strconv.FormatInt(deadline.UnixMilli(), 10))
 				}
 				// 是否设置 Oneway
 				if isOneway(ctx) {
-					meta["oneway"] = "true"
+					meta.Set("oneway", "true")
 				}
 				req := &message.Request{
 					ServiceName: service.Name(),
@@ -104,8 +103,20 @@ func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
 type ClientOption func(client *Client)
 
 type Client struct {
-	pool pool.Pool
+	conns *connGroup
+	// 连接数, 每个连接都是多路复用的, 一般一个就够了
+	connections int
+	// 响应的头部和响应体的大小上限
+	maxHeader uint32
+	maxBody uint32
+	// 协议版本, 以及是否带上请求体的校验和
+	version uint8
+	checksum bool
 	serializer serialize.Serializer
+	// 请求体不小于 compressThreshold 的时候压缩, 服务端也会用同一个算法压缩响应
+	compressor compress.Compressor
+	compressThreshold int
+	middlewares []Middleware
 }
 
 func ClientWithSerializer(sl serialize.Serializer) ClientOption {
@@ -114,91 +125,109 @@ func ClientWithSerializer(sl serialize.Serializer) ClientOption {
 	}
 }
 
-func NewClient(addr string, opts ...ClientOption) (*Client,error) {
-	p, err := pool.NewChannelPool(&pool.Config{
-		InitialCap:  1,
-		MaxCap:      30,
-		MaxIdle:     10,
-		Factory: func() (interface{}, error) {
-			return net.DialTimeout("tcp", addr, time.Second*3)
-		},
-		Close: func(i interface{}) error {
-			return i.(net.Conn).Close()
-		},
-		IdleTimeout: time.Minute,
-	})
-	if err != nil {
-		return nil, err
+// ClientWithMiddlewares InitService 生成的方法会经过这些 Middleware
+func ClientWithMiddlewares(ms ...Middleware) ClientOption {
+	return func(client *Client) {
+		client.middlewares = append(client.middlewares, ms...)
 	}
-	
+}
+
+// ClientWithConnections 和服务端建立 n 个连接, 请求轮询使用, 默认一个
+// 每个连接上的请求是并发的, 只有单个连接的带宽不够的时候才需要多个
+func ClientWithConnections(n int) ClientOption {
+	return func(client *Client) {
+		if n > 0 {
+			client.connections = n
+		}
+	}
+}
+
+// ClientWithMaxFrameSize 响应的头部或者响应体超过上限的时候关闭连接, 默认 DefaultMaxHeaderSize 和 DefaultMaxBodySize
+func ClientWithMaxFrameSize(maxHeader, maxBody uint32) ClientOption {
+	return func(client *Client) {
+		client.maxHeader = maxHeader
+		client.maxBody = maxBody
+	}
+}
+
+// ClientWithProtocolVersion 默认 message.CurrentVersion, 服务端比较旧的时候可以用 message.Version1
+func ClientWithProtocolVersion(v uint8) ClientOption {
+	return func(client *Client) {
+		client.version = v
+	}
+}
+
+// ClientWithChecksum 请求带上请求体的 CRC32, 服务端的响应也会带上, 版本 1 不支持
+func ClientWithChecksum() ClientOption {
+	return func(client *Client) {
+		client.checksum = true
+	}
+}
+
+// ClientWithCompressor 服务端要 RegisterCompressor 同一个算法
+func ClientWithCompressor(c compress.Compressor) ClientOption {
+	return func(client *Client) {
+		client.compressor = c
+	}
+}
+
+// ClientWithCompressThreshold 默认 compress.DefaultThreshold
+func ClientWithCompressThreshold(n int) ClientOption {
+	return func(client *Client) {
+		client.compressThreshold = n
+	}
+}
+
+func NewClient(addr string, opts ...ClientOption) (*Client,error) {
 	res := &Client{
-		pool: p,
+		connections: 1,
+		maxHeader: DefaultMaxHeaderSize,
+		maxBody: DefaultMaxBodySize,
+		version: message.CurrentVersion,
 		serializer: &json.Serializer{},
+		compressThreshold: compress.DefaultThreshold,
 	}
 	for _, opt := range opts {
 		opt(res)
 	}
+	config := connConfig{
+		timeout: time.Second * 3,
+		maxHeader: res.maxHeader,
+		maxBody: res.maxBody,
+		version: res.version,
+	}
+	if res.checksum {
+		config.flags |= message.FlagChecksum
+	}
+	conns, err := newConnGroup(addr, res.connections, config)
+	if err != nil {
+		return nil, err
+	}
+	res.conns = conns
 	return res, nil
 }
 
+// Invoke 请求在连接上多路复用, 不需要等前一个请求返回
 func (c *Client) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	
-	// 另开一个 goruntine, 是一个信号量监听是否返回响应
-	ch := make(chan struct{})
-	defer func() {
-		close(ch)
-	}()
-	var (
-		resp *message.Response
-		err error
-	)
-	go func() {
-		resp, err = c.doInvoke(ctx, req)
-		ch <- struct{}{}
-	}()
-
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-ch:
-		return resp, err
-	}
-}
-
-func (c *Client) doInvoke(ctx context.Context, req *message.Request) (*message.Response, error) {
-	data := message.EncodeReq(req)
-	// 发送给服务端
-	resp, err := c.send(ctx, data)
+	conn, err := c.conns.pick()
 	if err != nil {
 		return nil, err
 	}
-	// 这里才算是中断
-	//if ctx.Err() != nil {
-	//	return nil, ctx.Err()
-	//}
-	return message.DecodeResp(resp), nil
-}
-
-func (c *Client) send(ctx context.Context, data []byte) ([]byte, error) {
-	val, err := c.pool.Get()
+	req, err = c.compressReq(req)
 	if err != nil {
 		return nil, err
 	}
-	conn := val.(net.Conn)
-	defer func() {
-		_ = c.pool.Put(conn)
-	}()
-	_, err = conn.Write(data)
+	resp, err := conn.call(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	// 检测是否是 oneway 调用
-	if isOneway(ctx) {
-		return nil, errors.New("micro: 这是一个 oneway 调用，你不应该处理任何结果")
-	}
-	return ReadMsg(conn)
+	return c.decompressResp(resp)
 }
 
+// Close 关闭所有连接, 等待中的请求返回错误
+func (c *Client) Close() error {
+	return c.conns.Close()
+}
@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"fmt"
+	"micro/rpc/compress"
+	"micro/rpc/message"
+)
+
+// compressData 小于 threshold 或者压缩之后没有变小的时候不压缩, 返回 false
+func compressData(c compress.Compressor, data []byte, threshold int) ([]byte, bool, error) {
+	if c == nil || len(data) < threshold {
+		return data, false, nil
+	}
+	res, err := c.Compress(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(res) >= len(data) {
+		return data, false, nil
+	}
+	return res, true, nil
+}
+
+func unsupportedCompresser(code uint8) error {
+	return fmt.Errorf("micro: 不支持的压缩算法 %d", code)
+}
+
+// compressReq 不修改调用方的请求. 版本 2 开始 Compresser 总是客户端的压缩算法, 告诉服务端可以压缩响应
+func (c *Client) compressReq(req *message.Request) (*message.Request, error) {
+	if c.compressor == nil {
+		return req, nil
+	}
+	data, ok, err := compressData(c.compressor, req.Data, c.compressThreshold)
+	if err != nil {
+		return nil, err
+	}
+	res := *req
+	res.Data = data
+	res.Compresser = c.compressor.Code()
+	if ok {
+		res.Flags |= message.FlagCompressed
+	} else if c.version == message.Version1 {
+		// 版本 1 的 Compresser 不为 0 就表示压缩过
+		res.Compresser = 0
+	}
+	res.CalculateBodyLength()
+	return &res, nil
+}
+
+// decompressResp 服务端只会用客户端的压缩算法压缩响应
+func (c *Client) decompressResp(resp *message.Response) (*message.Response, error) {
+	if !resp.Compressed() {
+		return resp, nil
+	}
+	if c.compressor == nil || c.compressor.Code() != resp.Compresser {
+		return nil, unsupportedCompresser(resp.Compresser)
+	}
+	data, err := c.compressor.Decompress(resp.Data, int(c.maxBody))
+	if err != nil {
+		return nil, err
+	}
+	resp.Data = data
+	resp.Flags &^= message.FlagCompressed
+	resp.Compresser = 0
+	resp.CalculateBodyLength()
+	return resp, nil
+}
+
+// decompressReq 返回客户端支持的压缩算法, 用来压缩响应, 服务端没有注册的时候返回 nil
+// 解压之后请求的 Compresser 是 0, Middleware 和服务看到的都是原始数据
+func (s *Server) decompressReq(req *message.Request) (compress.Compressor, error) {
+	if req.Compresser == 0 {
+		return nil, nil
+	}
+	compressor, ok := s.compressors[req.Compresser]
+	if !req.Compressed() {
+		req.Compresser = 0
+		return compressor, nil
+	}
+	if !ok {
+		return nil, unsupportedCompresser(req.Compresser)
+	}
+	data, err := compressor.Decompress(req.Data, int(s.maxBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Data = data
+	req.Flags &^= message.FlagCompressed
+	req.Compresser = 0
+	req.CalculateBodyLength()
+	return compressor, nil
+}
+
+// compressResp 按照请求的版本设置 Compresser 和 FlagCompressed
+func (s *Server) compressResp(resp *message.Response, c compress.Compressor) error {
+	resp.Compresser = 0
+	resp.Flags &^= message.FlagCompressed
+	data, ok, err := compressData(c, resp.Data, s.compressThreshold)
+	if err != nil || !ok {
+		return err
+	}
+	resp.Data = data
+	resp.Compresser = c.Code()
+	resp.Flags |= message.FlagCompressed
+	return nil
+}
@@ -0,0 +1,117 @@
+package compress_test
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	kzstd "github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/rpc/compress"
+	"micro/rpc/compress/gzip"
+	"micro/rpc/compress/snappy"
+	"micro/rpc/compress/zstd"
+	"testing"
+)
+
+func TestCompressor(t *testing.T) {
+	testCases := []struct {
+		name string
+		c    compress.Compressor
+	}{
+		{name: "gzip", c: &gzip.Compressor{}},
+		{name: "zstd", c: &zstd.Compressor{}},
+		{name: "snappy", c: &snappy.Compressor{}},
+	}
+	data := bytes.Repeat([]byte(`{"id":123,"name":"micro"}`), 100)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NotZero(t, tc.c.Code())
+			compressed, err := tc.c.Compress(data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+
+			res, err := tc.c.Decompress(compressed, len(data))
+			require.NoError(t, err)
+			assert.Equal(t, data, res)
+
+			// 解压之后超过上限
+			_, err = tc.c.Decompress(compressed, len(data)-1)
+			assert.Equal(t, compress.ErrTooLarge, err)
+
+			_, err = tc.c.Decompress([]byte("not compressed"), len(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestDecompress_Limit 对端可以不写原始大小, 或者发多个帧, 解压的时候也要检查上限
+func TestDecompress_Limit(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	testCases := []struct {
+		name       string
+		c          compress.Compressor
+		compressed func(t *testing.T) []byte
+	}{
+		{
+			name: "zstd without content size",
+			c:    &zstd.Compressor{},
+			compressed: func(t *testing.T) []byte {
+				var buf bytes.Buffer
+				// 窗口比上限大的帧直接拒绝, 这里要测的是解压过程中的检查
+				w, err := kzstd.NewWriter(&buf, kzstd.WithWindowSize(64<<10))
+				require.NoError(t, err)
+				_, err = w.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				var header kzstd.Header
+				require.NoError(t, header.Decode(buf.Bytes()))
+				require.False(t, header.HasFCS)
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "zstd multiple frames",
+			c:    &zstd.Compressor{},
+			compressed: func(t *testing.T) []byte {
+				frame, err := (&zstd.Compressor{}).Compress(data[:1<<10])
+				require.NoError(t, err)
+				// 每个帧都不超过上限, 加起来超过
+				return bytes.Repeat(frame, 1<<10)
+			},
+		},
+		{
+			name: "gzip multiple members",
+			c:    &gzip.Compressor{},
+			compressed: func(t *testing.T) []byte {
+				var buf bytes.Buffer
+				for i := 0; i < 1<<10; i++ {
+					w := stdgzip.NewWriter(&buf)
+					_, err := w.Write(data[:1<<10])
+					require.NoError(t, err)
+					require.NoError(t, w.Close())
+				}
+				return buf.Bytes()
+			},
+		},
+		{
+			name: "snappy",
+			c:    &snappy.Compressor{},
+			compressed: func(t *testing.T) []byte {
+				res, err := (&snappy.Compressor{}).Compress(data)
+				require.NoError(t, err)
+				return res
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed := tc.compressed(t)
+			_, err := tc.c.Decompress(compressed, 64<<10)
+			assert.Equal(t, compress.ErrTooLarge, err)
+
+			res, err := tc.c.Decompress(compressed, len(data))
+			require.NoError(t, err)
+			assert.Equal(t, data, res)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"micro/rpc/compress"
+	"sync"
+)
+
+// Compressor 使用标准库的 gzip, Writer 比较重, 复用起来
+type Compressor struct {
+	writers sync.Pool
+}
+
+func (c *Compressor) Code() uint8 {
+	return 1
+}
+
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Compressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// 多读一个字节才知道有没有超过上限
+	res, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > limit {
+		return nil, compress.ErrTooLarge
+	}
+	return res, nil
+}
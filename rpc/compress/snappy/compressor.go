@@ -0,0 +1,29 @@
+package snappy
+
+import (
+	"github.com/golang/snappy"
+	"micro/rpc/compress"
+)
+
+// Compressor 压缩率不高, 但是最快, 适合内网
+type Compressor struct{}
+
+func (c *Compressor) Code() uint8 {
+	return 3
+}
+
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *Compressor) Decompress(data []byte, limit int) ([]byte, error) {
+	// 原始大小记录在开头, 解压之前就能检查
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, compress.ErrTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
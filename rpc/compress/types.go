@@ -0,0 +1,17 @@
+package compress
+
+import "errors"
+
+// DefaultThreshold 小于这个大小的消息体不压缩, 压缩省下的字节抵不上 CPU 开销
+const DefaultThreshold = 1 << 10
+
+// ErrTooLarge 解压之后超过上限, 避免很小的数据解压出很大的内存
+var ErrTooLarge = errors.New("micro: 解压之后的数据超过上限")
+
+// Compressor 和 serialize.Serializer 一样用 Code 区分, 0 表示不压缩
+type Compressor interface {
+	Code() uint8
+	Compress(data []byte) ([]byte, error)
+	// Decompress 解压之后超过 limit 个字节返回 ErrTooLarge
+	Decompress(data []byte, limit int) ([]byte, error)
+}
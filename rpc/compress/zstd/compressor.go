@@ -0,0 +1,68 @@
+package zstd
+
+import (
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"micro/rpc/compress"
+	"sync"
+)
+
+// Compressor 压缩率比 gzip 高, 速度也更快
+// Encoder 和 Decoder 可以并发使用, 第一次用到的时候创建
+type Compressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	err     error
+	// decoders 解压的上限要在创建 Decoder 的时候设置, 按照上限缓存, 一般只有一两个
+	decoders sync.Map
+}
+
+func (c *Compressor) Code() uint8 {
+	return 2
+}
+
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil)
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+// Decompress 帧头里面的原始大小是对端给的, 可能没有, 也可能有多个帧,
+// 所以由 Decoder 在解压的过程中检查上限, 而不是只检查帧头
+func (c *Compressor) Decompress(data []byte, limit int) ([]byte, error) {
+	decoder, err := c.decoder(limit)
+	if err != nil {
+		return nil, err
+	}
+	res, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, compress.ErrTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Compressor) decoder(limit int) (*zstd.Decoder, error) {
+	if d, ok := c.decoders.Load(limit); ok {
+		return d.(*zstd.Decoder), nil
+	}
+	if limit <= 0 {
+		return nil, compress.ErrTooLarge
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxMemory(uint64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	res, loaded := c.decoders.LoadOrStore(limit, d)
+	if loaded {
+		d.Close()
+	}
+	return res.(*zstd.Decoder), nil
+}
@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"micro/rpc/message"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errConnClosed = errors.New("micro: 连接已经关闭")
+
+// clientConn 多路复用的连接, 一个连接上可以同时有多个请求
+// 每个请求分配一个 RequestID, 读协程按照 RequestID 把响应交给等待的调用者
+// 所以服务端可以乱序返回响应
+type clientConn struct {
+	conn *frameConn
+	// 请求使用的协议版本和 Flags
+	version uint8
+	flags   uint8
+
+	mutex   sync.Mutex
+	pending map[uint32]chan *message.Response
+	nextID  uint32
+	// 读协程退出的原因, 之后的请求都直接返回这个错误
+	err    error
+	closed chan struct{}
+}
+
+func newClientConn(conn *frameConn, version, flags uint8) *clientConn {
+	res := &clientConn{
+		conn:    conn,
+		version: version,
+		flags:   flags,
+		pending: make(map[uint32]chan *message.Response, 16),
+		closed:  make(chan struct{}),
+	}
+	go res.readLoop()
+	return res
+}
+
+// call 发送请求并等待响应, oneway 请求发出去就返回
+func (c *clientConn) call(ctx context.Context, req *message.Request) (*message.Response, error) {
+	oneway := isOneway(ctx)
+	var ch chan *message.Response
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	req.RequestID = c.newID()
+	req.Version = c.version
+	req.Flags = c.flags | req.Flags&message.FlagCompressed
+	// 版本和 Flags 会影响头部长度
+	req.CalculateHeaderLength()
+	if !oneway {
+		ch = make(chan *message.Response, 1)
+		c.pending[req.RequestID] = ch
+	}
+	c.mutex.Unlock()
+
+	bs, err := message.EncodeReq(req)
+	if err != nil {
+		c.remove(req.RequestID)
+		return nil, err
+	}
+	if err = c.write(ctx, bs); err != nil {
+		c.remove(req.RequestID)
+		return nil, err
+	}
+	if oneway {
+		return nil, errors.New("micro: 这是一个 oneway 调用，你不应该处理任何结果")
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		// 响应晚到的话读协程找不到调用者, 直接丢掉
+		c.remove(req.RequestID)
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, c.err
+	}
+}
+
+// newID 跳过 0 和还在等待响应的 ID, 调用者持有 mutex
+func (c *clientConn) newID() uint32 {
+	for {
+		c.nextID++
+		if _, ok := c.pending[c.nextID]; c.nextID != 0 && !ok {
+			return c.nextID
+		}
+	}
+}
+
+func (c *clientConn) remove(id uint32) {
+	c.mutex.Lock()
+	delete(c.pending, id)
+	c.mutex.Unlock()
+}
+
+// write 写超时之后连接上可能只写了半个请求, 只能关掉连接
+func (c *clientConn) write(ctx context.Context, data []byte) error {
+	deadline, _ := ctx.Deadline()
+	err := c.conn.WriteMsg(data, deadline)
+	if err != nil {
+		c.close(err)
+	}
+	return err
+}
+
+// readLoop 读出错之后连接就不能用了, 包括 ProtocolError
+func (c *clientConn) readLoop() {
+	defer c.conn.release()
+	for {
+		data, err := c.conn.ReadMsg()
+		if err != nil {
+			c.close(err)
+			return
+		}
+		resp, err := message.DecodeResp(data)
+		if err != nil {
+			// 不支持的版本, 校验和不对, 格式错误, 后面的数据也不能相信了
+			c.close(&ProtocolError{Err: err})
+			return
+		}
+		c.mutex.Lock()
+		ch, ok := c.pending[resp.RequestID]
+		delete(c.pending, resp.RequestID)
+		c.mutex.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// close 关闭连接, 所有等待响应的调用者都会返回 err
+func (c *clientConn) close(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.pending = make(map[uint32]chan *message.Response)
+	close(c.closed)
+	_ = c.conn.conn.Close()
+}
+
+func (c *clientConn) alive() bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// connGroup 固定数量的多路复用连接, 轮询使用, 断开之后下次使用的时候重连
+type connGroup struct {
+	addr   string
+	config connConfig
+	index  uint32
+	dial   func(network, addr string, timeout time.Duration) (net.Conn, error)
+
+	mutex  sync.Mutex
+	conns  []*clientConn
+	closed bool
+}
+
+// connConfig 每个连接的配置
+type connConfig struct {
+	timeout   time.Duration
+	maxHeader uint32
+	maxBody   uint32
+	version   uint8
+	flags     uint8
+}
+
+func newConnGroup(addr string, size int, config connConfig) (*connGroup, error) {
+	res := &connGroup{addr: addr, config: config, dial: net.DialTimeout, conns: make([]*clientConn, size)}
+	for i := range res.conns {
+		if _, err := res.get(i); err != nil {
+			_ = res.Close()
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (g *connGroup) pick() (*clientConn, error) {
+	i := atomic.AddUint32(&g.index, 1)
+	return g.get(int(i % uint32(len(g.conns))))
+}
+
+// get 建立连接的时候不持有锁, 对端很慢的时候不会阻塞使用其它连接的调用者
+// 多个调用者可能同时重连同一个位置, 先放进去的为准, 其它的关掉
+func (g *connGroup) get(i int) (*clientConn, error) {
+	g.mutex.Lock()
+	c, closed := g.conns[i], g.closed
+	g.mutex.Unlock()
+	if closed {
+		return nil, errConnClosed
+	}
+	if c != nil && c.alive() {
+		return c, nil
+	}
+	conn, err := g.dial("tcp", g.addr, g.config.timeout)
+	if err != nil {
+		return nil, err
+	}
+	nc := newClientConn(newFrameConn(conn, g.config.maxHeader, g.config.maxBody),
+		g.config.version, g.config.flags)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		nc.close(errConnClosed)
+		return nil, errConnClosed
+	}
+	if c = g.conns[i]; c != nil && c.alive() {
+		nc.close(errConnClosed)
+		return c, nil
+	}
+	g.conns[i] = nc
+	return nc, nil
+}
+
+func (g *connGroup) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.closed = true
+	for _, c := range g.conns {
+		if c != nil {
+			c.close(errConnClosed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"micro/rpc/compress"
+	"micro/rpc/compress/gzip"
+	"micro/rpc/compress/snappy"
+	"micro/rpc/compress/zstd"
+	"micro/rpc/message"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Multiplex(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	// 一个连接上的请求并发处理, 先发的请求睡得更久, 响应是乱序返回的
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			resp, er := usClient.GetById(context.Background(), &GetByIdReq{Id: id})
+			assert.NoError(t, er)
+			assert.Equal(t, strconv.Itoa(id), resp.Msg)
+		}(i)
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_MultiplexTimeout(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = usClient.GetById(ctx, &GetByIdReq{Id: 0})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 超时的响应晚到也不会交给后面的请求
+	resp, err := usClient.GetById(context.Background(), &GetByIdReq{Id: 9})
+	require.NoError(t, err)
+	assert.Equal(t, "9", resp.Msg)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	conn, err := client.conns.pick()
+	require.NoError(t, err)
+	conn.close(errConnClosed)
+	resp, err := usClient.GetById(context.Background(), &GetByIdReq{Id: 9})
+	require.NoError(t, err)
+	assert.Equal(t, "9", resp.Msg)
+}
+
+func TestServer_MaxConcurrentStreams(t *testing.T) {
+	service := &countService{}
+	server := NewServer(ServerWithMaxConcurrentStreams(2))
+	server.RegisterServer(service)
+	addr := serveTest(t, server)
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	// 一个连接上的请求超过上限的时候排队处理, 不会失败
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			resp, er := usClient.GetById(context.Background(), &GetByIdReq{Id: id})
+			assert.NoError(t, er)
+			assert.Equal(t, strconv.Itoa(id), resp.Msg)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), service.max.Load())
+}
+
+func TestConnGroup_SlowDial(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	g, err := newConnGroup(addr, 2, connConfig{timeout: time.Second,
+		maxHeader: DefaultMaxHeaderSize, maxBody: DefaultMaxBodySize, version: message.CurrentVersion})
+	require.NoError(t, err)
+	defer g.Close()
+
+	// 第二个连接断开了, 重连的时候对端很慢, 不会阻塞使用第一个连接的调用者
+	g.conns[1].close(errConnClosed)
+	dialing, block := make(chan struct{}), make(chan struct{})
+	defer close(block)
+	g.dial = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		close(dialing)
+		<-block
+		return nil, context.DeadlineExceeded
+	}
+	go func() {
+		_, _ = g.get(1)
+	}()
+	<-dialing
+	start := time.Now()
+	c, err := g.get(0)
+	require.NoError(t, err)
+	assert.True(t, c.alive())
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	// 头部长度和请求体长度都是 0xffffffff
+	_, err = conn.Write([]byte{message.Magic[0], message.Magic[1], message.Version2, 0,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	require.NoError(t, err)
+	// 服务端直接关闭连接, 不会分配 8GB 的内存
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestClient_ProtocolVersion(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	testCases := []struct {
+		name string
+		opts []ClientOption
+	}{
+		{
+			name: "version 1",
+			opts: []ClientOption{ClientWithProtocolVersion(message.Version1)},
+		},
+		{
+			name: "version 2",
+			opts: []ClientOption{ClientWithProtocolVersion(message.Version2)},
+		},
+		{
+			name: "checksum",
+			opts: []ClientOption{ClientWithChecksum()},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(addr, tc.opts...)
+			require.NoError(t, err)
+			defer client.Close()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			resp, err := usClient.GetById(context.Background(), &GetByIdReq{Id: 9})
+			require.NoError(t, err)
+			assert.Equal(t, "9", resp.Msg)
+		})
+	}
+}
+
+func TestClient_V1HeadTooLarge(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	large := func(next Proxy) Proxy {
+		return ProxyFunc(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			req.Meta.Set("baggage", strings.Repeat("a", message.MaxV1HeadLength))
+			req.CalculateHeaderLength()
+			return next.Invoke(ctx, req)
+		})
+	}
+	client, err := NewClient(addr, ClientWithProtocolVersion(message.Version1), ClientWithMiddlewares(large))
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+	_, err = usClient.GetById(context.Background(), &GetByIdReq{Id: 9})
+	assert.ErrorIs(t, err, message.ErrHeadTooLarge)
+}
+
+func TestServer_V1HeadTooLarge(t *testing.T) {
+	addr := startTestServer(t, &largeErrorService{})
+	client, err := NewClient(addr, ClientWithProtocolVersion(message.Version1))
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+	// 错误信息放不进版本 1 的头部, 客户端收到的是编码失败的原因
+	_, err = usClient.GetById(context.Background(), &GetByIdReq{Id: 9})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), message.ErrHeadTooLarge.Error())
+}
+
+func TestServer_UnsupportedVersion(t *testing.T) {
+	addr := startTestServer(t, &slowService{})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	req := &message.Request{RequestID: 7, Version: 9, ServiceName: "user-service", MethodName: "GetById"}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	bs, err := message.EncodeReq(req)
+	require.NoError(t, err)
+	_, err = conn.Write(bs)
+	require.NoError(t, err)
+	data, err := ReadMsg(conn)
+	require.NoError(t, err)
+	resp, err := message.DecodeResp(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), resp.RequestID)
+	assert.Equal(t, (&message.VersionError{Version: 9}).Error(), string(resp.Error))
+}
+
+func TestClient_Compress(t *testing.T) {
+	server := NewServer()
+	server.RegisterServer(&repeatService{})
+	server.RegisterCompressor(&gzip.Compressor{})
+	server.RegisterCompressor(&zstd.Compressor{})
+	server.RegisterCompressor(&snappy.Compressor{})
+	addr := serveTest(t, server)
+	testCases := []struct {
+		name string
+		opts []ClientOption
+		id   int
+	}{
+		{
+			name: "gzip",
+			opts: []ClientOption{ClientWithCompressor(&gzip.Compressor{})},
+			id:   2000,
+		},
+		{
+			name: "zstd",
+			opts: []ClientOption{ClientWithCompressor(&zstd.Compressor{})},
+			id:   2000,
+		},
+		{
+			name: "snappy",
+			opts: []ClientOption{ClientWithCompressor(&snappy.Compressor{})},
+			id:   2000,
+		},
+		{
+			// 响应小于阈值, 不压缩
+			name: "below threshold",
+			opts: []ClientOption{ClientWithCompressor(&gzip.Compressor{})},
+			id:   10,
+		},
+		{
+			// 版本 1 请求没有压缩的时候服务端也不会压缩响应
+			name: "version 1",
+			opts: []ClientOption{ClientWithCompressor(&gzip.Compressor{}),
+				ClientWithProtocolVersion(message.Version1)},
+			id: 2000,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(addr, tc.opts...)
+			require.NoError(t, err)
+			defer client.Close()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			resp, err := usClient.GetById(context.Background(), &GetByIdReq{Id: tc.id})
+			require.NoError(t, err)
+			assert.Equal(t, strings.Repeat("a", tc.id), resp.Msg)
+		})
+	}
+}
+
+func TestServer_Compress(t *testing.T) {
+	server := NewServer()
+	server.RegisterServer(&repeatService{})
+	server.RegisterCompressor(&gzip.Compressor{})
+	addr := serveTest(t, server)
+	compressed, err := (&gzip.Compressor{}).Compress([]byte(`{"Id":2000}`))
+	require.NoError(t, err)
+	testCases := []struct {
+		name    string
+		req     *message.Request
+		wantErr string
+		// 响应是否压缩过
+		compressed bool
+	}{
+		{
+			name: "compressed request",
+			req: &message.Request{Version: message.Version3, Flags: message.FlagCompressed,
+				Compresser: 1, Serializer: 1, Data: compressed},
+			compressed: true,
+		},
+		{
+			// 请求没有压缩, Compresser 表示客户端支持 gzip
+			name: "accept gzip",
+			req: &message.Request{Version: message.Version3,
+				Compresser: 1, Serializer: 1, Data: []byte(`{"Id":2000}`)},
+			compressed: true,
+		},
+		{
+			// 版本 1 没有 Flags, Compresser 不为 0 就是压缩过
+			name: "version 1",
+			req: &message.Request{Version: message.Version1,
+				Compresser: 1, Serializer: 1, Data: compressed},
+			compressed: true,
+		},
+		{
+			name: "not registered",
+			req: &message.Request{Version: message.Version3, Flags: message.FlagCompressed,
+				Compresser: 9, Serializer: 1, Data: compressed},
+			wantErr: "micro: 不支持的压缩算法 9",
+		},
+		{
+			// 服务端没有注册, 不压缩响应
+			name: "accept not registered",
+			req: &message.Request{Version: message.Version3,
+				Compresser: 9, Serializer: 1, Data: []byte(`{"Id":2000}`)},
+		},
+		{
+			name: "corrupted",
+			req: &message.Request{Version: message.Version3, Flags: message.FlagCompressed,
+				Compresser: 1, Serializer: 1, Data: []byte(`{"Id":2000}`)},
+			wantErr: "gzip: invalid header",
+		},
+	}
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.ServiceName = "user-service"
+			tc.req.MethodName = "GetById"
+			tc.req.CalculateHeaderLength()
+			tc.req.CalculateBodyLength()
+			bs, err := message.EncodeReq(tc.req)
+			require.NoError(t, err)
+			_, err = conn.Write(bs)
+			require.NoError(t, err)
+			data, err := ReadMsg(conn)
+			require.NoError(t, err)
+			resp, err := message.DecodeResp(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantErr, string(resp.Error))
+			assert.Equal(t, tc.compressed, resp.Compressed())
+			if tc.wantErr != "" {
+				return
+			}
+			if resp.Compressed() {
+				assert.Equal(t, uint8(1), resp.Compresser)
+				resp.Data, err = (&gzip.Compressor{}).Decompress(resp.Data, compress.DefaultThreshold*4)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, `{"Msg":"`+strings.Repeat("a", 2000)+`"}`, string(resp.Data))
+		})
+	}
+}
+
+func startTestServer(t *testing.T, service Service) string {
+	server := NewServer()
+	server.RegisterServer(service)
+	return serveTest(t, server)
+}
+
+func serveTest(t *testing.T, server *Server) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+	go func() {
+		for {
+			conn, er := lis.Accept()
+			if er != nil {
+				return
+			}
+			go func() {
+				_ = server.handleConn(conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// slowService Id 越小睡得越久
+type slowService struct{}
+
+func (s *slowService) Name() string {
+	return "user-service"
+}
+
+func (s *slowService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	time.Sleep(time.Duration(10-req.Id%10) * 10 * time.Millisecond)
+	return &GetByIdResp{Msg: strconv.Itoa(req.Id)}, nil
+}
+
+// repeatService 返回 Id 个 a, 用来测试压缩
+type repeatService struct{}
+
+func (s *repeatService) Name() string {
+	return "user-service"
+}
+
+func (s *repeatService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	return &GetByIdResp{Msg: strings.Repeat("a", req.Id)}, nil
+}
+
+// largeErrorService 返回的错误信息超过了版本 1 的头部上限
+type largeErrorService struct{}
+
+func (s *largeErrorService) Name() string {
+	return "user-service"
+}
+
+func (s *largeErrorService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	return nil, errors.New(strings.Repeat("a", message.MaxV1HeadLength))
+}
+
+// countService 记录同时处理的请求数的最大值
+type countService struct {
+	current atomic.Int32
+	max     atomic.Int32
+}
+
+func (s *countService) Name() string {
+	return "user-service"
+}
+
+func (s *countService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	n := s.current.Add(1)
+	defer s.current.Add(-1)
+	for {
+		m := s.max.Load()
+		if n <= m || s.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return &GetByIdResp{Msg: strconv.Itoa(req.Id)}, nil
+}
@@ -0,0 +1,52 @@
+// Package message rpc 协议的编解码
+//
+// 所有整数都是大端序. 一个消息分成头部和消息体两部分, HeadLength 是整个头部的长度,
+// 包括长度字段本身, BodyLength 是消息体的长度.
+//
+// 版本 2 和版本 3 (Version3, 默认) 的固定部分是一样的:
+//
+//	偏移  长度  字段
+//	0     2     魔数 0x6d 0x63 ("mc"), 不是这两个字节的连接直接拒绝
+//	2     1     Version
+//	3     1     Flags, FlagChecksum 表示头部带有消息体的 CRC32, FlagCompressed 表示消息体压缩过
+//	4     4     HeadLength
+//	8     4     BodyLength
+//	12    4     RequestID
+//	16    1     Compresser
+//	17    1     Serializer
+//	18    4     消息体的 CRC32 (Castagnoli), 只有 Flags 带有 FlagChecksum 的时候才有
+//
+// 前 16 个字节在以后的版本里面也不会变, 收到不支持的版本时可以用 RequestID 回复错误.
+//
+// 版本 1 (Version1, 兼容旧的客户端): 没有魔数和 Flags
+//
+//	偏移  长度  字段
+//	0     4     HeadLength
+//	4     4     BodyLength
+//	8     4     RequestID
+//	12    1     Version, 旧的客户端不会设置, 解码的时候总是当成 Version1
+//	13    1     Compresser
+//	14    1     Serializer
+//
+// 版本 1 的头部长度不能超过 64KB (MaxV1HeadLength), 所以前两个字节一定是 0, 以此和之后的版本区分.
+// 编码的时候超过了就返回 ErrHeadTooLarge, 和连接上允许的最大头部无关.
+//
+// 固定部分之后是变长部分. 响应的变长部分是错误信息. 请求的变长部分在版本 3 里面是
+//
+//	uvarint 长度 + ServiceName
+//	uvarint 长度 + MethodName
+//	uvarint Meta 的 key 的数量, 然后是每个 key:
+//	    uvarint 长度 + key
+//	    uvarint 值的数量, 然后是每个值: uvarint 长度 + 值
+//
+// 版本 1 和 2 是 ServiceName, MethodName 和 Meta 的每一对 key value, 每一项以 '\n' 结尾,
+// key 和 value 之间用 '\r' 分隔, 一个 key 有多个值的时候重复多行, 所以内容里面不能有这两个字符,
+// 有的话编码的时候返回 ErrDelimiter.
+// Meta 的 key 都按照字典序排列. 变长部分之后是消息体, 也就是序列化之后的 Data.
+//
+// Compresser 是压缩算法, 0 表示不压缩. 版本 2 开始请求的 Compresser 是客户端支持的算法,
+// 消息体是否压缩看 FlagCompressed, 所以请求没有压缩的时候服务端也可以压缩响应.
+// 版本 1 没有 Flags, Compresser 不为 0 就表示消息体压缩过. CRC32 是压缩之后的消息体的.
+//
+// 服务端按照请求的版本回复, 不支持的版本回复 VersionError 的错误信息.
+package message
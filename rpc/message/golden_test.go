@@ -0,0 +1,238 @@
+package message
+
+import (
+	"errors"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// go test ./rpc/message -run Golden -update 重新生成 testdata 下面的文件
+// 只有有意修改协议的时候才可以更新, 旧版本的文件不能改, 新版本加新的文件
+var update = flag.Bool("update", false, "更新 golden 文件")
+
+func TestRequestGolden(t *testing.T) {
+	testCases := []struct {
+		name string
+		req  *Request
+	}{
+		{
+			name: "request_v1",
+			req: &Request{
+				RequestID:   123,
+				Version:     Version1,
+				Compresser:  1,
+				Serializer:  2,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+				Meta:        Meta{"trace-id": {"123456"}, "deadline": {"1700000000000"}},
+				Data:        []byte(`{"Id":123}`),
+			},
+		},
+		{
+			name: "request_v2",
+			req: &Request{
+				RequestID:   123,
+				Version:     Version2,
+				Compresser:  1,
+				Serializer:  2,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+				Meta:        Meta{"trace-id": {"123456"}, "deadline": {"1700000000000"}},
+				Data:        []byte(`{"Id":123}`),
+			},
+		},
+		{
+			name: "request_v3",
+			req: &Request{
+				RequestID:   123,
+				Version:     Version3,
+				Compresser:  1,
+				Serializer:  2,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+				Meta: Meta{
+					"trace-id": {"123456"},
+					"baggage":  {"{\"tenant\":\"a\nb\"}"},
+					"accept":   {"json", "proto"},
+				},
+				Data: []byte(`{"Id":123}`),
+			},
+		},
+		{
+			name: "request_v2_checksum",
+			req: &Request{
+				RequestID:   123,
+				Version:     Version2,
+				Flags:       FlagChecksum,
+				Serializer:  2,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+				Data:        []byte(`{"Id":123}`),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.CalculateHeaderLength()
+			tc.req.CalculateBodyLength()
+			data, err := EncodeReq(tc.req)
+			require.NoError(t, err)
+			golden := readGolden(t, tc.name, data)
+			assert.Equal(t, golden, data)
+			req, err := DecodeReq(golden)
+			require.NoError(t, err)
+			assert.Equal(t, tc.req, req)
+		})
+	}
+}
+
+func TestResponseGolden(t *testing.T) {
+	testCases := []struct {
+		name string
+		resp *Response
+	}{
+		{
+			name: "response_v1",
+			resp: &Response{
+				RequestID:  123,
+				Version:    Version1,
+				Serializer: 2,
+				Error:      []byte("mock error"),
+				Data:       []byte(`{"Msg":"hello"}`),
+			},
+		},
+		{
+			name: "response_v2",
+			resp: &Response{
+				RequestID:  123,
+				Version:    Version2,
+				Serializer: 2,
+				Error:      []byte("mock error"),
+				Data:       []byte(`{"Msg":"hello"}`),
+			},
+		},
+		{
+			name: "response_v3",
+			resp: &Response{
+				RequestID:  123,
+				Version:    Version3,
+				Serializer: 2,
+				Error:      []byte("mock error"),
+				Data:       []byte(`{"Msg":"hello"}`),
+			},
+		},
+		{
+			name: "response_v2_checksum",
+			resp: &Response{
+				RequestID:  123,
+				Version:    Version2,
+				Flags:      FlagChecksum,
+				Serializer: 2,
+				Data:       []byte(`{"Msg":"hello"}`),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.resp.CalculateHeaderLength()
+			tc.resp.CalculateBodyLength()
+			data, err := EncodeResp(tc.resp)
+			require.NoError(t, err)
+			golden := readGolden(t, tc.name, data)
+			assert.Equal(t, golden, data)
+			resp, err := DecodeResp(golden)
+			require.NoError(t, err)
+			assert.Equal(t, tc.resp, resp)
+		})
+	}
+}
+
+func TestDecodeReq_Error(t *testing.T) {
+	valid := func() []byte {
+		req := &Request{
+			Version:     Version2,
+			Flags:       FlagChecksum,
+			ServiceName: "user-service",
+			MethodName:  "GetById",
+			Data:        []byte("hello"),
+		}
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		data, err := EncodeReq(req)
+		require.NoError(t, err)
+		return data
+	}
+	testCases := []struct {
+		name string
+		data func() []byte
+
+		wantErr error
+	}{
+		{
+			name: "not rpc",
+			data: func() []byte {
+				return []byte("GET / HTTP/1.1\r\n\r\n")
+			},
+			wantErr: ErrInvalidMagic,
+		},
+		{
+			name: "unsupported version",
+			data: func() []byte {
+				data := valid()
+				data[2] = 9
+				return data
+			},
+			wantErr: &VersionError{Version: 9},
+		},
+		{
+			name: "checksum",
+			data: func() []byte {
+				data := valid()
+				data[len(data)-1] = 'x'
+				return data
+			},
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "truncated",
+			data: func() []byte {
+				data := valid()
+				return data[:len(data)-1]
+			},
+			wantErr: ErrMalformed,
+		},
+		{
+			name: "short",
+			data: func() []byte {
+				return Magic[:]
+			},
+			wantErr: ErrMalformed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeReq(tc.data())
+			var ve *VersionError
+			if errors.As(tc.wantErr, &ve) {
+				assert.Equal(t, tc.wantErr, err)
+				return
+			}
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func readGolden(t *testing.T, name string, data []byte) []byte {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+	golden, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return golden
+}
@@ -0,0 +1,34 @@
+package message
+
+import "sort"
+
+// Meta 请求的元数据, 和 grpc 的 metadata.MD 一样, 一个 key 可以有多个值
+type Meta map[string][]string
+
+// Get 第一个值, 没有的时候返回空字符串
+func (m Meta) Get(key string) string {
+	if vals := m[key]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// Set 覆盖 key 原来的值
+func (m Meta) Set(key string, vals ...string) {
+	m[key] = vals
+}
+
+// Append 在 key 原来的值后面追加
+func (m Meta) Append(key string, vals ...string) {
+	m[key] = append(m[key], vals...)
+}
+
+// keys 按照字典序排列, 保证同样的请求编码出来是一样的
+func (m Meta) keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
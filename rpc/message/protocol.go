@@ -0,0 +1,198 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	Version1 uint8 = 1
+	Version2 uint8 = 2
+	// Version3 服务名, 方法名和 Meta 使用 uvarint 长度前缀, 不再使用分隔符
+	Version3 uint8 = 3
+	// CurrentVersion 客户端默认使用的版本
+	CurrentVersion = Version3
+
+	// FlagChecksum 头部带有消息体的 CRC32
+	FlagChecksum uint8 = 1 << 0
+	// FlagCompressed 消息体用 Compresser 压缩过. 没有这个 Flag 的时候 Compresser 表示客户端支持的压缩算法
+	FlagCompressed uint8 = 1 << 1
+
+	// PrefixLength 先读这么多字节就能知道消息的长度, 比任何版本的最短消息都短
+	PrefixLength = 12
+
+	// MaxV1HeadLength 版本 1 靠前两个字节是 0 来识别, 所以头部不能超过 64KB
+	MaxV1HeadLength = 1<<16 - 1
+
+	v1FixedLength  = 15
+	v2FixedLength  = 18
+	checksumLength = 4
+)
+
+// Magic 版本 2 开始的消息都以这两个字节开头
+var Magic = [2]byte{0x6d, 0x63}
+
+var (
+	ErrInvalidMagic = errors.New("micro: 魔数不对, 不是 rpc 协议的数据")
+	ErrChecksum     = errors.New("micro: 消息体校验和不一致")
+	ErrMalformed    = errors.New("micro: 消息格式错误")
+	// ErrHeadTooLarge 版本 1 的头部超过了 MaxV1HeadLength, 要用版本 2 以上
+	ErrHeadTooLarge = errors.New("micro: 版本 1 的头部不能超过 64KB")
+	// ErrDelimiter 版本 1 和 2 的服务名, 方法名和 Meta 里面有分隔符, 要用版本 3
+	ErrDelimiter = errors.New("micro: 版本 1 和 2 的服务名, 方法名和 Meta 里面不能有 \\n 或者 \\r")
+)
+
+// VersionError 对端使用了不支持的协议版本
+type VersionError struct {
+	Version uint8
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("micro: 不支持协议版本 %d, 支持的版本 %d-%d", e.Version, Version1, CurrentVersion)
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Lengths 从消息的前 PrefixLength 个字节里面解析出头部长度和消息体长度
+func Lengths(prefix []byte) (headLength, bodyLength uint32, err error) {
+	if len(prefix) < PrefixLength {
+		return 0, 0, ErrMalformed
+	}
+	var minLength uint32
+	switch {
+	case prefix[0] == Magic[0] && prefix[1] == Magic[1]:
+		headLength = binary.BigEndian.Uint32(prefix[4:8])
+		bodyLength = binary.BigEndian.Uint32(prefix[8:12])
+		minLength = v2FixedLength
+	case prefix[0] == 0 && prefix[1] == 0:
+		headLength = binary.BigEndian.Uint32(prefix[:4])
+		bodyLength = binary.BigEndian.Uint32(prefix[4:8])
+		minLength = v1FixedLength
+	default:
+		return 0, 0, ErrInvalidMagic
+	}
+	if headLength < minLength {
+		return 0, 0, fmt.Errorf("%w: 头部长度 %d 小于 %d", ErrMalformed, headLength, minLength)
+	}
+	return headLength, bodyLength, nil
+}
+
+// FrameRequestID 版本 2 开始 RequestID 的位置是固定的, 收到不支持的版本时用来回复错误
+func FrameRequestID(data []byte) uint32 {
+	if len(data) < 16 || data[0] != Magic[0] || data[1] != Magic[1] {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data[12:16])
+}
+
+// delimited 版本 1 和 2 的变长部分用 \n 和 \r 分隔, 内容里面不能有这两个字符
+func delimited(version uint8) bool {
+	return version < Version3
+}
+
+// compressed 版本 1 没有 Flags, Compresser 不为 0 就是压缩过
+func compressed(version, flags, compresser uint8) bool {
+	if version == Version1 {
+		return compresser != 0
+	}
+	return compresser != 0 && flags&FlagCompressed != 0
+}
+
+// checkHeadLength 编码之前检查, 不然版本 1 的消息会被对端当成别的版本
+func checkHeadLength(version uint8, headLength uint32) error {
+	if version == Version1 && headLength > MaxV1HeadLength {
+		return fmt.Errorf("%w: 头部长度 %d", ErrHeadTooLarge, headLength)
+	}
+	return nil
+}
+
+// fixedLength 头部固定部分的长度
+func fixedLength(version, flags uint8) int {
+	if version == Version1 {
+		return v1FixedLength
+	}
+	if flags&FlagChecksum != 0 {
+		return v2FixedLength + checksumLength
+	}
+	return v2FixedLength
+}
+
+// putFixed 写入头部的固定部分, 返回变长部分
+func putFixed(bs []byte, headLength, bodyLength, requestID uint32,
+	version, flags, compresser, serializer uint8, body []byte) []byte {
+	if version == Version1 {
+		binary.BigEndian.PutUint32(bs[:4], headLength)
+		binary.BigEndian.PutUint32(bs[4:8], bodyLength)
+		binary.BigEndian.PutUint32(bs[8:12], requestID)
+		bs[12] = version
+		bs[13] = compresser
+		bs[14] = serializer
+		return bs[v1FixedLength:]
+	}
+	bs[0], bs[1] = Magic[0], Magic[1]
+	bs[2] = version
+	bs[3] = flags
+	binary.BigEndian.PutUint32(bs[4:8], headLength)
+	binary.BigEndian.PutUint32(bs[8:12], bodyLength)
+	binary.BigEndian.PutUint32(bs[12:16], requestID)
+	bs[16] = compresser
+	bs[17] = serializer
+	if flags&FlagChecksum != 0 {
+		binary.BigEndian.PutUint32(bs[18:22], crc32.Checksum(body, crcTable))
+		return bs[v2FixedLength+checksumLength:]
+	}
+	return bs[v2FixedLength:]
+}
+
+// fixed 头部的固定部分
+type fixed struct {
+	headLength uint32
+	bodyLength uint32
+	requestID  uint32
+	version    uint8
+	flags      uint8
+	compresser uint8
+	serializer uint8
+}
+
+// parseFixed 解析并校验头部的固定部分, 返回变长部分和消息体
+func parseFixed(data []byte) (f fixed, header []byte, body []byte, err error) {
+	if len(data) < PrefixLength {
+		return f, nil, nil, ErrMalformed
+	}
+	f.headLength, f.bodyLength, err = Lengths(data)
+	if err != nil {
+		return f, nil, nil, err
+	}
+	if uint64(len(data)) != uint64(f.headLength)+uint64(f.bodyLength) {
+		return f, nil, nil, fmt.Errorf("%w: 长度 %d 和头部记录的 %d+%d 不一致",
+			ErrMalformed, len(data), f.headLength, f.bodyLength)
+	}
+	body = data[f.headLength:]
+	if data[0] == 0 {
+		f.requestID = binary.BigEndian.Uint32(data[8:12])
+		// 旧的客户端不会设置版本
+		f.version = Version1
+		f.compresser = data[13]
+		f.serializer = data[14]
+		return f, data[v1FixedLength:f.headLength], body, nil
+	}
+	f.version = data[2]
+	if f.version != Version2 && f.version != Version3 {
+		return f, nil, nil, &VersionError{Version: f.version}
+	}
+	f.flags = data[3]
+	f.requestID = binary.BigEndian.Uint32(data[12:16])
+	f.compresser = data[16]
+	f.serializer = data[17]
+	n := fixedLength(f.version, f.flags)
+	if int(f.headLength) < n {
+		return f, nil, nil, ErrMalformed
+	}
+	if f.flags&FlagChecksum != 0 && binary.BigEndian.Uint32(data[18:22]) != crc32.Checksum(body, crcTable) {
+		return f, nil, nil, ErrChecksum
+	}
+	return f, data[n:f.headLength], body, nil
+}
@@ -3,6 +3,8 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"strings"
 )
 
 type Request struct {
@@ -10,30 +12,87 @@ type Request struct {
 	BodyLength uint32
 	RequestID uint32
 	Version uint8
+	// Flags 例如 FlagChecksum, 版本 1 没有这个字段
+	Flags uint8
 	Compresser uint8
 	Serializer uint8
 
 	ServiceName string
 	MethodName string
-	Meta map[string]string
+	Meta Meta
 
 	Data []byte
 }
 
-func EncodeReq(req *Request) []byte {
+// EncodeReq 按照 req.Version 编码, 调用之前要先算好 HeadLength 和 BodyLength
+// 这个版本编码不了的时候返回 error, 例如版本 1 的头部超过了 MaxV1HeadLength
+// 或者版本 1 和 2 的内容里面有分隔符
+func EncodeReq(req *Request) ([]byte, error) {
+	if err := checkHeadLength(req.Version, req.HeadLength); err != nil {
+		return nil, err
+	}
+	if delimited(req.Version) {
+		if err := checkDelimited(req); err != nil {
+			return nil, err
+		}
+	}
 	bs := make([]byte, req.HeadLength + req.BodyLength)
-	
-	// 1. 写入头部长度
-	binary.BigEndian.PutUint32(bs[:4], req.HeadLength)
-	// 2. 写入 body 长度
-	binary.BigEndian.PutUint32(bs[4:8], req.BodyLength)
-	// 3. 写入 Request ID
-	binary.BigEndian.PutUint32(bs[8:12], req.RequestID)
-	bs[12] = req.Version
-	bs[13] = req.Compresser
-	bs[14] = req.Serializer
-	cur := bs[15:]
-	
+	cur := putFixed(bs, req.HeadLength, req.BodyLength, req.RequestID,
+		req.Version, req.Flags, req.Compresser, req.Serializer, req.Data)
+	if delimited(req.Version) {
+		cur = putDelimited(cur, req)
+	} else {
+		cur = putVarint(cur, req)
+	}
+	// 写入请求体
+	copy(cur, req.Data)
+	return bs, nil
+}
+
+// putVarint 版本 3 开始每一项前面是 uvarint 编码的长度, 内容可以是任意字节
+func putVarint(cur []byte, req *Request) []byte {
+	cur = putString(cur, req.ServiceName)
+	cur = putString(cur, req.MethodName)
+	cur = cur[binary.PutUvarint(cur, uint64(len(req.Meta))):]
+	for _, key := range req.Meta.keys() {
+		vals := req.Meta[key]
+		cur = putString(cur, key)
+		cur = cur[binary.PutUvarint(cur, uint64(len(vals))):]
+		for _, val := range vals {
+			cur = putString(cur, val)
+		}
+	}
+	return cur
+}
+
+func putString(cur []byte, s string) []byte {
+	cur = cur[binary.PutUvarint(cur, uint64(len(s))):]
+	return cur[copy(cur, s):]
+}
+
+// checkDelimited 内容里面有分隔符的话对端会解析错, 只能拒绝
+func checkDelimited(req *Request) error {
+	if strings.ContainsAny(req.ServiceName, "\r\n") {
+		return fmt.Errorf("%w: 服务名 %q", ErrDelimiter, req.ServiceName)
+	}
+	if strings.ContainsAny(req.MethodName, "\r\n") {
+		return fmt.Errorf("%w: 方法名 %q", ErrDelimiter, req.MethodName)
+	}
+	for key, vals := range req.Meta {
+		if strings.ContainsAny(key, "\r\n") {
+			return fmt.Errorf("%w: Meta 的 key %q", ErrDelimiter, key)
+		}
+		for _, val := range vals {
+			if strings.ContainsAny(val, "\r\n") {
+				return fmt.Errorf("%w: Meta %s 的值 %q", ErrDelimiter, key, val)
+			}
+		}
+	}
+	return nil
+}
+
+// putDelimited 版本 1 和 2 用 \n 和 \r 分隔, 一个 key 有多个值的时候写多行
+func putDelimited(cur []byte, req *Request) []byte {
 	copy(cur, req.ServiceName)
 	cur = cur[len(req.ServiceName):]
 	cur[0] = '\n'
@@ -44,76 +103,195 @@ func EncodeReq(req *Request) []byte {
 	cur = cur[1:]
 	
 	// 写入 meta
-	for key, val := range req.Meta {
-		copy(cur, key)
-		cur = cur[len(key):]
-		cur[0] = '\r'
-		cur = cur[1:]
-		copy(cur, val)
-		cur = cur[len(val):]
-		cur[0] = '\n'
-		cur = cur[1:]
+	for _, key := range req.Meta.keys() {
+		for _, val := range req.Meta[key] {
+			copy(cur, key)
+			cur = cur[len(key):]
+			cur[0] = '\r'
+			cur = cur[1:]
+			copy(cur, val)
+			cur = cur[len(val):]
+			cur[0] = '\n'
+			cur = cur[1:]
+		}
 	}
-	
-	// 写入请求体
-	copy(cur, req.Data)
-	return bs
-}
-
-func DecodeReq(data []byte) *Request {
-	req := &Request{}
-	req.HeadLength = binary.BigEndian.Uint32(data[:4])
-	req.BodyLength = binary.BigEndian.Uint32(data[4:8])
-	req.RequestID = binary.BigEndian.Uint32(data[8:12])
-	req.Version = data[12]
-	req.Compresser = data[13]
-	req.Serializer = data[14]
-	header := data[15:req.HeadLength]
+	return cur
+}
+
+// DecodeReq 数据是对端发过来的, 格式不对的时候返回 error, 不会 panic
+func DecodeReq(data []byte) (*Request, error) {
+	f, header, body, err := parseFixed(data)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		HeadLength: f.headLength,
+		BodyLength: f.bodyLength,
+		RequestID: f.requestID,
+		Version: f.version,
+		Flags: f.flags,
+		Compresser: f.compresser,
+		Serializer: f.serializer,
+	}
+	if delimited(req.Version) {
+		err = decodeDelimited(header, req)
+	} else {
+		err = decodeVarint(header, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.BodyLength != 0 {
+		req.Data = body
+	}
+	return req, nil
+}
+
+func decodeVarint(header []byte, req *Request) error {
+	var err error
+	if req.ServiceName, header, err = readString(header); err != nil {
+		return err
+	}
+	if req.MethodName, header, err = readString(header); err != nil {
+		return err
+	}
+	cnt, header, err := readUvarint(header)
+	if err != nil {
+		return err
+	}
+	if cnt > 0 {
+		req.Meta = make(Meta, min(cnt, uint64(len(header))))
+	}
+	for i := uint64(0); i < cnt; i++ {
+		var key string
+		if key, header, err = readString(header); err != nil {
+			return err
+		}
+		var n uint64
+		if n, header, err = readUvarint(header); err != nil {
+			return err
+		}
+		// 每个值至少有一个字节的长度, n 不可能比剩下的字节数还多
+		if n > uint64(len(header)) {
+			return ErrMalformed
+		}
+		vals := req.Meta[key]
+		for j := uint64(0); j < n; j++ {
+			var val string
+			if val, header, err = readString(header); err != nil {
+				return err
+			}
+			vals = append(vals, val)
+		}
+		req.Meta[key] = vals
+	}
+	if len(header) > 0 {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func readUvarint(bs []byte) (uint64, []byte, error) {
+	x, n := binary.Uvarint(bs)
+	if n <= 0 {
+		return 0, nil, ErrMalformed
+	}
+	return x, bs[n:], nil
+}
+
+func readString(bs []byte) (string, []byte, error) {
+	l, bs, err := readUvarint(bs)
+	if err != nil {
+		return "", nil, err
+	}
+	if l > uint64(len(bs)) {
+		return "", nil, ErrMalformed
+	}
+	return string(bs[:l]), bs[l:], nil
+}
+
+func decodeDelimited(header []byte, req *Request) error {
 	// 按分隔符切割协议中不定长部分
 	index := bytes.IndexByte(header, '\n')
+	if index == -1 {
+		return ErrMalformed
+	}
 	req.ServiceName = string(header[:index])
 	header = header[index+1:]
 	
 	index = bytes.IndexByte(header, '\n')
+	if index == -1 {
+		return ErrMalformed
+	}
 	req.MethodName = string(header[:index])
 	header = header[index+1:]
 	
 	index = bytes.IndexByte(header, '\n')
 	if index != -1 {
-		meta := make(map[string]string, 4)
+		meta := make(Meta, 4)
 		for index != -1 {
 			pair := header[:index]
 			// meta 按照 \r 切割
 			pairIndex := bytes.IndexByte(pair, '\r')
+			if pairIndex == -1 {
+				return ErrMalformed
+			}
 			key := string(pair[:pairIndex])
-			value := string(pair[pairIndex+1:])
-			meta[key] = value
+			meta.Append(key, string(pair[pairIndex+1:]))
 			
 			header = header[index+1:]
 			index = bytes.IndexByte(header, '\n')
 		}
 		req.Meta = meta
 	}
-	if req.BodyLength != 0 {
-		req.Data = data[req.HeadLength:]
+	if len(header) > 0 {
+		return ErrMalformed
 	}
-	return req
+	return nil
 }
 
 func (req *Request) CalculateHeaderLength() {
-	// 不要忘了分隔符
-	headLength := 15 + len(req.ServiceName) + 1 + len(req.MethodName) + 1
-	for key, value := range req.Meta {
-		headLength += len(key)
-		// key 和 value 之间的分隔符
-		headLength ++
-		headLength += len(value)
-		headLength ++
-		// 和下一个 key value 的分隔符
+	headLength := fixedLength(req.Version, req.Flags)
+	if delimited(req.Version) {
+		// 不要忘了分隔符
+		headLength += len(req.ServiceName) + 1 + len(req.MethodName) + 1
+		for key, vals := range req.Meta {
+			for _, val := range vals {
+				// key 和 value 之间的分隔符, 以及和下一个 key value 的分隔符
+				headLength += len(key) + 1 + len(val) + 1
+			}
+		}
+		req.HeadLength = uint32(headLength)
+		return
+	}
+	headLength += stringLength(req.ServiceName) + stringLength(req.MethodName) + uvarintLength(uint64(len(req.Meta)))
+	for key, vals := range req.Meta {
+		headLength += stringLength(key) + uvarintLength(uint64(len(vals)))
+		for _, val := range vals {
+			headLength += stringLength(val)
+		}
 	}
 	req.HeadLength = uint32(headLength)
 }
 
+func stringLength(s string) int {
+	return uvarintLength(uint64(len(s))) + len(s)
+}
+
+func uvarintLength(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
 func (req *Request) CalculateBodyLength() {
 	req.BodyLength = uint32(len(req.Data))
-}
\ No newline at end of file
+}
+
+// Compressed 消息体是否压缩过, 压缩算法是 Compresser
+func (req *Request) Compressed() bool {
+	return compressed(req.Version, req.Flags, req.Compresser)
+}
@@ -2,6 +2,8 @@ package message
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 )
 
@@ -14,14 +16,14 @@ func TestEncodeDecode(t *testing.T) {
 			name: "normal",
 			req: &Request{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				ServiceName: "user-service",
 				MethodName: "GetById",
-				Meta: map[string]string{
-					"trace-id": "123456",
-					"a/b": "a",
+				Meta: Meta{
+					"trace-id": {"123456"},
+					"a/b": {"a"},
 				},
 				Data: []byte("hello, world"),
 			},
@@ -31,7 +33,7 @@ func TestEncodeDecode(t *testing.T) {
 			name: "data with \n ",
 			req: &Request{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				ServiceName: "user-service",
@@ -40,25 +42,41 @@ func TestEncodeDecode(t *testing.T) {
 			},
 		},
 
-		// 你可以禁止开发者（框架使用者）在 meta 里面使用 \n 和 \r，所以不会出现这种情况
-		//{
-		//	name: "data with \n ",
-		//	resp: &Request{
-		//		RequestID: 123,
-		//		Version: 12,
-		//		Compresser: 13,
-		//		Serializer: 14,
-		//		ServiceName: "user-\nservice",
-		//		MethodName: "GetById",
-		//		Data: []byte("hello \n world"),
-		//	},
-		//},
+		// 版本 3 的 meta 和服务名里面可以有 \n 和 \r
+		{
+			name: "version 3 with \n",
+			req: &Request{
+				RequestID: 123,
+				Version: Version3,
+				Compresser: 13,
+				Serializer: 14,
+				ServiceName: "user-\nservice",
+				MethodName: "GetById",
+				Meta: Meta{
+					"baggage": {"{\"a\":\"b\r\nc\"}"},
+					"accept": {"json", "proto"},
+				},
+				Data: []byte("hello \n world"),
+			},
+		},
+		{
+			name: "version 2 multi values",
+			req: &Request{
+				RequestID: 123,
+				Version: Version2,
+				ServiceName: "user-service",
+				MethodName: "GetById",
+				Meta: Meta{
+					"accept": {"json", "proto"},
+				},
+			},
+		},
 
 		{
 			name: "no meta",
 			req: &Request{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				ServiceName: "user-service",
@@ -70,7 +88,35 @@ func TestEncodeDecode(t *testing.T) {
 			name: "no meta with data",
 			req: &Request{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
+				Compresser: 13,
+				Serializer: 14,
+				ServiceName: "user-service",
+				MethodName: "GetById",
+				Data: []byte("hello, world"),
+			},
+		},
+		{
+			name: "version 1",
+			req: &Request{
+				RequestID: 123,
+				Version: Version1,
+				Compresser: 13,
+				Serializer: 14,
+				ServiceName: "user-service",
+				MethodName: "GetById",
+				Meta: Meta{
+					"trace-id": {"123456"},
+				},
+				Data: []byte("hello, world"),
+			},
+		},
+		{
+			name: "checksum",
+			req: &Request{
+				RequestID: 123,
+				Version: Version2,
+				Flags: FlagChecksum,
 				Compresser: 13,
 				Serializer: 14,
 				ServiceName: "user-service",
@@ -84,10 +130,115 @@ func TestEncodeDecode(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.req.CalculateHeaderLength()
 			tc.req.CalculateBodyLength()
-			data := EncodeReq(tc.req)
-			req := DecodeReq(data)
+			data, err := EncodeReq(tc.req)
+			require.NoError(t, err)
+			req, err := DecodeReq(data)
+			require.NoError(t, err)
 			assert.Equal(t, tc.req, req)
 		})
 	}
 }
 
+
+func TestRequest_Compressed(t *testing.T) {
+	testCases := []struct{
+		name string
+		req *Request
+		want bool
+	} {
+		{
+			name: "version 1",
+			req: &Request{Version: Version1, Compresser: 1},
+			want: true,
+		},
+		{
+			name: "version 1 no compresser",
+			req: &Request{Version: Version1},
+		},
+		{
+			// 只表示客户端支持的压缩算法
+			name: "accept",
+			req: &Request{Version: Version3, Compresser: 1},
+		},
+		{
+			name: "compressed",
+			req: &Request{Version: Version3, Flags: FlagCompressed, Compresser: 1},
+			want: true,
+		},
+		{
+			name: "flag without compresser",
+			req: &Request{Version: Version3, Flags: FlagCompressed},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.req.Compressed())
+		})
+	}
+}
+
+func TestEncodeReq_Error(t *testing.T) {
+	large := Meta{"baggage": {strings.Repeat("a", MaxV1HeadLength)}}
+	testCases := []struct {
+		name string
+		req  *Request
+
+		wantErr error
+	}{
+		{
+			name:    "v1 head too large",
+			req:     &Request{Version: Version1, ServiceName: "user-service", MethodName: "GetById", Meta: large},
+			wantErr: ErrHeadTooLarge,
+		},
+		{
+			name: "v2 large head",
+			req:  &Request{Version: Version2, ServiceName: "user-service", MethodName: "GetById", Meta: large},
+		},
+		{
+			name: "v3 large head",
+			req:  &Request{Version: Version3, ServiceName: "user-service", MethodName: "GetById", Meta: large},
+		},
+		{
+			name:    "v1 newline in service name",
+			req:     &Request{Version: Version1, ServiceName: "user\nservice", MethodName: "GetById"},
+			wantErr: ErrDelimiter,
+		},
+		{
+			name:    "v2 newline in method name",
+			req:     &Request{Version: Version2, ServiceName: "user-service", MethodName: "Get\nById"},
+			wantErr: ErrDelimiter,
+		},
+		{
+			name: "v2 carriage return in meta key",
+			req: &Request{Version: Version2, ServiceName: "user-service", MethodName: "GetById",
+				Meta: Meta{"trace\rid": {"123"}}},
+			wantErr: ErrDelimiter,
+		},
+		{
+			name: "v2 newline in meta value",
+			req: &Request{Version: Version2, ServiceName: "user-service", MethodName: "GetById",
+				Meta: Meta{"baggage": {"a\nb"}}},
+			wantErr: ErrDelimiter,
+		},
+		{
+			name: "v3 newline in meta value",
+			req: &Request{Version: Version3, ServiceName: "user-service", MethodName: "GetById",
+				Meta: Meta{"baggage": {"a\r\nb"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.CalculateHeaderLength()
+			tc.req.CalculateBodyLength()
+			data, err := EncodeReq(tc.req)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			req, err := DecodeReq(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.req, req)
+		})
+	}
+}
@@ -1,12 +1,12 @@
 package message
 
-import "encoding/binary"
-
 type Response struct {
 	HeadLength uint32
 	BodyLength uint32
 	RequestID uint32
 	Version uint8
+	// Flags 例如 FlagChecksum, 版本 1 没有这个字段
+	Flags uint8
 	Compresser uint8
 	Serializer uint8
 	Error []byte
@@ -14,52 +14,56 @@ type Response struct {
 	Data []byte
 }
 
-func EncodeResp(resp *Response) []byte {
+// EncodeResp 按照 resp.Version 编码, 调用之前要先算好 HeadLength 和 BodyLength
+// 版本 1 的错误信息太长, 头部超过了 MaxV1HeadLength 的时候返回 error
+func EncodeResp(resp *Response) ([]byte, error) {
+	if err := checkHeadLength(resp.Version, resp.HeadLength); err != nil {
+		return nil, err
+	}
 	bs := make([]byte, resp.HeadLength + resp.BodyLength)
-
-	// 1. 写入头部长度
-	binary.BigEndian.PutUint32(bs[:4], resp.HeadLength)
-	// 2. 写入 body 长度
-	binary.BigEndian.PutUint32(bs[4:8], resp.BodyLength)
-	// 3. 写入 Request ID
-	binary.BigEndian.PutUint32(bs[8:12], resp.RequestID)
-	// 4. 写入 Version
-	bs[12] = resp.Version
-	bs[13] = resp.Compresser
-	bs[14] = resp.Serializer
-	cur := bs[15:]
+	cur := putFixed(bs, resp.HeadLength, resp.BodyLength, resp.RequestID,
+		resp.Version, resp.Flags, resp.Compresser, resp.Serializer, resp.Data)
 
 	copy(cur, resp.Error)
 	cur = cur[len(resp.Error):]
 	copy(cur, resp.Data)
-	return bs
+	return bs, nil
 }
 
-func DecodeResp(data []byte) *Response {
-	resp := &Response{}
-	// 1. 头四个字节是头部长度
-	resp.HeadLength = binary.BigEndian.Uint32(data[:4])
-	// 2. 紧接着，又是四个字节，对应于 body 长度
-	resp.BodyLength = binary.BigEndian.Uint32(data[4:8])
-	// 3. 又是四个字节，对应于 Request ID
-	resp.RequestID = binary.BigEndian.Uint32(data[8:12])
-	resp.Version = data[12]
-	resp.Compresser = data[13]
-	resp.Serializer = data[14]
-	if resp.HeadLength > 15 {
-		resp.Error = data[15:resp.HeadLength]
+// DecodeResp 数据是对端发过来的, 格式不对的时候返回 error, 不会 panic
+func DecodeResp(data []byte) (*Response, error) {
+	f, header, body, err := parseFixed(data)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		HeadLength: f.headLength,
+		BodyLength: f.bodyLength,
+		RequestID: f.requestID,
+		Version: f.version,
+		Flags: f.flags,
+		Compresser: f.compresser,
+		Serializer: f.serializer,
+	}
+	if len(header) > 0 {
+		resp.Error = header
 	}
 
 	if resp.BodyLength != 0 {
-		resp.Data = data[resp.HeadLength:]
+		resp.Data = body
 	}
-	return resp
+	return resp, nil
 }
 
 func (resp *Response) CalculateHeaderLength() {
-	resp.HeadLength = 15 + uint32(len(resp.Error))
+	resp.HeadLength = uint32(fixedLength(resp.Version, resp.Flags) + len(resp.Error))
 }
 
 func (resp *Response) CalculateBodyLength() {
 	resp.BodyLength = uint32(len(resp.Data))
 }
+
+// Compressed 消息体是否压缩过, 压缩算法是 Compresser
+func (resp *Response) Compressed() bool {
+	return compressed(resp.Version, resp.Flags, resp.Compresser)
+}
@@ -2,6 +2,8 @@ package message
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 )
 
@@ -14,7 +16,7 @@ func TestRespEncodeDecode(t *testing.T) {
 			name: "normal",
 			resp: &Response{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				Error: []byte("this is error"),
@@ -25,7 +27,7 @@ func TestRespEncodeDecode(t *testing.T) {
 			name: "no data",
 			resp: &Response{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				Error: []byte("this is error"),
@@ -35,7 +37,7 @@ func TestRespEncodeDecode(t *testing.T) {
 			name: "no error",
 			resp: &Response{
 				RequestID: 123,
-				Version: 12,
+				Version: Version2,
 				Compresser: 13,
 				Serializer: 14,
 				Data: []byte("hello, world"),
@@ -46,12 +48,32 @@ func TestRespEncodeDecode(t *testing.T) {
 		//	name: "data with \n ",
 		//	resp: &Response{
 		//		RequestID: 123,
-		//		Version: 12,
+		//		Version: Version2,
 		//		Compresser: 13,
 		//		Serializer: 14,
 		//		Data: []byte("hello \n world"),
 		//	},
 		//},
+		{
+			name: "version 1",
+			resp: &Response{
+				RequestID: 123,
+				Version: Version1,
+				Compresser: 13,
+				Serializer: 14,
+				Error: []byte("this is error"),
+				Data: []byte("hello, world"),
+			},
+		},
+		{
+			name: "checksum",
+			resp: &Response{
+				RequestID: 123,
+				Version: Version2,
+				Flags: FlagChecksum,
+				Data: []byte("hello, world"),
+			},
+		},
 
 	}
 
@@ -59,9 +81,18 @@ func TestRespEncodeDecode(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.resp.CalculateHeaderLength()
 			tc.resp.CalculateBodyLength()
-			data := EncodeResp(tc.resp)
-			req := DecodeResp(data)
+			data, err := EncodeResp(tc.resp)
+			require.NoError(t, err)
+			req, err := DecodeResp(data)
+			require.NoError(t, err)
 			assert.Equal(t, tc.resp, req)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestEncodeResp_HeadTooLarge(t *testing.T) {
+	resp := &Response{Version: Version1, Error: []byte(strings.Repeat("a", MaxV1HeadLength))}
+	resp.CalculateHeaderLength()
+	_, err := EncodeResp(resp)
+	assert.ErrorIs(t, err, ErrHeadTooLarge)
+}
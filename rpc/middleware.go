@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"context"
+	"micro/rpc/message"
+)
+
+// Middleware 包装 Proxy, 在调用前后做一些事情, 例如统计指标
+// 服务端出错的时候也要把 resp 返回去, 错误信息是写在 resp 里面的
+type Middleware func(next Proxy) Proxy
+
+// ProxyFunc 让普通的方法实现 Proxy
+type ProxyFunc func(ctx context.Context, req *message.Request) (*message.Response, error)
+
+func (f ProxyFunc) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
+	return f(ctx, req)
+}
+
+// chain 第一个 Middleware 在最外层
+func chain(p Proxy, ms []Middleware) Proxy {
+	for i := len(ms) - 1; i >= 0; i-- {
+		p = ms[i](p)
+	}
+	return p
+}
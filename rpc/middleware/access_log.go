@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"micro/observability/logging"
+	"micro/rpc"
+	"micro/rpc/message"
+	"time"
+)
+
+// AccessLog 用于 rpc.ServerWithMiddlewares 和 rpc.ClientWithMiddlewares, 请求 ID 放在 Meta 里面
+func AccessLog(b *logging.AccessLogBuilder) rpc.Middleware {
+	return func(next rpc.Proxy) rpc.Proxy {
+		return rpc.ProxyFunc(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			// Meta 里面的请求 ID 可能是对端传过来的, 不合法的话重新生成
+			id := req.Meta.Get(logging.RequestIDKey)
+			if !logging.ValidRequestID(id) {
+				id = logging.RequestIDFromContext(ctx)
+			}
+			if !logging.ValidRequestID(id) {
+				id = logging.NewRequestID()
+			}
+			if req.Meta == nil {
+				req.Meta = make(message.Meta, 1)
+			}
+			if req.Meta.Get(logging.RequestIDKey) != id {
+				req.Meta.Set(logging.RequestIDKey, id)
+				// Meta 变了, 头部长度要重新算
+				req.CalculateHeaderLength()
+			}
+			ctx = logging.ContextWithRequestID(ctx, id)
+			startTime := time.Now()
+			resp, err := next.Invoke(ctx, req)
+			if err == nil && resp != nil && len(resp.Error) > 0 {
+				err = status.Error(codes.Unknown, string(resp.Error))
+			}
+			b.Record(ctx, "rpc 请求", "/"+req.ServiceName+"/"+req.MethodName, id, startTime, err)
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"micro/rpc"
+	"micro/rpc/message"
+)
+
+// CallMetrics 记录一次调用的指标, 返回的方法在调用结束的时候调用
+// opentelemetry.ServerMetricsBuilder 和 opentelemetry.ClientMetricsBuilder 都实现了这个接口
+type CallMetrics interface {
+	StartCall(ctx context.Context, service, method string, requestSize int) func(responseSize int, failed bool)
+}
+
+// Metrics 用于 rpc.ServerWithMiddlewares 和 rpc.ClientWithMiddlewares
+// 大小是序列化之后的大小, 服务端写在 resp 里面的错误也算出错
+func Metrics(m CallMetrics) rpc.Middleware {
+	return func(next rpc.Proxy) rpc.Proxy {
+		return rpc.ProxyFunc(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			end := m.StartCall(ctx, req.ServiceName, req.MethodName, len(req.Data))
+			resp, err := next.Invoke(ctx, req)
+			size := -1
+			if resp != nil {
+				size = len(resp.Data)
+			}
+			end(size, err != nil || (resp != nil && len(resp.Error) > 0))
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"micro/observability/logging"
+	"micro/observability/opentelemetry"
+	"micro/rpc"
+	"micro/rpc/message"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	sm, cm := &memMetrics{}, &memMetrics{}
+	us := startUserService(t, rpc.ServerWithMiddlewares(Metrics(sm)), rpc.ClientWithMiddlewares(Metrics(cm)))
+	resp, err := us.GetById(context.Background(), &rpc.GetByIdReq{Id: 123})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", resp.Msg)
+
+	for _, m := range []*memMetrics{sm, cm} {
+		require.Len(t, m.calls, 1)
+		c := m.calls[0]
+		assert.Equal(t, "user-service", c.service)
+		assert.Equal(t, "GetById", c.method)
+		assert.Greater(t, c.requestSize, 0)
+		assert.Greater(t, c.responseSize, 0)
+		assert.False(t, c.failed)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	sl, cl := &memLogger{}, &memLogger{}
+	us := startUserService(t,
+		rpc.ServerWithMiddlewares(AccessLog(&logging.AccessLogBuilder{Logger: sl})),
+		rpc.ClientWithMiddlewares(AccessLog(&logging.AccessLogBuilder{Logger: cl})))
+	ctx := logging.ContextWithRequestID(context.Background(), "req-1")
+	_, err := us.GetById(ctx, &rpc.GetByIdReq{Id: 123})
+	require.NoError(t, err)
+
+	// 客户端的请求 ID 通过 Meta 传给服务端
+	for _, l := range []*memLogger{sl, cl} {
+		require.Len(t, l.records, 1)
+		assert.Equal(t, "/user-service/GetById", l.records[0]["method"])
+		assert.Equal(t, "req-1", l.records[0]["request_id"])
+	}
+}
+
+func TestAccessLog_InvalidRequestID(t *testing.T) {
+	l := &memLogger{}
+	var gotID string
+	proxy := AccessLog(&logging.AccessLogBuilder{Logger: l})(rpc.ProxyFunc(
+		func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			gotID = logging.RequestIDFromContext(ctx)
+			return &message.Response{}, nil
+		}))
+	req := &message.Request{ServiceName: "user-service", MethodName: "GetById",
+		Meta: message.Meta{logging.RequestIDKey: {"req-1\nlevel=ERROR"}}}
+	_, err := proxy.Invoke(context.Background(), req)
+	require.NoError(t, err)
+
+	// 对端传过来的不合法, 重新生成一个
+	assert.Len(t, gotID, 32)
+	assert.Equal(t, gotID, req.Meta.Get(logging.RequestIDKey))
+	require.Len(t, l.records, 1)
+	assert.Equal(t, gotID, l.records[0]["request_id"])
+}
+
+func startUserService(t *testing.T, so rpc.ServerOption, co rpc.ClientOption) *rpc.UserService {
+	// rpc.Server 不会告诉我们监听的端口, 先占一个
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	server := rpc.NewServer(so)
+	server.RegisterServer(&rpc.UserServiceServer{Msg: "hello"})
+	go func() {
+		_ = server.Start("tcp", addr)
+	}()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	client, err := rpc.NewClient(addr, co)
+	require.NoError(t, err)
+	us := &rpc.UserService{}
+	require.NoError(t, client.InitService(us))
+	return us
+}
+
+var (
+	_ CallMetrics = (*opentelemetry.ServerMetricsBuilder)(nil)
+	_ CallMetrics = (*opentelemetry.ClientMetricsBuilder)(nil)
+)
+
+type call struct {
+	service      string
+	method       string
+	requestSize  int
+	responseSize int
+	failed       bool
+}
+
+type memMetrics struct {
+	mutex sync.Mutex
+	calls []call
+}
+
+func (m *memMetrics) StartCall(ctx context.Context, service, method string, requestSize int) func(responseSize int, failed bool) {
+	return func(responseSize int, failed bool) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.calls = append(m.calls, call{service: service, method: method,
+			requestSize: requestSize, responseSize: responseSize, failed: failed})
+	}
+}
+
+type memLogger struct {
+	mutex   sync.Mutex
+	records []map[string]string
+}
+
+func (m *memLogger) log(args []any) {
+	attrs := make(map[string]string, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records = append(m.records, attrs)
+}
+
+func (m *memLogger) Debug(msg string, args ...any) { m.log(args) }
+
+func (m *memLogger) Info(msg string, args ...any) { m.log(args) }
+
+func (m *memLogger) Warn(msg string, args ...any) { m.log(args) }
+
+func (m *memLogger) Error(msg string, args ...any) { m.log(args) }
@@ -3,7 +3,8 @@ package rpc
 import (
 	"context"
 	"errors"
-	"log"
+	"micro/observability"
+	"micro/rpc/compress"
 	"micro/rpc/message"
 	"micro/rpc/serialize"
 	"micro/rpc/serialize/json"
@@ -13,20 +14,87 @@ import (
 	"time"
 )
 
+type ServerOption func(server *Server)
+
 type Server struct {
 	services map[string]reflectionStub
 	serializers map[uint8]serialize.Serializer
+	compressors map[uint8]compress.Compressor
+	// 响应体不小于 compressThreshold 的时候用客户端的压缩算法压缩
+	compressThreshold int
+	middlewares []Middleware
+	// 经过 Middleware 之后的 Invoke
+	proxy Proxy
+	// 请求的头部和请求体的大小上限
+	maxHeader uint32
+	maxBody uint32
+	// 每个连接上同时处理的请求数
+	maxStreams int
+	logger observability.Logger
 }
 
-func NewServer() *Server {
+// DefaultMaxConcurrentStreams 和 http2 常用的默认值一样
+const DefaultMaxConcurrentStreams = 100
+
+func NewServer(opts ...ServerOption) *Server {
 	 res := &Server{
 		services: make(map[string]reflectionStub, 8),
 		serializers: make(map[uint8]serialize.Serializer, 4),
+		compressors: make(map[uint8]compress.Compressor, 4),
+		compressThreshold: compress.DefaultThreshold,
+		maxHeader: DefaultMaxHeaderSize,
+		maxBody: DefaultMaxBodySize,
+		maxStreams: DefaultMaxConcurrentStreams,
+		logger: observability.LoggerOrDefault(nil),
 	}
 	res.RegisterSerializer(&json.Serializer{})
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.proxy = chain(ProxyFunc(res.Invoke), res.middlewares)
 	return res
 }
 
+// ServerWithLogger 默认使用 slog.Default()
+func ServerWithLogger(l observability.Logger) ServerOption {
+	return func(server *Server) {
+		server.logger = observability.LoggerOrDefault(l)
+	}
+}
+
+// ServerWithMiddlewares 连接上收到的请求会经过这些 Middleware
+func ServerWithMiddlewares(ms ...Middleware) ServerOption {
+	return func(server *Server) {
+		server.middlewares = append(server.middlewares, ms...)
+	}
+}
+
+// ServerWithMaxFrameSize 请求的头部或者请求体超过上限的时候关闭连接, 默认 DefaultMaxHeaderSize 和 DefaultMaxBodySize
+// 版本 1 的头部不管这里怎么设置都不能超过 message.MaxV1HeadLength
+func ServerWithMaxFrameSize(maxHeader, maxBody uint32) ServerOption {
+	return func(server *Server) {
+		server.maxHeader = maxHeader
+		server.maxBody = maxBody
+	}
+}
+
+// ServerWithMaxConcurrentStreams 每个连接上同时处理的请求数上限, 默认 DefaultMaxConcurrentStreams
+// 达到上限之后暂停读这个连接, 客户端的写会被 TCP 的流量控制挡住
+func ServerWithMaxConcurrentStreams(n int) ServerOption {
+	return func(server *Server) {
+		if n > 0 {
+			server.maxStreams = n
+		}
+	}
+}
+
+// ServerWithCompressThreshold 默认 compress.DefaultThreshold
+func ServerWithCompressThreshold(n int) ServerOption {
+	return func(server *Server) {
+		server.compressThreshold = n
+	}
+}
+
 func (s *Server) RegisterServer(service Service) {
 	s.services[service.Name()] = reflectionStub{
 		s: service,
@@ -39,6 +107,11 @@ func (s *Server) RegisterSerializer(sl serialize.Serializer) {
 	s.serializers[sl.Code()] = sl
 }
 
+// RegisterCompressor 客户端用了没有注册的压缩算法时, 压缩过的请求返回错误
+func (s *Server) RegisterCompressor(c compress.Compressor) {
+	s.compressors[c.Code()] = c
+}
+
 func (s *Server) Start(network, addr string) error {
 	lis, err := net.Listen(network, addr)
 	if err != nil {
@@ -57,47 +130,136 @@ func (s *Server) Start(network, addr string) error {
 	}
 }
 
+// handleConn 一个协程读请求, 每个请求在单独的协程里面处理, 响应按照处理完的顺序写回
+// 客户端按照 RequestID 找到对应的调用. 同时处理的请求数达到上限之后等前面的请求处理完再读
 func (s *Server) handleConn(conn net.Conn) error {
+	fc := newFrameConn(conn, s.maxHeader, s.maxBody)
+	defer fc.release()
+	streams := make(chan struct{}, s.maxStreams)
 	for {
-		reqBs, err := ReadMsg(conn)
+		reqBs, err := fc.ReadMsg()
+		var pe *ProtocolError
+		if errors.As(err, &pe) {
+			s.logger.Warn("rpc 协议错误, 关闭连接", "remote", conn.RemoteAddr(), "error", err)
+		}
 		if err != nil {
 			return err
 		}
 		// 还原请求信息
-		req := message.DecodeReq(reqBs)
-		
-		ctx := context.Background()
-		cancel := func() {}
-		log.Println(req.Meta)
-		if deadlineStr, ok := req.Meta["deadline"]; ok {
-			log.Println(deadlineStr)
-			deadline, er := strconv.ParseInt(deadlineStr, 10, 64)
-			if er == nil {
-				ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
+		req, err := message.DecodeReq(reqBs)
+		var ve *message.VersionError
+		if errors.As(err, &ve) {
+			// 版本不对的时候消息的长度还是对的, 回复错误之后可以继续处理
+			if err = fc.WriteMsg(versionErrorResp(reqBs, ve), time.Time{}); err != nil {
+				return err
 			}
+			continue
 		}
-		oneway, ok := req.Meta["oneway"]
-		if ok && oneway == "true" {
-			ctx = CtxWithOneway(ctx)
+		if err != nil {
+			s.logger.Warn("rpc 协议错误, 关闭连接", "remote", conn.RemoteAddr(), "error", err)
+			return err
 		}
+		streams <- struct{}{}
+		go func() {
+			defer func() {
+				<-streams
+			}()
+			resp := s.handleReq(req)
+			if resp == nil {
+				return
+			}
+			if er := fc.WriteMsg(s.encodeResp(resp), time.Time{}); er != nil {
+				// 读协程会因为连接关闭而退出
+				_ = conn.Close()
+			}
+		}()
+	}
+}
 
-		resp, err := s.Invoke(ctx, req)
-		// 服务链路调用结束, 结束 ctx
-		cancel()
-		if err != nil {
-			// 处理业务 error
-			resp.Error = []byte(err.Error())
+// handleReq oneway 请求不需要响应, 返回 nil
+func (s *Server) handleReq(req *message.Request) *message.Response {
+	ctx := context.Background()
+	cancel := func() {}
+	s.logger.Debug("rpc 收到请求", "service", req.ServiceName, "method", req.MethodName,
+		"request_id", req.RequestID, "meta", req.Meta)
+	if deadlineStr := req.Meta.Get("deadline"); deadlineStr != "" {
+		deadline, er := strconv.ParseInt(deadlineStr, 10, 64)
+		if er == nil {
+			ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
 		}
-		
-		// 设置好 response 
-		resp.CalculateHeaderLength()
-		resp.CalculateBodyLength()
-		
-		_, err = conn.Write(message.EncodeResp(resp))
-		if err != nil {
-			return err
+	}
+	if req.Meta.Get("oneway") == "true" {
+		ctx = CtxWithOneway(ctx)
+	}
+
+	var resp *message.Response
+	compressor, err := s.decompressReq(req)
+	if err == nil {
+		resp, err = s.proxy.Invoke(ctx, req)
+	}
+	// 服务链路调用结束, 结束 ctx
+	cancel()
+	if isOneway(ctx) {
+		return nil
+	}
+	if resp == nil {
+		// Middleware 可能直接返回 error
+		resp = &message.Response{
+			Compresser: req.Compresser,
+			Serializer: req.Serializer,
 		}
 	}
+	// 客户端靠 RequestID 找到对应的调用, 按照请求的版本回复
+	resp.RequestID = req.RequestID
+	resp.Version = req.Version
+	resp.Flags = req.Flags
+	if err != nil {
+		// 处理业务 error
+		resp.Error = []byte(err.Error())
+	}
+	if er := s.compressResp(resp, compressor); er != nil {
+		// 压缩失败就不压缩
+		s.logger.Warn("rpc 压缩响应失败", "compresser", compressor.Code(), "error", er)
+	}
+
+	// 设置好 response
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+// encodeResp 编码失败的时候, 例如版本 1 的错误信息太长, 把编码的错误回复给客户端
+func (s *Server) encodeResp(resp *message.Response) []byte {
+	bs, err := message.EncodeResp(resp)
+	if err == nil {
+		return bs
+	}
+	s.logger.Warn("rpc 编码响应失败", "request_id", resp.RequestID, "error", err)
+	resp = &message.Response{
+		RequestID: resp.RequestID,
+		Version: resp.Version,
+		Serializer: resp.Serializer,
+		Error: []byte(err.Error()),
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	// 错误信息很短, 不会再失败了
+	bs, _ = message.EncodeResp(resp)
+	return bs
+}
+
+// versionErrorResp 用服务端的版本回复, 客户端至少能看到错误信息
+func versionErrorResp(reqBs []byte, ve *message.VersionError) []byte {
+	resp := &message.Response{
+		RequestID: message.FrameRequestID(reqBs),
+		Version: message.CurrentVersion,
+		Error: []byte(ve.Error()),
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	// 服务端的版本不是版本 1, 不会失败
+	bs, _ := message.EncodeResp(resp)
+	return bs
 }
 
 func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
@@ -106,6 +268,7 @@ func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Res
 	resp := &message.Response{
 		RequestID: req.RequestID,
 		Version: req.Version,
+		Flags: req.Flags,
 		Compresser: req.Compresser,
 		Serializer: req.Serializer,
 	}
@@ -1,26 +1,135 @@
 package rpc
 
 import (
-	"encoding/binary"
+	"bufio"
+	"fmt"
+	"io"
+	"micro/rpc/message"
 	"net"
+	"sync"
+	"time"
 )
 
 const (
-	headLength = 8
+	// DefaultMaxHeaderSize 头部包含服务名, 方法名和 Meta, 64KB 足够了
+	DefaultMaxHeaderSize = 64 << 10
+	// DefaultMaxBodySize 请求体和响应体的默认上限
+	DefaultMaxBodySize = 16 << 20
 )
 
-func ReadMsg(conn net.Conn) ([]byte, error) {
-	// 协议头和协议体
-	lenBs := make([]byte, headLength)
-	_, err := conn.Read(lenBs)
+// ProtocolError 对端发送的数据不符合协议, 例如长度超过限制
+// 连接上后面的数据已经没法解析了, 只能关闭连接
+type ProtocolError struct {
+	Reason string
+	// Err 解码失败的原因, 例如 message.ErrChecksum, 可以用 errors.Is 判断
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return "micro: 协议错误, " + e.Err.Error()
+	}
+	return "micro: 协议错误, " + e.Reason
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// ReadMsg 读一个完整的消息, 头部和请求体的大小使用默认的上限
+func ReadMsg(r io.Reader) ([]byte, error) {
+	var prefix [message.PrefixLength]byte
+	return readMsg(r, prefix[:], DefaultMaxHeaderSize, DefaultMaxBodySize)
+}
+
+// readMsg 长度字段是对端给的, 不能相信, 超过上限直接拒绝, 避免分配很大的内存
+func readMsg(r io.Reader, prefix []byte, maxHeader, maxBody uint32) ([]byte, error) {
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	headerLength, bodyLength, err := message.Lengths(prefix)
 	if err != nil {
+		return nil, &ProtocolError{Err: err}
+	}
+	if headerLength > maxHeader {
+		return nil, &ProtocolError{Reason: fmt.Sprintf("头部长度 %d 超过上限 %d", headerLength, maxHeader)}
+	}
+	if bodyLength > maxBody {
+		return nil, &ProtocolError{Reason: fmt.Sprintf("请求体长度 %d 超过上限 %d", bodyLength, maxBody)}
+	}
+	data := make([]byte, int(headerLength)+int(bodyLength))
+	copy(data, prefix)
+	if _, err = io.ReadFull(r, data[len(prefix):]); err != nil {
+		if err == io.EOF {
+			// 读到一半对端关闭了
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	headerLength := binary.BigEndian.Uint32(lenBs[:4])
-	bodyLength := binary.BigEndian.Uint32(lenBs[4:])
-	length := headerLength + bodyLength
-	data := make([]byte, length)
-	_, err = conn.Read(data[8:])
-	copy(data[:8], lenBs)
-	return data, err
-}
\ No newline at end of file
+	return data, nil
+}
+
+var (
+	readerPool = sync.Pool{New: func() any {
+		return bufio.NewReaderSize(nil, 4<<10)
+	}}
+	writerPool = sync.Pool{New: func() any {
+		return bufio.NewWriterSize(nil, 4<<10)
+	}}
+)
+
+// frameConn 带缓冲的连接, 只能在一个协程里面读, 可以在多个协程里面写
+// 读协程退出之前调用 release 把缓冲区还回去
+type frameConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	prefix    [message.PrefixLength]byte
+	maxHeader uint32
+	maxBody   uint32
+
+	writeMutex sync.Mutex
+	writer     *bufio.Writer
+}
+
+func newFrameConn(conn net.Conn, maxHeader, maxBody uint32) *frameConn {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(conn)
+	writer := writerPool.Get().(*bufio.Writer)
+	writer.Reset(conn)
+	return &frameConn{
+		conn:      conn,
+		reader:    reader,
+		maxHeader: maxHeader,
+		maxBody:   maxBody,
+		writer:    writer,
+	}
+}
+
+func (f *frameConn) ReadMsg() ([]byte, error) {
+	return readMsg(f.reader, f.prefix[:], f.maxHeader, f.maxBody)
+}
+
+// WriteMsg 一个消息要一次写完, 不然会和其它协程写的消息交错
+// deadline 为零值表示不超时
+func (f *frameConn) WriteMsg(data []byte, deadline time.Time) error {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	if f.writer == nil {
+		return errConnClosed
+	}
+	_ = f.conn.SetWriteDeadline(deadline)
+	if _, err := f.writer.Write(data); err != nil {
+		return err
+	}
+	return f.writer.Flush()
+}
+
+func (f *frameConn) release() {
+	f.reader.Reset(nil)
+	readerPool.Put(f.reader)
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	f.writer.Reset(nil)
+	writerPool.Put(f.writer)
+	f.writer = nil
+}
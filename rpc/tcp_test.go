@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"micro/rpc/message"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadMsg(t *testing.T) {
+	frame := func(headerLength, bodyLength uint32, n int) []byte {
+		bs := make([]byte, n)
+		bs[0], bs[1], bs[2] = message.Magic[0], message.Magic[1], message.Version2
+		binary.BigEndian.PutUint32(bs[4:8], headerLength)
+		binary.BigEndian.PutUint32(bs[8:12], bodyLength)
+		return bs
+	}
+	testCases := []struct {
+		name   string
+		reader io.Reader
+
+		wantLen int
+		wantErr error
+	}{
+		{
+			name:    "one byte at a time",
+			reader:  iotest.OneByteReader(bytes.NewReader(frame(20, 10, 30))),
+			wantLen: 30,
+		},
+		{
+			name:    "eof",
+			reader:  bytes.NewReader(nil),
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated length",
+			reader:  bytes.NewReader([]byte{0, 0, 0}),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated body",
+			reader:  bytes.NewReader(frame(20, 10, 25)),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "version 1",
+			reader: bytes.NewReader([]byte{0, 0, 0, 15, 0, 0, 0, 1, 0, 0, 0, 0,
+				message.Version1, 0, 0, 'a'}),
+			wantLen: 16,
+		},
+		{
+			name:    "not rpc",
+			reader:  bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")),
+			wantErr: &ProtocolError{Err: message.ErrInvalidMagic},
+		},
+		{
+			name:    "header too small",
+			reader:  bytes.NewReader(frame(8, 0, 12)),
+			wantErr: &ProtocolError{Err: fmt.Errorf("%w: 头部长度 8 小于 18", message.ErrMalformed)},
+		},
+		{
+			name:    "header too large",
+			reader:  bytes.NewReader(frame(DefaultMaxHeaderSize+1, 0, 12)),
+			wantErr: &ProtocolError{Reason: "头部长度 65537 超过上限 65536"},
+		},
+		{
+			name:    "body too large",
+			reader:  bytes.NewReader(frame(20, 1<<32-1, 12)),
+			wantErr: &ProtocolError{Reason: "请求体长度 4294967295 超过上限 16777216"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := ReadMsg(tc.reader)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Len(t, data, tc.wantLen)
+		})
+	}
+}
+
+func TestProtocolError_Unwrap(t *testing.T) {
+	err := error(&ProtocolError{Err: message.ErrChecksum})
+	assert.True(t, errors.Is(err, message.ErrChecksum))
+	assert.Equal(t, "micro: 协议错误, "+message.ErrChecksum.Error(), err.Error())
+}
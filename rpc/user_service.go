@@ -2,7 +2,6 @@ package rpc
 
 import (
 	"context"
-	"log"
 	"micro/proto/gen"
 	"testing"
 	"time"
@@ -35,14 +34,12 @@ type UserServiceServer struct {
 }
 
 func (u *UserServiceServer) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
-	log.Println(req)
 	return &GetByIdResp{
 		Msg: u.Msg,
 	}, u.Err
 }
 
 func (u *UserServiceServer) GetByIdProto(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
-	log.Println(req)
 	return &gen.GetByIdResp{
 		User: &gen.User{
 			Name: u.Msg,
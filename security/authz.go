@@ -0,0 +1,194 @@
+package security
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// Policy 方法级别的访问控制, 决定哪些调用方可以调用哪些方法
+//
+//	NewPolicy().
+//		Allow("/users.UserService/GetById", "order-service", "pay-service").
+//		Allow("/users.UserService/*", "admin-service").
+//		Public("/grpc.health.v1.Health/*")
+//
+// 方法名可以是完整的方法名, 也可以是 /包名.服务名/* 或者 *, 调用方 * 表示任意通过认证的调用方
+// 没有匹配规则的方法一律拒绝
+type Policy struct {
+	rules  map[string]map[string]struct{}
+	public map[string]struct{}
+}
+
+func NewPolicy() *Policy {
+	return &Policy{
+		rules:  make(map[string]map[string]struct{}, 8),
+		public: make(map[string]struct{}, 4),
+	}
+}
+
+// Allow subjects 是调用方的身份, 也就是 token 里面的 sub
+func (p *Policy) Allow(method string, subjects ...string) *Policy {
+	rule, ok := p.rules[method]
+	if !ok {
+		rule = make(map[string]struct{}, len(subjects))
+		p.rules[method] = rule
+	}
+	for _, sub := range subjects {
+		rule[sub] = struct{}{}
+	}
+	return p
+}
+
+// Public 不需要认证的方法, 例如健康检查
+func (p *Policy) Public(methods ...string) *Policy {
+	for _, m := range methods {
+		p.public[m] = struct{}{}
+	}
+	return p
+}
+
+func (p *Policy) isPublic(method string) bool {
+	for _, pattern := range patterns(method) {
+		if _, ok := p.public[pattern]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) authorize(method string, principal Principal) bool {
+	for _, pattern := range patterns(method) {
+		rule, ok := p.rules[pattern]
+		if !ok {
+			continue
+		}
+		if _, ok = rule[principal.Subject]; ok {
+			return true
+		}
+		if _, ok = rule["*"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns 从精确到模糊
+// /users.UserService/GetById => /users.UserService/GetById, /users.UserService/*, *
+func patterns(method string) []string {
+	res := []string{method}
+	if idx := strings.LastIndexByte(method, '/'); idx > 0 {
+		res = append(res, method[:idx+1]+"*")
+	}
+	return append(res, "*")
+}
+
+type AuthOption func(b *AuthInterceptorBuilder)
+
+// AuthInterceptorBuilder 服务端的认证和鉴权
+// 从 metadata 里面取出 token 校验, 再按照 Policy 判断能不能调用
+// 通过之后可以用 PrincipalFromContext 拿到调用方
+type AuthInterceptorBuilder struct {
+	verifier Verifier
+	policy   *Policy
+	header   string
+	scheme   string
+}
+
+// NewAuthInterceptorBuilder 没有设置 Policy 的时候, 任何通过认证的调用方都可以调用所有方法
+func NewAuthInterceptorBuilder(v Verifier, opts ...AuthOption) *AuthInterceptorBuilder {
+	res := &AuthInterceptorBuilder{
+		verifier: v,
+		header:   "authorization",
+		scheme:   "Bearer",
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func AuthWithPolicy(p *Policy) AuthOption {
+	return func(b *AuthInterceptorBuilder) {
+		b.policy = p
+	}
+}
+
+// AuthWithHeader 要和客户端的 CredentialsWithHeader 保持一致
+func AuthWithHeader(header, scheme string) AuthOption {
+	return func(b *AuthInterceptorBuilder) {
+		b.header = strings.ToLower(header)
+		b.scheme = scheme
+	}
+}
+
+func (b *AuthInterceptorBuilder) BuildServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp any, err error) {
+		ctx, err = b.auth(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (b *AuthInterceptorBuilder) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := b.auth(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (b *AuthInterceptorBuilder) auth(ctx context.Context, method string) (context.Context, error) {
+	if b.policy != nil && b.policy.isPublic(method) {
+		return ctx, nil
+	}
+	token, ok := b.token(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "micro: 缺少 token")
+	}
+	principal, err := b.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "micro: 认证失败: %v", err)
+	}
+	if b.policy != nil && !b.policy.authorize(method, principal) {
+		return nil, status.Errorf(codes.PermissionDenied, "micro: %s 没有权限调用 %s", principal.Subject, method)
+	}
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+func (b *AuthInterceptorBuilder) token(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	vals := md.Get(b.header)
+	if len(vals) == 0 {
+		return "", false
+	}
+	if b.scheme == "" {
+		return vals[0], vals[0] != ""
+	}
+	prefix := b.scheme + " "
+	if len(vals[0]) <= len(prefix) || !strings.EqualFold(vals[0][:len(prefix)], prefix) {
+		return "", false
+	}
+	return vals[0][len(prefix):], true
+}
+
+// serverStream 替换 context, 让 handler 可以拿到调用方
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,88 @@
+package security
+
+import (
+	"context"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestAuthInterceptorBuilder(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token := func(sub string) string {
+		return "Bearer " + sign(t, jose.SigningKey{Algorithm: jose.HS256, Key: secret},
+			jwt.Claims{Subject: sub, Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	}
+	policy := NewPolicy().
+		Allow("/users.UserService/GetById", "order-service").
+		Allow("/users.UserService/*", "admin-service").
+		Public("/grpc.health.v1.Health/*")
+	interceptor := NewAuthInterceptorBuilder(NewHMACVerifier(secret), AuthWithPolicy(policy)).BuildServerInterceptor()
+
+	testCases := []struct {
+		name          string
+		method        string
+		authorization string
+
+		wantCode    codes.Code
+		wantSubject string
+	}{
+		{
+			name:          "allowed",
+			method:        "/users.UserService/GetById",
+			authorization: token("order-service"),
+			wantSubject:   "order-service",
+		},
+		{
+			name:          "service wildcard",
+			method:        "/users.UserService/Delete",
+			authorization: token("admin-service"),
+			wantSubject:   "admin-service",
+		},
+		{
+			name:          "permission denied",
+			method:        "/users.UserService/Delete",
+			authorization: token("order-service"),
+			wantCode:      codes.PermissionDenied,
+		},
+		{
+			name:     "missing token",
+			method:   "/users.UserService/GetById",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:          "invalid token",
+			method:        "/users.UserService/GetById",
+			authorization: "Bearer abc",
+			wantCode:      codes.Unauthenticated,
+		},
+		{
+			name:   "public",
+			method: "/grpc.health.v1.Health/Check",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tc.authorization))
+			}
+			var subject string
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req any) (any, error) {
+					p, _ := PrincipalFromContext(ctx)
+					subject = p.Subject
+					return nil, nil
+				})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantSubject, subject)
+		})
+	}
+}
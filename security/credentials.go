@@ -0,0 +1,146 @@
+package security
+
+import (
+	"context"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenFunc 获取 token 和它的过期时间, 过期时间为零值表示不会过期
+type TokenFunc func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// StaticToken 固定的 token, 例如 API key
+func StaticToken(token string) TokenFunc {
+	return func(ctx context.Context) (string, time.Time, error) {
+		return token, time.Time{}, nil
+	}
+}
+
+// FileToken 从文件读取 token, 例如 k8s 的 projected service account token
+// 文件会被定期轮换, 所以最多缓存 interval, 如果是 JWT 还不会超过它的 exp
+func FileToken(path string, interval time.Duration) TokenFunc {
+	return func(ctx context.Context) (string, time.Time, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		token := strings.TrimSpace(string(data))
+		expiry := time.Now().Add(interval)
+		if exp, ok := jwtExpiry(token); ok && exp.Before(expiry) {
+			expiry = exp
+		}
+		return token, expiry, nil
+	}
+}
+
+// jwtExpiry 不校验签名, 只是看一下什么时候过期
+func jwtExpiry(token string) (time.Time, bool) {
+	tok, err := jwt.ParseSigned(token, signatureAlgorithms)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims jwt.Claims
+	if err = tok.UnsafeClaimsWithoutVerification(&claims); err != nil || claims.Expiry == nil {
+		return time.Time{}, false
+	}
+	return claims.Expiry.Time(), true
+}
+
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.HS256, jose.HS384, jose.HS512,
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+type CredentialsOption func(c *PerRPCCredentials)
+
+// PerRPCCredentials 实现了 grpc 的 credentials.PerRPCCredentials
+// 每次调用都会在 metadata 里面带上 token, 快过期的时候重新获取
+// 配合 micro.ClientWithPerRPCCredentials 使用
+type PerRPCCredentials struct {
+	fn     TokenFunc
+	header string
+	scheme string
+	// 提前多久刷新, 避免 token 在传输过程中过期
+	refreshBefore time.Duration
+	insecure      bool
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewPerRPCCredentials 默认是 authorization: Bearer <token>
+func NewPerRPCCredentials(fn TokenFunc, opts ...CredentialsOption) *PerRPCCredentials {
+	res := &PerRPCCredentials{
+		fn:            fn,
+		header:        "authorization",
+		scheme:        "Bearer",
+		refreshBefore: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// CredentialsWithHeader 例如 API key 放在 x-api-key 里面, scheme 可以为空
+func CredentialsWithHeader(header, scheme string) CredentialsOption {
+	return func(c *PerRPCCredentials) {
+		c.header = strings.ToLower(header)
+		c.scheme = scheme
+	}
+}
+
+func CredentialsWithRefreshBefore(d time.Duration) CredentialsOption {
+	return func(c *PerRPCCredentials) {
+		c.refreshBefore = d
+	}
+}
+
+// CredentialsAllowInsecure 允许在没有 TLS 的连接上发送 token, 只应该在测试环境使用
+func CredentialsAllowInsecure() CredentialsOption {
+	return func(c *PerRPCCredentials) {
+		c.insecure = true
+	}
+}
+
+func (c *PerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.scheme != "" {
+		token = c.scheme + " " + token
+	}
+	return map[string]string{c.header: token}, nil
+}
+
+func (c *PerRPCCredentials) RequireTransportSecurity() bool {
+	return !c.insecure
+}
+
+// get 加锁保证同一时刻只有一个请求去刷新 token
+func (c *PerRPCCredentials) get(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && (c.expiry.IsZero() || time.Now().Add(c.refreshBefore).Before(c.expiry)) {
+		return c.token, nil
+	}
+	token, expiry, err := c.fn(ctx)
+	if err != nil {
+		// 刷新失败, 旧的 token 还没有过期就继续用
+		if c.token != "" && time.Now().Before(c.expiry) {
+			return c.token, nil
+		}
+		return "", err
+	}
+	c.token, c.expiry = token, expiry
+	return token, nil
+}
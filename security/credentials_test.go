@@ -0,0 +1,56 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPerRPCCredentials(t *testing.T) {
+	var (
+		cnt  int
+		errs []error
+	)
+	creds := NewPerRPCCredentials(func(ctx context.Context) (string, time.Time, error) {
+		cnt++
+		if len(errs) > 0 {
+			err := errs[0]
+			errs = errs[1:]
+			return "", time.Time{}, err
+		}
+		return fmt.Sprintf("token-%d", cnt), time.Now().Add(100 * time.Millisecond), nil
+	}, CredentialsWithRefreshBefore(50*time.Millisecond))
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer token-1"}, md)
+	// 没有到刷新时间, 使用缓存
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer token-1"}, md)
+
+	// 进入刷新窗口, 刷新失败但是旧的还没有过期
+	time.Sleep(60 * time.Millisecond)
+	errs = []error{errors.New("auth server down")}
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer token-1"}, md)
+
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer token-3"}, md)
+	assert.True(t, creds.RequireTransportSecurity())
+}
+
+func TestPerRPCCredentials_APIKey(t *testing.T) {
+	creds := NewPerRPCCredentials(StaticToken("my-key"), CredentialsWithHeader("X-API-Key", ""),
+		CredentialsAllowInsecure())
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"x-api-key": "my-key"}, md)
+	assert.False(t, creds.RequireTransportSecurity())
+}
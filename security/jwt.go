@@ -0,0 +1,197 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Principal 通过认证的调用方
+type Principal struct {
+	// Subject 调用方的身份, 一般是服务名
+	Subject  string
+	Issuer   string
+	Audience []string
+	// Claims token 里面所有的字段, 可以用来做更细的鉴权
+	Claims map[string]any
+}
+
+type principalKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext 在业务代码里面拿到调用方
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Verifier 校验 token, 返回调用方
+type Verifier interface {
+	Verify(ctx context.Context, token string) (Principal, error)
+}
+
+type VerifierOption func(v *JWTVerifier)
+
+// JWTVerifier 校验 JWT 的签名和 exp, nbf, iss, aud
+type JWTVerifier struct {
+	algorithms []jose.SignatureAlgorithm
+	issuer     string
+	audience   []string
+	leeway     time.Duration
+	// 检查 JWKS 文件是否变化的间隔
+	reloadInterval time.Duration
+	// key 返回校验签名用的 key, HMAC 是 []byte, JWKS 是 jose.JSONWebKeySet
+	key func() (any, error)
+}
+
+// NewHMACVerifier 使用共享密钥校验
+func NewHMACVerifier(secret []byte, opts ...VerifierOption) *JWTVerifier {
+	res := newJWTVerifier([]jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512}, opts)
+	res.key = func() (any, error) {
+		return secret, nil
+	}
+	return res
+}
+
+// NewJWKSVerifier 使用本地的 JWKS 文件校验, 文件变化之后自动重新加载, 加载失败继续用旧的
+// 按照 token 头部的 kid 来选择 key
+func NewJWKSVerifier(path string, opts ...VerifierOption) (*JWTVerifier, error) {
+	res := newJWTVerifier([]jose.SignatureAlgorithm{
+		jose.RS256, jose.RS384, jose.RS512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.EdDSA,
+	}, opts)
+	ks := &keySet{path: path, interval: res.reloadInterval}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	res.key = ks.current
+	return res, nil
+}
+
+func newJWTVerifier(algorithms []jose.SignatureAlgorithm, opts []VerifierOption) *JWTVerifier {
+	res := &JWTVerifier{
+		algorithms:     algorithms,
+		leeway:         jwt.DefaultLeeway,
+		reloadInterval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func VerifierWithIssuer(issuer string) VerifierOption {
+	return func(v *JWTVerifier) {
+		v.issuer = issuer
+	}
+}
+
+// VerifierWithAudience token 的 aud 只要包含其中一个就可以, 一般是自己的服务名
+func VerifierWithAudience(audience ...string) VerifierOption {
+	return func(v *JWTVerifier) {
+		v.audience = audience
+	}
+}
+
+// VerifierWithLeeway 允许的时钟误差
+func VerifierWithLeeway(leeway time.Duration) VerifierOption {
+	return func(v *JWTVerifier) {
+		v.leeway = leeway
+	}
+}
+
+func VerifierWithReloadInterval(interval time.Duration) VerifierOption {
+	return func(v *JWTVerifier) {
+		v.reloadInterval = interval
+	}
+}
+
+var errInvalidToken = errors.New("micro: token 不合法")
+
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Principal, error) {
+	tok, err := jwt.ParseSigned(token, v.algorithms)
+	if err != nil {
+		return Principal{}, errors.Join(errInvalidToken, err)
+	}
+	key, err := v.key()
+	if err != nil {
+		return Principal{}, err
+	}
+	var (
+		claims jwt.Claims
+		all    map[string]any
+	)
+	if err = tok.Claims(key, &claims, &all); err != nil {
+		return Principal{}, errors.Join(errInvalidToken, err)
+	}
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:      v.issuer,
+		AnyAudience: v.audience,
+		Time:        time.Now(),
+	}, v.leeway)
+	if err != nil {
+		return Principal{}, errors.Join(errInvalidToken, err)
+	}
+	return Principal{
+		Subject:  claims.Subject,
+		Issuer:   claims.Issuer,
+		Audience: claims.Audience,
+		Claims:   all,
+	}, nil
+}
+
+// keySet 和 CertStore 一样, 超过检查间隔才去看文件有没有变化
+type keySet struct {
+	path     string
+	interval time.Duration
+
+	mutex     sync.RWMutex
+	keys      jose.JSONWebKeySet
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (ks *keySet) current() (any, error) {
+	ks.mutex.RLock()
+	lastCheck := ks.lastCheck
+	ks.mutex.RUnlock()
+	if time.Since(lastCheck) >= ks.interval {
+		_ = ks.load()
+	}
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.keys, nil
+}
+
+func (ks *keySet) load() error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.lastCheck = time.Now()
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(ks.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	var keys jose.JSONWebKeySet
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	ks.keys, ks.modTime = keys, info.ModTime()
+	return nil
+}
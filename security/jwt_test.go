@@ -0,0 +1,116 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJWTVerifier_HMAC(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	v := NewHMACVerifier(secret, VerifierWithIssuer("micro-auth"), VerifierWithAudience("user-service"))
+
+	testCases := []struct {
+		name   string
+		key    any
+		claims jwt.Claims
+
+		wantSubject string
+		wantErr     bool
+	}{
+		{
+			name: "valid",
+			key:  secret,
+			claims: jwt.Claims{Subject: "order-service", Issuer: "micro-auth",
+				Audience: jwt.Audience{"user-service"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+			wantSubject: "order-service",
+		},
+		{
+			name: "expired",
+			key:  secret,
+			claims: jwt.Claims{Subject: "order-service", Issuer: "micro-auth",
+				Audience: jwt.Audience{"user-service"}, Expiry: jwt.NewNumericDate(time.Now().Add(-time.Hour))},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			key:  secret,
+			claims: jwt.Claims{Subject: "order-service", Issuer: "micro-auth",
+				Audience: jwt.Audience{"pay-service"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+			wantErr: true,
+		},
+		{
+			name: "wrong secret",
+			key:  []byte("fedcba9876543210fedcba9876543210"),
+			claims: jwt.Claims{Subject: "order-service", Issuer: "micro-auth",
+				Audience: jwt.Audience{"user-service"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := sign(t, jose.SigningKey{Algorithm: jose.HS256, Key: tc.key}, tc.claims)
+			p, err := v.Verify(context.Background(), token)
+			assert.Equal(t, tc.wantErr, err != nil, err)
+			assert.Equal(t, tc.wantSubject, p.Subject)
+		})
+	}
+}
+
+func TestJWTVerifier_JWKS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	oldKey := writeJWKS(t, path, "key-1")
+	v, err := NewJWKSVerifier(path, VerifierWithReloadInterval(0))
+	require.NoError(t, err)
+	claims := jwt.Claims{Subject: "order-service", Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+
+	_, err = v.Verify(context.Background(), signES256(t, oldKey, "key-1", claims))
+	require.NoError(t, err)
+
+	// 轮换 key 之后, 旧的 key 签发的 token 不能再用
+	// 等一下再写, 文件的修改时间精度有限
+	time.Sleep(20 * time.Millisecond)
+	newKey := writeJWKS(t, path, "key-2")
+	_, err = v.Verify(context.Background(), signES256(t, newKey, "key-2", claims))
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), signES256(t, oldKey, "key-1", claims))
+	assert.Error(t, err)
+}
+
+func sign(t *testing.T, key jose.SigningKey, claims any) string {
+	signer, err := jose.NewSigner(key, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims any) string {
+	return sign(t, jose.SigningKey{Algorithm: jose.ES256,
+		Key: jose.JSONWebKey{Key: key, KeyID: kid, Algorithm: string(jose.ES256)}}, claims)
+}
+
+// writeJWKS 生成新的 key, 把公钥写到 JWKS 文件里面
+func writeJWKS(t *testing.T, path, kid string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	data, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &key.PublicKey, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"},
+	}})
+	require.NoError(t, err)
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, data, 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+	return key
+}
@@ -0,0 +1,230 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type CertOption func(c *CertStore)
+
+// CertStore 从文件加载证书, 文件变化之后自动重新加载
+// 证书轮转的时候不需要重启进程, 已经建立的连接不受影响, 新的握手使用新的证书
+// 重新加载失败会继续使用旧的证书
+type CertStore struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	// 检查文件是否变化的间隔, 在握手的时候检查, 不会启动额外的 goroutine
+	interval time.Duration
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertStore certFile 和 keyFile 是自己的证书, 可以为空, 例如客户端不需要 mTLS
+func NewCertStore(certFile, keyFile string, opts ...CertOption) (*CertStore, error) {
+	res := &CertStore{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CertWithCAFile 用来校验对端证书的 CA, 客户端和 mTLS 的服务端必须设置
+// 不会退回到系统的 CA, 否则任何公网 CA 签发的证书都能通过校验
+func CertWithCAFile(caFile string) CertOption {
+	return func(c *CertStore) {
+		c.caFile = caFile
+	}
+}
+
+func CertWithReloadInterval(interval time.Duration) CertOption {
+	return func(c *CertStore) {
+		c.interval = interval
+	}
+}
+
+type clientTLS struct {
+	skipIdentity bool
+}
+
+type ClientTLSOption func(c *clientTLS)
+
+// ClientTLSWithoutIdentity 不校验服务端证书的 SAN, 只要是 CA 签发的证书都接受
+// 只有所有服务共用一个证书的时候才需要
+func ClientTLSWithoutIdentity() ClientTLSOption {
+	return func(c *clientTLS) {
+		c.skipIdentity = true
+	}
+}
+
+// ClientConfig 客户端的 TLS 配置, 服务端证书必须是 CA 签发的, 并且 SAN 包含服务名
+// 服务名就是 grpc 的 authority, micro.Client 和 cluster 里面会设置为注册中心的服务名
+func (c *CertStore) ClientConfig(opts ...ClientTLSOption) *tls.Config {
+	var cfg clientTLS
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		// CA 会重新加载, 所以不能用 RootCAs, 在 VerifyConnection 里面自己校验
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cfg.skipIdentity {
+				return c.verify(cs.PeerCertificates, "", x509.ExtKeyUsageServerAuth)
+			}
+			// DNSName 为空的时候 x509 不检查 SAN
+			if cs.ServerName == "" {
+				return errNoServerName
+			}
+			return c.verify(cs.PeerCertificates, cs.ServerName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// ServerConfig 服务端的 TLS 配置, requireClientCert 为 true 的时候就是 mTLS
+func (c *CertStore) ServerConfig(requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			if cert == nil {
+				return nil, errNoCertificate
+			}
+			// ClientCAs 为 nil 的时候会使用系统的 CA
+			if requireClientCert && pool == nil {
+				return nil, errNoCA
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				// grpc 要求协商 h2
+				NextProtos: []string{"h2"},
+			}
+			if requireClientCert {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+var (
+	errNoCertificate = errors.New("micro: 没有配置证书")
+	errNoCA          = errors.New("micro: 没有配置 CA")
+	errNoServerName  = errors.New("micro: 没有服务名, 无法校验服务端证书")
+)
+
+func (c *CertStore) verify(certs []*x509.Certificate, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errNoCertificate
+	}
+	_, pool := c.current()
+	// Roots 为 nil 的时候会使用系统的 CA
+	if pool == nil {
+		return errNoCA
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// current 返回当前的证书, 超过检查间隔就看一下文件有没有变化
+func (c *CertStore) current() (*tls.Certificate, *x509.CertPool) {
+	c.mutex.RLock()
+	cert, pool, lastCheck := c.cert, c.pool, c.lastCheck
+	c.mutex.RUnlock()
+	if c.interval <= 0 || time.Since(lastCheck) < c.interval {
+		return cert, pool
+	}
+	// 失败了继续用旧的
+	_ = c.load()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, c.pool
+}
+
+// load 文件有变化的时候重新加载
+func (c *CertStore) load() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastCheck = time.Now()
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	// 用 Equal 而不是 After, k8s 挂载的 secret 更新之后时间可能会变小
+	if modTime.Equal(c.modTime) {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	if c.certFile != "" {
+		crt, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &crt
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		data, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("micro: CA 文件 %s 里面没有证书", c.caFile)
+		}
+	}
+	c.cert, c.pool, c.modTime = cert, pool, modTime
+	return nil
+}
+
+func (c *CertStore) latestModTime() (time.Time, error) {
+	var res time.Time
+	for _, file := range []string{c.certFile, c.keyFile, c.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(res) {
+			res = info.ModTime()
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,214 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertStore(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	ca.writeCA(t, filepath.Join(dir, "ca.pem"))
+	ca.issue(t, "user-service", filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	ca.issue(t, "order-service", filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+
+	serverStore, err := NewCertStore(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"),
+		CertWithCAFile(filepath.Join(dir, "ca.pem")))
+	require.NoError(t, err)
+	addr := startServer(t, serverStore, true)
+
+	testCases := []struct {
+		name      string
+		certFile  string
+		keyFile   string
+		caFile    string
+		authority string
+		opts      []ClientTLSOption
+
+		wantErr bool
+	}{
+		{
+			name:      "mTLS",
+			certFile:  filepath.Join(dir, "client.pem"),
+			keyFile:   filepath.Join(dir, "client.key"),
+			caFile:    filepath.Join(dir, "ca.pem"),
+			authority: "user-service",
+		},
+		{
+			name:      "wrong service",
+			certFile:  filepath.Join(dir, "client.pem"),
+			keyFile:   filepath.Join(dir, "client.key"),
+			caFile:    filepath.Join(dir, "ca.pem"),
+			authority: "payment-service",
+			wantErr:   true,
+		},
+		{
+			name:      "skip identity check",
+			certFile:  filepath.Join(dir, "client.pem"),
+			keyFile:   filepath.Join(dir, "client.key"),
+			caFile:    filepath.Join(dir, "ca.pem"),
+			authority: "payment-service",
+			opts:      []ClientTLSOption{ClientTLSWithoutIdentity()},
+		},
+		{
+			// 不会退回到系统的 CA
+			name:      "no CA",
+			certFile:  filepath.Join(dir, "client.pem"),
+			keyFile:   filepath.Join(dir, "client.key"),
+			authority: "user-service",
+			opts:      []ClientTLSOption{ClientTLSWithoutIdentity()},
+			wantErr:   true,
+		},
+		{
+			name:      "no client cert",
+			caFile:    filepath.Join(dir, "ca.pem"),
+			authority: "user-service",
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewCertStore(tc.certFile, tc.keyFile, CertWithCAFile(tc.caFile))
+			require.NoError(t, err)
+			err = check(addr, store, tc.authority, tc.opts...)
+			assert.Equal(t, tc.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestCertStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	ca.writeCA(t, filepath.Join(dir, "ca.pem"))
+	ca.issue(t, "user-service", filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+
+	serverStore, err := NewCertStore(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"),
+		CertWithReloadInterval(time.Millisecond))
+	require.NoError(t, err)
+	addr := startServer(t, serverStore, false)
+	clientStore, err := NewCertStore("", "", CertWithCAFile(filepath.Join(dir, "ca.pem")),
+		CertWithReloadInterval(time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, check(addr, clientStore, "user-service"))
+
+	// 换一个 CA 重新签发, 两边都不需要重启
+	// 等一下再写, 文件的修改时间精度有限
+	time.Sleep(20 * time.Millisecond)
+	ca = newTestCA(t)
+	ca.issue(t, "user-service", filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	ca.writeCA(t, filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, check(addr, clientStore, "user-service"))
+}
+
+func TestCertStore_ServerWithoutCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	ca.writeCA(t, filepath.Join(dir, "ca.pem"))
+	ca.issue(t, "user-service", filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	ca.issue(t, "order-service", filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+
+	// mTLS 的服务端没有 CA, 不会用系统的 CA 校验客户端证书
+	serverStore, err := NewCertStore(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	require.NoError(t, err)
+	addr := startServer(t, serverStore, true)
+	clientStore, err := NewCertStore(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"),
+		CertWithCAFile(filepath.Join(dir, "ca.pem")))
+	require.NoError(t, err)
+	assert.Error(t, check(addr, clientStore, "user-service"))
+}
+
+func startServer(t *testing.T, store *CertStore, requireClientCert bool) string {
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(store.ServerConfig(requireClientCert))))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func check(addr string, store *CertStore, authority string, opts ...ClientTLSOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cc, err := grpc.Dial(addr, grpc.WithAuthority(authority),
+		grpc.WithTransportCredentials(credentials.NewTLS(store.ClientConfig(opts...))))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(false))
+	return err
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "micro test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCA(t *testing.T, path string) {
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+}
+
+// issue 签发一个同时可以用于服务端和客户端的证书, SAN 是服务名
+func (ca *testCA) issue(t *testing.T, service, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: service},
+		DNSNames:     []string{service},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
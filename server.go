@@ -2,40 +2,310 @@ package micro
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"micro/observability"
 	"micro/registry"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type ServerOption func(server *Server)
 
+// Hook 服务端生命周期钩子
+type Hook func(ctx context.Context) error
+
 // Server 标识信息服务名
 // rpc 连接的服务端
 // 兼容自定注册中心
 type Server struct {
 	name string
 	*grpc.Server
-	registry registry.Registry
+	registry        registry.Registry
 	registerTimeout time.Duration
-	listener net.Listener
-	weight uint32
-	group string
+	listener        net.Listener
+	weight          uint32
+	group           string
+
+	// 已经注册的节点, 关闭的时候要先注销
+	si    *registry.ServiceInstance
+	mutex sync.Mutex
+	// health 记录服务状态, 调试接口和优雅退出都会用到
+	// 设置了 ServerWithHealth 才会注册为 grpc 的健康检查服务
+	health         *health.Server
+	registerHealth bool
+	// 注销之后等待客户端感知的时间
+	shutdownDelay time.Duration
+	// 等待处理中请求的最长时间, 超时强制关闭
+	shutdownTimeout time.Duration
+	// 收到这些信号就优雅退出
+	signals   []os.Signal
+	// Serve 退出之后关闭, serveErr 是 Serve 的返回值
+	served    chan struct{}
+	serveErr  error
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	// 注册失败的重试次数, 以及第一次重试的间隔
+	registerRetries int
+	registerBackoff time.Duration
+	// 就绪检查全部通过之后才会注册
+	probes        []Hook
+	probeInterval time.Duration
+	onStart       []Hook
+	onRegistered  []Hook
+	onStop        []Hook
+
+	// 注册到注册中心的地址, 为空则根据监听地址和本机 IP 推断
+	advertiseAddr      string
+	metadata           map[string]string
+	grpcOpts           []grpc.ServerOption
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+
+	// 管理端口, 暴露 /metrics 之类的 HTTP 接口, 为空则不启动
+	adminAddr   string
+	adminMux    *http.ServeMux
+	adminServer *http.Server
+	// 调试接口展示的组件, 例如限流器
+	inspectors map[string]Inspector
+
+	logger observability.Logger
 }
 
-func NewServer(name string, opts...ServerOption) (*Server, error) {
+func NewServer(name string, opts ...ServerOption) (*Server, error) {
 	res := &Server{
-		name: name,
-		Server: grpc.NewServer(),
+		name:            name,
 		registerTimeout: 10 * time.Second, // 初始固定注册超时时间
+		health:          health.NewServer(),
+		shutdownDelay:   3 * time.Second,
+		shutdownTimeout: 10 * time.Second,
+		signals:         []os.Signal{syscall.SIGTERM, syscall.SIGINT},
+		served:          make(chan struct{}),
+		closed:          make(chan struct{}),
+		registerRetries: 3,
+		registerBackoff: time.Second,
+		probeInterval:   500 * time.Millisecond,
+		logger:          observability.LoggerOrDefault(nil),
 	}
-	
+
 	for _, opt := range opts {
 		opt(res)
 	}
+	// 拦截器放在最后, 让用户通过 ServerWithGRPCOptions 传入的拦截器先执行
+	grpcOpts := res.grpcOpts
+	if len(res.unaryInterceptors) > 0 {
+		grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(res.unaryInterceptors...))
+	}
+	if len(res.streamInterceptors) > 0 {
+		grpcOpts = append(grpcOpts, grpc.ChainStreamInterceptor(res.streamInterceptors...))
+	}
+	res.Server = grpc.NewServer(grpcOpts...)
+	// 就绪之前健康检查返回 NOT_SERVING
+	res.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	if res.registerHealth {
+		healthpb.RegisterHealthServer(res.Server, res.health)
+	}
 	return res, nil
 }
 
+// ServerWithLogger 默认使用 slog.Default()
+func ServerWithLogger(l observability.Logger) ServerOption {
+	return func(server *Server) {
+		server.logger = observability.LoggerOrDefault(l)
+	}
+}
+
+// ServerWithHealth 注册 grpc 的健康检查服务, 就绪之后返回 SERVING, 退出的时候返回 NOT_SERVING
+// 自己注册了健康检查服务的不要设置, 否则会重复注册
+func ServerWithHealth() ServerOption {
+	return func(server *Server) {
+		server.registerHealth = true
+	}
+}
+
+// ServerWithRegisterRetry 注册失败的重试次数, 以及第一次重试的间隔, 之后按照指数退避
+func ServerWithRegisterRetry(retries int, backoff time.Duration) ServerOption {
+	return func(server *Server) {
+		server.registerRetries = retries
+		server.registerBackoff = backoff
+	}
+}
+
+// ServerWithReadinessProbe 所有的就绪检查都通过之后才会注册, 例如检查数据库连接
+func ServerWithReadinessProbe(probes ...Hook) ServerOption {
+	return func(server *Server) {
+		server.probes = append(server.probes, probes...)
+	}
+}
+
+// ServerWithOnStart 开始监听之后, 就绪检查之前执行, 返回 error 会中止启动
+func ServerWithOnStart(hooks ...Hook) ServerOption {
+	return func(server *Server) {
+		server.onStart = append(server.onStart, hooks...)
+	}
+}
+
+// ServerWithOnRegistered 注册成功之后执行, 返回 error 会中止启动
+func ServerWithOnRegistered(hooks ...Hook) ServerOption {
+	return func(server *Server) {
+		server.onRegistered = append(server.onRegistered, hooks...)
+	}
+}
+
+// ServerWithOnStop 关闭的时候, 处理完所有请求之后执行
+func ServerWithOnStop(hooks ...Hook) ServerOption {
+	return func(server *Server) {
+		server.onStop = append(server.onStop, hooks...)
+	}
+}
+
+// ServerWithShutdownDelay 注销之后等待多久再关闭, 给客户端感知节点下线的时间
+func ServerWithShutdownDelay(delay time.Duration) ServerOption {
+	return func(server *Server) {
+		server.shutdownDelay = delay
+	}
+}
+
+// ServerWithShutdownTimeout 等待处理中请求的最长时间, 超时之后强制关闭
+func ServerWithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(server *Server) {
+		server.shutdownTimeout = timeout
+	}
+}
+
+// ServerWithShutdownSignals 收到这些信号就调用 Close, 默认是 SIGTERM 和 SIGINT
+func ServerWithShutdownSignals(signals ...os.Signal) ServerOption {
+	return func(server *Server) {
+		server.signals = signals
+	}
+}
+
+// ServerWithoutShutdownSignals 不监听信号, 应用自己处理信号的时候使用, 收到信号之后调用 Close 即可
+func ServerWithoutShutdownSignals() ServerOption {
+	return func(server *Server) {
+		server.signals = nil
+	}
+}
+
+func ServerWithWeight(weight uint32) ServerOption {
+	return func(server *Server) {
+		server.weight = weight
+	}
+}
+
+// ServerWithAdvertiseAddr 注册到注册中心的地址, 例如容器里面对外可访问的地址
+// 不设置的话, 监听 :8081 这种地址时会用本机 IP 加上监听端口
+func ServerWithAdvertiseAddr(addr string) ServerOption {
+	return func(server *Server) {
+		server.advertiseAddr = addr
+	}
+}
+
+// ServerWithMetadata 注册到注册中心的 Metadata, 例如版本号, 机房
+func ServerWithMetadata(md map[string]string) ServerOption {
+	return func(server *Server) {
+		server.metadata = md
+	}
+}
+
+// ServerWithGRPCOptions 透传给 grpc.NewServer 的参数, 例如 TLS 证书, keepalive
+func ServerWithGRPCOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(server *Server) {
+		server.grpcOpts = append(server.grpcOpts, opts...)
+	}
+}
+
+// ServerWithTLS 使用 TLS, 需要 mTLS 的话在 tls.Config 里面校验客户端证书
+// 可以使用 security.CertStore 生成配置, 支持证书热加载
+func ServerWithTLS(cfg *tls.Config) ServerOption {
+	return ServerWithGRPCOptions(grpc.Creds(credentials.NewTLS(cfg)))
+}
+
+// ServerWithUnaryInterceptor 多次调用会按照顺序串联起来
+func ServerWithUnaryInterceptor(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(server *Server) {
+		server.unaryInterceptors = append(server.unaryInterceptors, interceptors...)
+	}
+}
+
+func ServerWithStreamInterceptor(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(server *Server) {
+		server.streamInterceptors = append(server.streamInterceptors, interceptors...)
+	}
+}
+
+// ServerWithMetricsHandler 在 addr 上启动 HTTP 服务, 通过 /metrics 暴露 g 里面的指标
+// g 为 nil 的时候用 prometheus.DefaultGatherer
+func ServerWithMetricsHandler(addr string, g prometheus.Gatherer) ServerOption {
+	return func(server *Server) {
+		if g == nil {
+			g = prometheus.DefaultGatherer
+		}
+		server.handleAdmin(addr, "/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	}
+}
+
+// ServerWithDebugHandler 在管理端口上通过 /debug/micro 查看服务名, 注册的节点, 健康状态,
+// 以及 ServerWithInspector 注册的组件的状态, 可以和 ServerWithMetricsHandler 共用一个端口
+func ServerWithDebugHandler(addr string) ServerOption {
+	return func(server *Server) {
+		server.handleAdmin(addr, "/debug/micro", http.HandlerFunc(server.serveDebug))
+	}
+}
+
+// ServerWithInspector 在调试接口里面展示 i 的状态, 例如 ratelimit 里面的限流器
+func ServerWithInspector(name string, i Inspector) ServerOption {
+	return func(server *Server) {
+		if server.inspectors == nil {
+			server.inspectors = make(map[string]Inspector)
+		}
+		server.inspectors[name] = i
+	}
+}
+
+func (s *Server) serveDebug(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	si := s.si
+	s.mutex.Unlock()
+	health, err := s.health.Check(r.Context(), &healthpb.HealthCheckRequest{})
+	status := health.GetStatus().String()
+	if err != nil {
+		status = err.Error()
+	}
+	components := make(map[string]any, len(s.inspectors))
+	for name, i := range s.inspectors {
+		components[name] = i.Inspect()
+	}
+	writeDebugJSON(w, map[string]any{
+		"service":    s.name,
+		"instance":   si,
+		"health":     status,
+		"components": components,
+	})
+}
+
+// handleAdmin 所有管理接口共用一个 HTTP 服务
+func (s *Server) handleAdmin(addr string, pattern string, handler http.Handler) {
+	s.adminAddr = addr
+	if s.adminMux == nil {
+		s.adminMux = http.NewServeMux()
+	}
+	s.adminMux.Handle(pattern, handler)
+}
 
 func ServerWithRegistry(r registry.Registry) ServerOption {
 	return func(server *Server) {
@@ -55,44 +325,293 @@ func (s *Server) Start(addr string) error {
 		return err
 	}
 	s.listener = lis
-	
+	if err = s.startAdmin(); err != nil {
+		_ = lis.Close()
+		return err
+	}
+
+	// 先启动 rpc 监听, 确认可以对外服务之后再注册
+	// 否则客户端可能在服务端 Serve 之前就拿到了这个节点
+	go func() {
+		s.serveErr = s.Serve(s.listener)
+		close(s.served)
+	}()
+	if len(s.signals) > 0 {
+		go s.waitSignal()
+	}
+
+	err = s.ready()
+	if errors.Is(err, errServerClosed) {
+		// 启动过程中被关闭了, 等 Serve 退出即可
+		<-s.served
+		return s.serveErr
+	}
+	if err != nil {
+		// 最佳实践: 启动失败也要释放资源, 不要让监听一直开着
+		_ = s.Close()
+		<-s.served
+		return err
+	}
+	<-s.served
+	return s.serveErr
+}
+
+var errServerClosed = errors.New("micro: 服务端已经关闭")
+
+func (s *Server) startAdmin() error {
+	if s.adminAddr == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", s.adminAddr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.adminMux}
+	s.mutex.Lock()
+	s.adminServer = srv
+	s.mutex.Unlock()
+	go func() {
+		if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("管理端口退出", "address", s.adminAddr, "error", err)
+		}
+	}()
+	return nil
+}
+
+// ready 依次执行 OnStart 钩子, 就绪检查, 注册, OnRegistered 钩子
+func (s *Server) ready() error {
+	if err := s.runHooks(s.onStart); err != nil {
+		return err
+	}
+	if err := s.waitProbes(); err != nil {
+		return err
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
 	// 当前服务是否有注册中心
-	if s.registry != nil {
-		// 在这里注册
+	if s.registry == nil {
+		return nil
+	}
+	// Serve 已经出错退出的话就不能注册了, 不然客户端会拿到一个连不上的节点
+	if err := s.serveFailed(); err != nil {
+		return err
+	}
+	addr, err := s.address()
+	if err != nil {
+		return err
+	}
+	si := registry.ServiceInstance{
+		Name: s.name,
+		// 节点的唯一定位信息
+		Address: addr,
+		Weight:  s.weight,
+		// 分组信息
+		Group:    s.group,
+		Metadata: s.metadata,
+	}
+	if err = s.register(si); err != nil {
+		return err
+	}
+	s.logger.Info("注册成功", "service", s.name, "address", si.Address)
+	s.mutex.Lock()
+	s.si = &si
+	s.mutex.Unlock()
+	// 这里已经注册成功了, 资源统一在 Close() 中释放
+	return s.runHooks(s.onRegistered)
+}
+
+// address 注册到注册中心的地址
+// 监听 :8081 的时候 listener.Addr() 是 [::]:8081, 其它节点是连不上的, 要换成本机 IP
+func (s *Server) address() (string, error) {
+	if s.advertiseAddr != "" {
+		return s.advertiseAddr, nil
+	}
+	addr := s.listener.Addr().String()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return addr, nil
+	}
+	host, err = hostIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// hostIP 和链路追踪里面记录的本机 IP 保持一致
+func hostIP() (string, error) {
+	if ip := observability.GetOutboundIP(); ip != "" {
+		return ip, nil
+	}
+	return "", errors.New("micro: 找不到本机 IP, 请使用 ServerWithAdvertiseAddr 指定注册地址")
+}
+
+// waitProbes 等待所有的就绪检查通过, 最多等待 registerTimeout
+func (s *Server) waitProbes() error {
+	if len(s.probes) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
+	defer cancel()
+	ticker := time.NewTicker(s.probeInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		for _, probe := range s.probes {
+			if err = probe(ctx); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("micro: 就绪检查没有通过: %w", err)
+		case <-s.closed:
+			return errServerClosed
+		}
+	}
+}
+
+// serveFailed 先看是不是被关闭了, 关闭的时候 Serve 也会退出
+func (s *Server) serveFailed() error {
+	select {
+	case <-s.closed:
+		return errServerClosed
+	default:
+	}
+	select {
+	case <-s.served:
+		return fmt.Errorf("micro: rpc 服务提前退出: %w", s.serveErr)
+	default:
+		return nil
+	}
+}
+
+// register 注册失败按照指数退避重试
+func (s *Server) register(si registry.ServiceInstance) error {
+	backoff := s.registerBackoff
+	for i := 0; ; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
+		err := s.registry.Register(ctx, si)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if i >= s.registerRetries {
+			return fmt.Errorf("micro: 注册失败, 重试 %d 次: %w", i, err)
+		}
+		s.logger.Warn("注册失败, 准备重试", "service", s.name, "address", si.Address,
+			"backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-s.closed:
+			return errServerClosed
+		case <-s.served:
+			return s.serveFailed()
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (s *Server) runHooks(hooks []Hook) error {
+	for _, hook := range hooks {
 		ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
-		defer cancel()
-		err = s.registry.Register(ctx, registry.ServiceInstance{
-			Name:    s.name,
-			// 节点的唯一定位信息
-			Address: s.listener.Addr().String(),
-			// 分组信息
-			Group: s.group,
-		})
+		err := hook(ctx)
+		cancel()
 		if err != nil {
 			return err
 		}
-		// 这里已经注册成功了
-		// 最佳实践: 实现 Close(), 同一关闭资源
-		//defer func() {
-		// 忽略或者 log 一下错误
-		//_ = s.registry.Close()
-		//_ = s.registry.UnRegister(registry.ServiceInstance{})
-		//}()
 	}
-	
-	// 启动 rpc 监听
-	err = s.Serve(s.listener)
-	return err
+	return nil
+}
+
+func (s *Server) waitSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, s.signals...)
+	defer signal.Stop(ch)
+	select {
+	case sig := <-ch:
+		s.logger.Info("收到退出信号, 开始优雅退出", "service", s.name, "signal", sig.String())
+		if err := s.Close(); err != nil {
+			s.logger.Error("优雅退出失败", "service", s.name, "error", err)
+		}
+	case <-s.closed:
+	}
 }
 
+// Close 优雅退出:
+// 1. 从注册中心注销, 客户端不再选中这个节点
+// 2. 等待一段时间, 让客户端感知到节点下线
+// 3. 健康检查返回 NOT_SERVING
+// 4. 等待处理中的请求结束, 超时则强制关闭
+// 5. 关闭注册中心
 func (s *Server) Close() error {
-	if s.registry != nil {
-		err := s.registry.Close()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.closeErr = s.shutdown()
+	})
+	return s.closeErr
+}
+
+func (s *Server) shutdown() error {
+	var errs []error
+	s.mutex.Lock()
+	si := s.si
+	adminServer := s.adminServer
+	s.mutex.Unlock()
+	if s.registry != nil && si != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
+		err := s.registry.UnRegister(ctx, *si)
+		cancel()
 		if err != nil {
-			return err
+			errs = append(errs, err)
 		}
+		time.Sleep(s.shutdownDelay)
 	}
+	s.health.Shutdown()
+
 	// 直接 grpc 的优雅退出方法, 关闭链接
-	s.GracefulStop()
-	return nil
-}
\ No newline at end of file
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.shutdownTimeout):
+		// 还有请求没处理完, 强制关闭
+		s.logger.Warn("等待请求处理超时, 强制关闭", "service", s.name, "timeout", s.shutdownTimeout)
+		s.Stop()
+	}
+	// 管理接口最后关, 退出过程中还可以看指标
+	if adminServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		if err := adminServer.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		cancel()
+	}
+
+	for _, hook := range s.onStop {
+		ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		cancel()
+	}
+
+	if s.registry != nil {
+		if err := s.registry.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,419 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"micro/observability/metrics"
+	"micro/registry"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServer_Close(t *testing.T) {
+	r := &memRegistry{}
+	server, err := NewServer("user-service", ServerWithRegistry(r),
+		ServerWithShutdownDelay(10*time.Millisecond))
+	require.NoError(t, err)
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- server.Start("127.0.0.1:0")
+	}()
+	require.Eventually(t, func() bool {
+		return len(r.calls()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, server.Close())
+	// 多次关闭不会出错
+	require.NoError(t, server.Close())
+	select {
+	case err = <-startErr:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Start 没有返回")
+	}
+	// 先注销再关闭注册中心
+	assert.Equal(t, []string{"Register", "UnRegister", "Close"}, r.calls())
+}
+
+func TestServer_Start(t *testing.T) {
+	testCases := []struct {
+		name string
+		r    *memRegistry
+		opts func(r *memRegistry) []ServerOption
+
+		wantErr   error
+		wantCalls []string
+	}{
+		{
+			name: "register retry",
+			r:    &memRegistry{errs: []error{errors.New("etcd down"), nil}},
+			opts: func(r *memRegistry) []ServerOption {
+				return []ServerOption{ServerWithRegisterRetry(1, time.Millisecond)}
+			},
+			wantCalls: []string{"Register", "Register", "UnRegister", "Close"},
+		},
+		{
+			name: "register failed",
+			r:    &memRegistry{errs: []error{errors.New("etcd down"), errors.New("etcd down")}},
+			opts: func(r *memRegistry) []ServerOption {
+				return []ServerOption{ServerWithRegisterRetry(1, time.Millisecond)}
+			},
+			wantErr:   fmt.Errorf("micro: 注册失败, 重试 1 次: %w", errors.New("etcd down")),
+			wantCalls: []string{"Register", "Register", "Close"},
+		},
+		{
+			name: "hooks",
+			r:    &memRegistry{},
+			opts: func(r *memRegistry) []ServerOption {
+				hook := func(name string) Hook {
+					return func(ctx context.Context) error {
+						r.record(name)
+						return nil
+					}
+				}
+				return []ServerOption{
+					ServerWithOnStart(hook("OnStart")),
+					ServerWithReadinessProbe(hook("Probe")),
+					ServerWithOnRegistered(hook("OnRegistered")),
+					ServerWithOnStop(hook("OnStop")),
+				}
+			},
+			wantCalls: []string{"OnStart", "Probe", "Register", "OnRegistered", "UnRegister", "OnStop", "Close"},
+		},
+		{
+			name: "probe failed",
+			r:    &memRegistry{},
+			opts: func(r *memRegistry) []ServerOption {
+				return []ServerOption{ServerWithReadinessProbe(func(ctx context.Context) error {
+					return errors.New("db not ready")
+				})}
+			},
+			wantErr:   fmt.Errorf("micro: 就绪检查没有通过: %w", errors.New("db not ready")),
+			wantCalls: []string{"Close"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append(tc.opts(tc.r), ServerWithRegistry(tc.r),
+				ServerWithShutdownDelay(0), func(server *Server) {
+					server.registerTimeout = 50 * time.Millisecond
+					server.probeInterval = 10 * time.Millisecond
+				})
+			server, err := NewServer("user-service", opts...)
+			require.NoError(t, err)
+			startErr := make(chan error, 1)
+			go func() {
+				startErr <- server.Start("127.0.0.1:0")
+			}()
+			if tc.wantErr == nil {
+				require.Eventually(t, func() bool {
+					server.mutex.Lock()
+					defer server.mutex.Unlock()
+					return server.si != nil
+				}, time.Second, 10*time.Millisecond)
+				require.NoError(t, server.Close())
+			}
+			select {
+			case err = <-startErr:
+				assert.Equal(t, tc.wantErr, err)
+			case <-time.After(time.Second):
+				t.Fatal("Start 没有返回")
+			}
+			assert.Equal(t, tc.wantCalls, tc.r.calls())
+		})
+	}
+}
+
+func TestServer_StartServeFailed(t *testing.T) {
+	r := &memRegistry{}
+	var server *Server
+	server, err := NewServer("user-service", ServerWithRegistry(r), ServerWithShutdownDelay(0),
+		ServerWithOnStart(func(ctx context.Context) error {
+			// 模拟 Serve 出错退出
+			_ = server.listener.Close()
+			<-server.served
+			return nil
+		}))
+	require.NoError(t, err)
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- server.Start("127.0.0.1:0")
+	}()
+	select {
+	case err = <-startErr:
+		assert.ErrorContains(t, err, "micro: rpc 服务提前退出")
+	case <-time.After(time.Second):
+		t.Fatal("Start 没有返回")
+	}
+	// 没有注册
+	assert.Equal(t, []string{"Close"}, r.calls())
+}
+
+func TestServer_Address(t *testing.T) {
+	testCases := []struct {
+		name     string
+		addr     string
+		opts     []ServerOption
+		wantAddr func(t *testing.T, lis net.Listener, addr string)
+	}{
+		{
+			name: "advertise addr",
+			addr: "127.0.0.1:0",
+			opts: []ServerOption{ServerWithAdvertiseAddr("user-service.prod:8081")},
+			wantAddr: func(t *testing.T, lis net.Listener, addr string) {
+				assert.Equal(t, "user-service.prod:8081", addr)
+			},
+		},
+		{
+			name: "specified host",
+			addr: "127.0.0.1:0",
+			wantAddr: func(t *testing.T, lis net.Listener, addr string) {
+				assert.Equal(t, lis.Addr().String(), addr)
+			},
+		},
+		{
+			name: "unspecified host",
+			addr: ":0",
+			wantAddr: func(t *testing.T, lis net.Listener, addr string) {
+				host, port, err := net.SplitHostPort(addr)
+				require.NoError(t, err)
+				_, wantPort, err := net.SplitHostPort(lis.Addr().String())
+				require.NoError(t, err)
+				assert.Equal(t, wantPort, port)
+				assert.False(t, net.ParseIP(host).IsUnspecified())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name == "unspecified host" {
+				if _, err := hostIP(); err != nil {
+					t.Skip(err)
+				}
+			}
+			server, err := NewServer("user-service", tc.opts...)
+			require.NoError(t, err)
+			server.listener, err = net.Listen("tcp", tc.addr)
+			require.NoError(t, err)
+			defer server.listener.Close()
+			addr, err := server.address()
+			require.NoError(t, err)
+			tc.wantAddr(t, server.listener, addr)
+		})
+	}
+}
+
+func TestServer_Interceptor(t *testing.T) {
+	var called []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			called = append(called, name)
+			return handler(ctx, req)
+		}
+	}
+	server, err := NewServer("user-service", ServerWithHealth(),
+		ServerWithUnaryInterceptor(interceptor("first")),
+		ServerWithUnaryInterceptor(interceptor("second")))
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	cc, err := grpc.Dial(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, called)
+}
+
+func TestServer_ShutdownSignals(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []ServerOption
+
+		wantSignals []os.Signal
+	}{
+		{
+			name:        "default",
+			wantSignals: []os.Signal{syscall.SIGTERM, syscall.SIGINT},
+		},
+		{
+			name:        "custom",
+			opts:        []ServerOption{ServerWithShutdownSignals(syscall.SIGTERM)},
+			wantSignals: []os.Signal{syscall.SIGTERM},
+		},
+		{
+			name: "opt out",
+			opts: []ServerOption{ServerWithoutShutdownSignals()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, err := NewServer("user-service", tc.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSignals, server.signals)
+		})
+	}
+}
+
+func TestServer_Health(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []ServerOption
+		// 用户自己注册的健康检查服务
+		own bool
+
+		wantStatus healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:       "health",
+			opts:       []ServerOption{ServerWithHealth()},
+			wantStatus: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			// 默认不注册, 用户自己注册不会重复
+			name:       "own health server",
+			own:        true,
+			wantStatus: healthpb.HealthCheckResponse_SERVING,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, err := NewServer("user-service", tc.opts...)
+			require.NoError(t, err)
+			if tc.own {
+				hs := health.NewServer()
+				require.NotPanics(t, func() {
+					healthpb.RegisterHealthServer(server, hs)
+				})
+			}
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go func() {
+				_ = server.Serve(lis)
+			}()
+			defer server.Stop()
+
+			cc, err := grpc.Dial(lis.Addr().String(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer cc.Close()
+			resp, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStatus, resp.Status)
+		})
+	}
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	// 先占一个端口拿到地址, 管理端口没法用 :0
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	adminAddr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	reg := prometheus.NewRegistry()
+	builder := &metrics.ServerMetricsBuilder{Registerer: reg}
+	server, err := NewServer("user-service", ServerWithHealth(),
+		ServerWithUnaryInterceptor(builder.Build()),
+		ServerWithMetricsHandler(adminAddr, reg))
+	require.NoError(t, err)
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- server.Start("127.0.0.1:0")
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + adminAddr + "/metrics")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	cc, err := grpc.Dial(server.listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://" + adminAddr + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `server_requests_total{address=`)
+	assert.Contains(t, string(body), `code="OK",method="/grpc.health.v1.Health/Check"} 1`)
+
+	require.NoError(t, server.Close())
+	assert.NoError(t, <-startErr)
+	// 关闭之后管理端口也关了
+	_, err = http.Get("http://" + adminAddr + "/metrics")
+	assert.Error(t, err)
+}
+
+// memRegistry 记录调用顺序的注册中心
+type memRegistry struct {
+	registry.Registry
+	mutex  sync.Mutex
+	called []string
+	// 依次返回的注册结果
+	errs []error
+}
+
+func (m *memRegistry) record(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.called = append(m.called, name)
+}
+
+func (m *memRegistry) calls() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.called...)
+}
+
+func (m *memRegistry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	m.record("Register")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.errs) == 0 {
+		return nil
+	}
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return err
+}
+
+func (m *memRegistry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	m.record("UnRegister")
+	return nil
+}
+
+func (m *memRegistry) Close() error {
+	m.record("Close")
+	return nil
+}